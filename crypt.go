@@ -18,7 +18,9 @@ package maplelib
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/subtle"
 	"fmt"
+	"math/bits"
 )
 
 /*
@@ -36,8 +38,12 @@ import (
    happens.
 */
 type Crypt struct {
-	mapleVersion uint16
+	profile      CryptProfile
+	mapleVersion uint16 // version as it appears in headers
 	key          [16]byte
+	maxLength    int // largest accepted packet body, 0 for the default
+	block        cipher.Block
+	stream       [16]byte // OFB keystream scratch space for aesCrypt
 }
 
 const encryptedHeaderSize = 4
@@ -54,8 +60,33 @@ var aeskey = [32]byte{
 	0x52, 0x00, 0x00, 0x00,
 }
 
-// NewCrypt initializes and returns an encryption key
+// mapleBlock is the AES cipher for aeskey. it's only ever used to encrypt,
+// which is safe to do from several goroutines at once
+var mapleBlock = newMapleBlock()
+
+func newMapleBlock() cipher.Block {
+	block, err := aes.NewCipher(aeskey[:])
+	if err != nil {
+		panic(err) // aeskey is a valid 256-bit key
+	}
+	return block
+}
+
+// AESKey returns a copy of the 32-byte AES user key that is used by maple's
+// packet encryption as well as wz string encryption
+func AESKey() [32]byte {
+	return aeskey
+}
+
+// NewCrypt initializes and returns an encryption key for the given GMS
+// version. Use NewCryptWithProfile for other regions.
 func NewCrypt(key [4]byte, mapleVersion uint16) Crypt {
+	return NewCryptWithProfile(key, gmsProfile(mapleVersion))
+}
+
+// NewCryptWithProfile initializes and returns an encryption key for the
+// given profile, see NewCryptProfile
+func NewCryptWithProfile(key [4]byte, profile CryptProfile) Crypt {
 	var res Crypt
 
 	// Repeats the key 4 times
@@ -63,17 +94,34 @@ func NewCrypt(key [4]byte, mapleVersion uint16) Crypt {
 		copy(res.key[4*i:], key[:])
 	}
 
-	res.mapleVersion = encodeMapleVersion(mapleVersion)
+	res.profile = profile
+	res.mapleVersion = profile.Header.encodeVersion(profile.Version)
+
+	if profile.AESKey == aeskey {
+		res.block = mapleBlock
+	} else {
+		block, err := aes.NewCipher(profile.AESKey[:])
+		if err != nil {
+			panic(err) // can't happen, any 32-byte key is valid
+		}
+		res.block = block
+	}
+
 	return res
 }
 
 func (c Crypt) String() string {
-	return fmt.Sprintf("MapleCrypt[v%d]{% X}", c.MapleVersion(), c.key)
+	return fmt.Sprintf("MapleCrypt[%v]{% X}", c.profile, c.key)
 }
 
 // MapleVersion returns the target MapleStory version for this encryption key
 func (c *Crypt) MapleVersion() uint16 {
-	return decodeMapleVersion(c.mapleVersion)
+	return c.profile.Version
+}
+
+// Profile returns the profile this key was created with
+func (c *Crypt) Profile() CryptProfile {
+	return c.profile
 }
 
 // IV returns the current initialization vector of this key (16 bytes)
@@ -81,12 +129,15 @@ func (c *Crypt) IV() []byte {
 	return c.key[:]
 }
 
-// Encrypt encrypts the given array of bytes.
+// Encrypt encrypts the given array of bytes with maple's custom cipher, if
+// the profile uses it, followed by AES.
 // NOTE: the array must have 4 bytes of space at the beginning for the encrypted
 // header
 func (c *Crypt) Encrypt(buffer []byte) {
 	c.makeHeader(buffer)
-	mapleCrypt(buffer[encryptedHeaderSize:])
+	if c.profile.Shanda {
+		mapleCrypt(buffer[encryptedHeaderSize:])
+	}
 	c.aesCrypt(buffer[encryptedHeaderSize:])
 }
 
@@ -98,11 +149,13 @@ func (c *Crypt) EncryptNoShanda(buffer []byte) {
 	c.aesCrypt(buffer[encryptedHeaderSize:])
 }
 
-// Decrypt decrypts the given array of bytes.
+// Decrypt decrypts the given array of bytes, undoing Encrypt.
 // NOTE: you must omit the first 4 bytes (encrypted header)
 func (c *Crypt) Decrypt(buffer []byte) {
 	c.aesCrypt(buffer[:])
-	mapleDecrypt(buffer[:])
+	if c.profile.Shanda {
+		mapleDecrypt(buffer[:])
+	}
 }
 
 // DecryptNoShanda decrypts the given array of bytes only using maple's AES.
@@ -136,18 +189,136 @@ func GetPacketLength(encryptedHeader []byte) int {
 		(uint16(encryptedHeader[2]) + uint16(encryptedHeader[3])*0x100))
 }
 
-func encodeMapleVersion(mapleVersion uint16) (res uint16) {
-	// I dunno why they do this, looks like some kind of way to encrypt the
-	// game version
-	res = 0xFFFF - mapleVersion
-	res = (res >> 8 & 0xFF) | (res << 8 & 0xFF00)
-	return
+// DefaultMaxPacketLength is the largest packet body CheckHeader accepts and
+// Session.WritePacket sends unless a lower limit is set with
+// SetMaxPacketLength. It's the most the encrypted header can hold.
+const DefaultMaxPacketLength = 0xFFFF
+
+// maxPlausibleVersion is the highest version a header that doesn't match
+// is considered to be sent by a client of a different version rather than
+// a desynced IV
+const maxPlausibleVersion = 1000
+
+// An IVMismatchError is returned by CheckHeader when the header was not
+// encrypted with the current IV, which usually means that the keys are out
+// of sync
+type IVMismatchError struct {
+	Expected [2]byte // IV bytes expected in the header
+	Got      [2]byte // IV bytes found in the header for the expected version
+}
+
+func (e IVMismatchError) Error() string {
+	return fmt.Sprintf("Packet header IV mismatch: got % X, expected % X",
+		e.Got, e.Expected)
+}
+
+// A VersionMismatchError is returned by CheckHeader when the header was
+// encrypted with the current IV but for a different MapleStory version.
+// This is a guess: the header only holds the version xored with the IV, so
+// a desynced IV also gives a version mismatch whenever the decoded version
+// happens to be between 1 and 1000, which is about 1.5% of the time.
+type VersionMismatchError struct {
+	Expected uint16
+	Got      uint16
+}
+
+func (e VersionMismatchError) Error() string {
+	return fmt.Sprintf("Packet header version mismatch: got v%d, expected v%d",
+		e.Got, e.Expected)
+}
+
+// A PacketTooLongError is returned by CheckHeader when the header claims a
+// body longer than the maximum packet length and by Session.WritePacket for
+// packets longer than that
+type PacketTooLongError struct {
+	Length int
+	Max    int
+}
+
+func (e PacketTooLongError) Error() string {
+	return fmt.Sprintf("Packet is too long (%d bytes, max %d)", e.Length,
+		e.Max)
+}
+
+// SetMaxPacketLength sets the largest packet body CheckHeader accepts.
+// Zero or less restores DefaultMaxPacketLength, higher limits are lowered
+// to it because the encrypted header can't hold longer packets.
+func (c *Crypt) SetMaxPacketLength(n int) {
+	c.maxLength = n
+}
+
+// MaxPacketLength returns the largest packet body CheckHeader accepts
+func (c *Crypt) MaxPacketLength() int {
+	switch {
+	case c.maxLength <= 0:
+		return DefaultMaxPacketLength
+	case c.maxLength > DefaultMaxPacketLength:
+		return DefaultMaxPacketLength
+	}
+	return c.maxLength
+}
+
+// CheckHeader verifies that an encrypted header was made with the current
+// IV of this key and its MapleStory version, then returns the length of the
+// packet body like GetPacketLength.
+// A header that decodes to a different version with the current IV is
+// reported as a VersionMismatchError if the version is plausible (see its
+// documentation for false positives), otherwise it's reported as an
+// IVMismatchError. Bodies longer than MaxPacketLength
+// are reported as a PacketTooLongError.
+func (c *Crypt) CheckHeader(header []byte) (length int, err error) {
+	if len(header) < encryptedHeaderSize {
+		return 0, EndOfPacketError{encryptedHeaderSize, len(header)}
+	}
+
+	iv := uint16(c.key[2])<<8 | uint16(c.key[3])
+	got := (uint16(header[0])<<8 | uint16(header[1])) ^ iv
+
+	if got != c.mapleVersion {
+		version := c.profile.Header.decodeVersion(got)
+		if version != 0 && version <= maxPlausibleVersion {
+			return 0, VersionMismatchError{c.MapleVersion(), version}
+		}
+
+		gotiv := uint16(header[0])<<8 | uint16(header[1])
+		gotiv ^= c.mapleVersion
+		return 0, IVMismatchError{
+			Expected: [2]byte{c.key[2], c.key[3]},
+			Got:      [2]byte{byte(gotiv >> 8), byte(gotiv)},
+		}
+	}
+
+	length = GetPacketLength(header)
+	if length > c.MaxPacketLength() {
+		return 0, PacketTooLongError{length, c.MaxPacketLength()}
+	}
+	return length, nil
 }
 
-func decodeMapleVersion(mapleVersion uint16) (res uint16) {
-	res = (mapleVersion >> 8 & 0xFF) | (mapleVersion << 8 & 0xFF00)
-	res = -(res - 0xFFFF)
-	return
+// im12andwhatisthis is the substitution table used by Shuffle
+var im12andwhatisthis = [256]byte{
+	0xEC, 0x3F, 0x77, 0xA4, 0x45, 0xD0, 0x71, 0xBF, 0xB7, 0x98, 0x20, 0xFC,
+	0x4B, 0xE9, 0xB3, 0xE1, 0x5C, 0x22, 0xF7, 0x0C, 0x44, 0x1B, 0x81, 0xBD,
+	0x63, 0x8D, 0xD4, 0xC3, 0xF2, 0x10, 0x19, 0xE0, 0xFB, 0xA1, 0x6E, 0x66,
+	0xEA, 0xAE, 0xD6, 0xCE, 0x06, 0x18, 0x4E, 0xEB, 0x78, 0x95, 0xDB, 0xBA,
+	0xB6, 0x42, 0x7A, 0x2A, 0x83, 0x0B, 0x54, 0x67, 0x6D, 0xE8, 0x65, 0xE7,
+	0x2F, 0x07, 0xF3, 0xAA, 0x27, 0x7B, 0x85, 0xB0, 0x26, 0xFD, 0x8B, 0xA9,
+	0xFA, 0xBE, 0xA8, 0xD7, 0xCB, 0xCC, 0x92, 0xDA, 0xF9, 0x93, 0x60, 0x2D,
+	0xDD, 0xD2, 0xA2, 0x9B, 0x39, 0x5F, 0x82, 0x21, 0x4C, 0x69, 0xF8, 0x31,
+	0x87, 0xEE, 0x8E, 0xAD, 0x8C, 0x6A, 0xBC, 0xB5, 0x6B, 0x59, 0x13, 0xF1,
+	0x04, 0x00, 0xF6, 0x5A, 0x35, 0x79, 0x48, 0x8F, 0x15, 0xCD, 0x97, 0x57,
+	0x12, 0x3E, 0x37, 0xFF, 0x9D, 0x4F, 0x51, 0xF5, 0xA3, 0x70, 0xBB, 0x14,
+	0x75, 0xC2, 0xB8, 0x72, 0xC0, 0xED, 0x7D, 0x68, 0xC9, 0x2E, 0x0D, 0x62,
+	0x46, 0x17, 0x11, 0x4D, 0x6C, 0xC4, 0x7E, 0x53, 0xC1, 0x25, 0xC7, 0x9A,
+	0x1C, 0x88, 0x58, 0x2C, 0x89, 0xDC, 0x02, 0x64, 0x40, 0x01, 0x5D, 0x38,
+	0xA5, 0xE2, 0xAF, 0x55, 0xD5, 0xEF, 0x1A, 0x7C, 0xA7, 0x5B, 0xA6, 0x6F,
+	0x86, 0x9F, 0x73, 0xE6, 0x0A, 0xDE, 0x2B, 0x99, 0x4A, 0x47, 0x9C, 0xDF,
+	0x09, 0x76, 0x9E, 0x30, 0x0E, 0xE4, 0xB2, 0x94, 0xA0, 0x3B, 0x34, 0x1D,
+	0x28, 0x0F, 0x36, 0xE3, 0x23, 0xB4, 0x03, 0xD8, 0x90, 0xC8, 0x3C, 0xFE,
+	0x5E, 0x32, 0x24, 0x50, 0x1F, 0x3A, 0x43, 0x8A, 0x96, 0x41, 0x74, 0xAC,
+	0x52, 0x33, 0xF0, 0xD9, 0x29, 0x80, 0xB1, 0x16, 0xD3, 0xAB, 0x91, 0xB9,
+	0x84, 0x7F, 0x61, 0x1E, 0xCF, 0xC5, 0xD1, 0x56, 0x3D, 0xCA, 0xF4, 0x05,
+	0xC6, 0xE5, 0x08, 0x49,
 }
 
 // Shuffle shuffles the current key after an encryption or decryption
@@ -155,42 +326,22 @@ func (c *Crypt) Shuffle() {
 	// I have no idea what I'm doing
 	// this encryption shit was reversed from the game itself
 	// credits to vana for the key shuffle code
-	var im12andwhatisthis = [256]byte{
-		0xEC, 0x3F, 0x77, 0xA4, 0x45, 0xD0, 0x71, 0xBF, 0xB7, 0x98, 0x20, 0xFC,
-		0x4B, 0xE9, 0xB3, 0xE1, 0x5C, 0x22, 0xF7, 0x0C, 0x44, 0x1B, 0x81, 0xBD,
-		0x63, 0x8D, 0xD4, 0xC3, 0xF2, 0x10, 0x19, 0xE0, 0xFB, 0xA1, 0x6E, 0x66,
-		0xEA, 0xAE, 0xD6, 0xCE, 0x06, 0x18, 0x4E, 0xEB, 0x78, 0x95, 0xDB, 0xBA,
-		0xB6, 0x42, 0x7A, 0x2A, 0x83, 0x0B, 0x54, 0x67, 0x6D, 0xE8, 0x65, 0xE7,
-		0x2F, 0x07, 0xF3, 0xAA, 0x27, 0x7B, 0x85, 0xB0, 0x26, 0xFD, 0x8B, 0xA9,
-		0xFA, 0xBE, 0xA8, 0xD7, 0xCB, 0xCC, 0x92, 0xDA, 0xF9, 0x93, 0x60, 0x2D,
-		0xDD, 0xD2, 0xA2, 0x9B, 0x39, 0x5F, 0x82, 0x21, 0x4C, 0x69, 0xF8, 0x31,
-		0x87, 0xEE, 0x8E, 0xAD, 0x8C, 0x6A, 0xBC, 0xB5, 0x6B, 0x59, 0x13, 0xF1,
-		0x04, 0x00, 0xF6, 0x5A, 0x35, 0x79, 0x48, 0x8F, 0x15, 0xCD, 0x97, 0x57,
-		0x12, 0x3E, 0x37, 0xFF, 0x9D, 0x4F, 0x51, 0xF5, 0xA3, 0x70, 0xBB, 0x14,
-		0x75, 0xC2, 0xB8, 0x72, 0xC0, 0xED, 0x7D, 0x68, 0xC9, 0x2E, 0x0D, 0x62,
-		0x46, 0x17, 0x11, 0x4D, 0x6C, 0xC4, 0x7E, 0x53, 0xC1, 0x25, 0xC7, 0x9A,
-		0x1C, 0x88, 0x58, 0x2C, 0x89, 0xDC, 0x02, 0x64, 0x40, 0x01, 0x5D, 0x38,
-		0xA5, 0xE2, 0xAF, 0x55, 0xD5, 0xEF, 0x1A, 0x7C, 0xA7, 0x5B, 0xA6, 0x6F,
-		0x86, 0x9F, 0x73, 0xE6, 0x0A, 0xDE, 0x2B, 0x99, 0x4A, 0x47, 0x9C, 0xDF,
-		0x09, 0x76, 0x9E, 0x30, 0x0E, 0xE4, 0xB2, 0x94, 0xA0, 0x3B, 0x34, 0x1D,
-		0x28, 0x0F, 0x36, 0xE3, 0x23, 0xB4, 0x03, 0xD8, 0x90, 0xC8, 0x3C, 0xFE,
-		0x5E, 0x32, 0x24, 0x50, 0x1F, 0x3A, 0x43, 0x8A, 0x96, 0x41, 0x74, 0xAC,
-		0x52, 0x33, 0xF0, 0xD9, 0x29, 0x80, 0xB1, 0x16, 0xD3, 0xAB, 0x91, 0xB9,
-		0x84, 0x7F, 0x61, 0x1E, 0xCF, 0xC5, 0xD1, 0x56, 0x3D, 0xCA, 0xF4, 0x05,
-		0xC6, 0xE5, 0x08, 0x49,
-	}
-
 	newiv := [4]byte{0xF2, 0x53, 0x50, 0xC6}
 	var input, valueinput byte
 	var fulliv, shift uint32
 
+	table := c.profile.ShuffleTable
+	if table == nil {
+		table = &im12andwhatisthis
+	}
+
 	for i := byte(0); i < 4; i++ {
 		input = c.key[i]
-		valueinput = im12andwhatisthis[input]
+		valueinput = table[input]
 
-		newiv[0] += im12andwhatisthis[newiv[1]] - input
+		newiv[0] += table[newiv[1]] - input
 		newiv[1] -= newiv[2] ^ valueinput
-		newiv[2] ^= im12andwhatisthis[newiv[3]] + input
+		newiv[2] ^= table[newiv[3]] + input
 		newiv[3] -= newiv[0] - valueinput
 
 		fulliv = uint32(newiv[3])<<24 | uint32(newiv[2])<<16 |
@@ -208,58 +359,23 @@ func (c *Crypt) Shuffle() {
 	}
 }
 
-func ror(val byte, num int) byte {
-	for i := 0; i < num; i++ {
-		var lowbit int
-
-		if val&1 > 0 {
-			lowbit = 1
-		} else {
-			lowbit = 0
-		}
-
-		val >>= 1
-		val |= byte(lowbit << 7)
-	}
-
-	return val
-}
-
-func rol(val byte, num int) byte {
-	var highbit int
-
-	for i := 0; i < num; i++ {
-		if val&0x80 > 0 {
-			highbit = 1
-		} else {
-			highbit = 0
-		}
-
-		val <<= 1
-		val |= byte(highbit)
-	}
-
-	return val
-}
-
 func mapleDecrypt(buf []byte) {
 	// I have no idea what I'm doing
 	// this encryption shit was reversed from the game itself
-	var j int32
 	var a, b, c byte
+	n := len(buf)
 
-	for i := byte(0); i < 3; i++ {
+	for i := 0; i < 3; i++ {
 		a = 0
 		b = 0
 
-		for j = int32(len(buf)); j > 0; j-- {
-			c = buf[j-1]
-			c = rol(c, 3)
+		for j := n; j > 0; j-- {
+			c = bits.RotateLeft8(buf[j-1], 3)
 			c ^= 0x13
 			a = c
 			c ^= b
-			c = byte(int32(c) - j)
-			c = ror(c, 4)
+			c -= byte(j)
+			c = bits.RotateLeft8(c, -4)
 			b = a
 			buf[j-1] = c
 		}
@@ -267,17 +383,17 @@ func mapleDecrypt(buf []byte) {
 		a = 0
 		b = 0
 
-		for j = int32(len(buf)); j > 0; j-- {
-			c = buf[int32(len(buf))-j]
+		for j := n; j > 0; j-- {
+			c = buf[n-j]
 			c -= 0x48
 			c ^= 0xFF
-			c = rol(c, int(j))
+			c = bits.RotateLeft8(c, j&7)
 			a = c
 			c ^= b
-			c = byte(int32(c) - j)
-			c = ror(c, 3)
+			c -= byte(j)
+			c = bits.RotateLeft8(c, -3)
 			b = a
-			buf[int32(len(buf))-j] = c
+			buf[n-j] = c
 		}
 	}
 }
@@ -285,70 +401,70 @@ func mapleDecrypt(buf []byte) {
 func mapleCrypt(buf []byte) {
 	// I have no idea what I'm doing
 	// this encryption shit was reversed from the game itself
-	var j int32
 	var a, c byte
+	n := len(buf)
 
-	for i := byte(0); i < 3; i++ {
+	for i := 0; i < 3; i++ {
 		a = 0
 
-		for j = int32(len(buf)); j > 0; j-- {
-			c = buf[int32(len(buf))-j]
-			c = rol(c, 3)
-			c = byte(int32(c) + j)
+		for j := n; j > 0; j-- {
+			c = bits.RotateLeft8(buf[n-j], 3)
+			c += byte(j)
 			c ^= a
 			a = c
-			c = ror(a, int(j))
+			c = bits.RotateLeft8(a, -(j & 7))
 			c ^= 0xFF
 			c += 0x48
-			buf[int32(len(buf))-j] = c
+			buf[n-j] = c
 		}
 
 		a = 0
 
-		for j = int32(len(buf)); j > 0; j-- {
-			c = buf[j-1]
-			c = rol(c, 4)
-			c = byte(int32(c) + j)
+		for j := n; j > 0; j-- {
+			c = bits.RotateLeft8(buf[j-1], 4)
+			c += byte(j)
 			c ^= a
 			a = c
 			c ^= 0x13
-			c = ror(c, 3)
+			c = bits.RotateLeft8(c, -3)
 			buf[j-1] = c
 		}
 	}
 }
 
 func (c *Crypt) aesCrypt(buf []byte) {
-	var pos, tpos, cbwrite, cb int32 = 0, 0, 0, int32(len(buf))
-	var first byte = 1
-
-	cb = int32(len(buf))
-
-	// I'm not 100% sure what this exactly does but apparently maple
-	// decrypts packets in blocks of 1460 bytes to work around
-	// packet limitations or something
+	block := c.block
+	if block == nil {
+		block = mapleBlock
+	}
 
-	for cb > pos {
-		tpos = blocksize - int32(first*4)
+	// maple encrypts packets in blocks of 1460 bytes, restarting OFB with
+	// the same IV for each block. the first block is 4 bytes shorter to
+	// make room for the header
+	n := blocksize - encryptedHeaderSize
 
-		if cb > pos+tpos {
-			cbwrite = tpos
-		} else {
-			cbwrite = cb - pos
+	for pos := 0; pos < len(buf); pos += n {
+		if pos != 0 {
+			n = blocksize
 		}
 
-		block, err := aes.NewCipher(aeskey[:])
-		if err != nil {
-			panic(err) // cbf to handle this unlikely error
+		end := pos + n
+		if end > len(buf) {
+			end = len(buf)
 		}
 
-		stream := cipher.NewOFB(block, c.key[:])
-		stream.XORKeyStream(buf[pos:pos+cbwrite], buf[pos:pos+cbwrite])
+		c.ofb(block, buf[pos:end])
+	}
+}
 
-		pos += tpos
+// ofb xors buf with the OFB keystream of block starting from the current IV.
+// the keystream goes through c.stream so that it doesn't escape to the heap
+// on every call
+func (c *Crypt) ofb(block cipher.Block, buf []byte) {
+	c.stream = c.key
 
-		if first == 1 {
-			first = 0
-		}
+	for len(buf) > 0 {
+		block.Encrypt(c.stream[:], c.stream[:])
+		buf = buf[subtle.XORBytes(buf, buf, c.stream[:]):]
 	}
 }
@@ -17,9 +17,15 @@ package maplelib
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
 	"testing"
 )
 
+var testProfile = gmsProfile(62)
+var testCryptIV = [4]byte{0xFE, 0xCA, 0xDD, 0xBA}
+
 func TestEncryption(t *testing.T) {
 	packet := NewPacket()
 	packet.Encode4(0x00000000) // placeholder for the encrypted header
@@ -56,3 +62,262 @@ func TestShuffle(t *testing.T) {
 		t.Errorf("nextiv = % X, expected % X", crypt.IV()[:4], nextiv[:])
 	}
 }
+
+func TestCheckHeader(t *testing.T) {
+	iv := [4]byte{0xFE, 0xCA, 0xDD, 0xBA}
+	packet := make([]byte, 4+300)
+	sender := NewCryptWithProfile(iv, testProfile)
+	sender.Encrypt(packet)
+
+	recv := NewCryptWithProfile(iv, testProfile)
+	length, err := recv.CheckHeader(packet)
+	if err != nil || length != 300 {
+		t.Errorf("CheckHeader = %d, %v, expected 300", length, err)
+	}
+
+	wrongVersion := NewCrypt(iv, 83)
+	_, err = wrongVersion.CheckHeader(packet)
+	if e, ok := err.(VersionMismatchError); !ok || e.Got != 62 ||
+		e.Expected != 83 {
+
+		t.Errorf("wrong version: err = %v", err)
+	}
+
+	shuffled := NewCryptWithProfile(iv, testProfile)
+	shuffled.Shuffle()
+	_, err = shuffled.CheckHeader(packet)
+	if e, ok := err.(IVMismatchError); !ok || e.Got != [2]byte{0xDD, 0xBA} {
+		t.Errorf("desynced iv: err = %v", err)
+	}
+
+	recv.SetMaxPacketLength(200)
+	_, err = recv.CheckHeader(packet)
+	if e, ok := err.(PacketTooLongError); !ok || e.Length != 300 ||
+		e.Max != 200 {
+
+		t.Errorf("max length: err = %v", err)
+	}
+	recv.SetMaxPacketLength(0x20000)
+	if recv.MaxPacketLength() != 0xFFFF {
+		t.Errorf("max length above the header limit = %d",
+			recv.MaxPacketLength())
+	}
+	recv.SetMaxPacketLength(0)
+	if recv.MaxPacketLength() != DefaultMaxPacketLength {
+		t.Errorf("default max length = %d", recv.MaxPacketLength())
+	}
+
+	if _, err = recv.CheckHeader(packet[:3]); err == nil {
+		t.Error("short header: expected an error")
+	}
+}
+
+// the reference implementation below is the original bit-by-bit version of
+// the packet encryption, kept to check that the optimized one produces the
+// same output
+
+func refRor(val byte, num int) byte {
+	for i := 0; i < num; i++ {
+		val = val>>1 | val<<7
+	}
+	return val
+}
+
+func refRol(val byte, num int) byte {
+	for i := 0; i < num; i++ {
+		val = val<<1 | val>>7
+	}
+	return val
+}
+
+func refMapleCrypt(buf []byte) {
+	var j int32
+	var a, c byte
+
+	for i := 0; i < 3; i++ {
+		a = 0
+		for j = int32(len(buf)); j > 0; j-- {
+			c = refRol(buf[int32(len(buf))-j], 3)
+			c = byte(int32(c) + j)
+			c ^= a
+			a = c
+			c = refRor(a, int(j))
+			c ^= 0xFF
+			c += 0x48
+			buf[int32(len(buf))-j] = c
+		}
+
+		a = 0
+		for j = int32(len(buf)); j > 0; j-- {
+			c = refRol(buf[j-1], 4)
+			c = byte(int32(c) + j)
+			c ^= a
+			a = c
+			c ^= 0x13
+			c = refRor(c, 3)
+			buf[j-1] = c
+		}
+	}
+}
+
+func refMapleDecrypt(buf []byte) {
+	var j int32
+	var a, b, c byte
+
+	for i := 0; i < 3; i++ {
+		a, b = 0, 0
+		for j = int32(len(buf)); j > 0; j-- {
+			c = refRol(buf[j-1], 3)
+			c ^= 0x13
+			a = c
+			c ^= b
+			c = byte(int32(c) - j)
+			c = refRor(c, 4)
+			b = a
+			buf[j-1] = c
+		}
+
+		a, b = 0, 0
+		for j = int32(len(buf)); j > 0; j-- {
+			c = buf[int32(len(buf))-j]
+			c -= 0x48
+			c ^= 0xFF
+			c = refRol(c, int(j))
+			a = c
+			c ^= b
+			c = byte(int32(c) - j)
+			c = refRor(c, 3)
+			b = a
+			buf[int32(len(buf))-j] = c
+		}
+	}
+}
+
+func refAesCrypt(iv []byte, buf []byte) {
+	var pos, tpos, cbwrite, cb int32 = 0, 0, 0, int32(len(buf))
+	first := int32(1)
+
+	for cb > pos {
+		tpos = blocksize - first*4
+		if cb > pos+tpos {
+			cbwrite = tpos
+		} else {
+			cbwrite = cb - pos
+		}
+
+		block, err := aes.NewCipher(aeskey[:])
+		if err != nil {
+			panic(err)
+		}
+
+		stream := cipher.NewOFB(block, iv)
+		stream.XORKeyStream(buf[pos:pos+cbwrite], buf[pos:pos+cbwrite])
+		pos += tpos
+		first = 0
+	}
+}
+
+// the reference rotates one bit at a time, up to len(buf) times per byte,
+// so the sizes are kept small enough for it
+var testCryptSizes = []int{0, 1, 2, 7, 16, 100, 1455, 1456, 1457, 2916,
+	2917, 3000}
+
+func testCryptBody(n int) []byte {
+	body := make([]byte, n)
+	for i := range body {
+		body[i] = byte(i*31 + i>>8)
+	}
+	return body
+}
+
+func TestCryptReference(t *testing.T) {
+	iv := [4]byte{0xFE, 0xCA, 0xDD, 0xBA}
+
+	for _, n := range testCryptSizes {
+		crypt := NewCryptWithProfile(iv, testProfile)
+		body := testCryptBody(n)
+
+		packet := make([]byte, 4+n)
+		copy(packet[4:], body)
+		crypt.Encrypt(packet)
+
+		expected := append([]byte(nil), body...)
+		refMapleCrypt(expected)
+		refAesCrypt(crypt.IV(), expected)
+		if !bytes.Equal(packet[4:], expected) {
+			t.Errorf("%d bytes: encrypted body differs from the reference",
+				n)
+			continue
+		}
+
+		crypt.Decrypt(packet[4:])
+		refAesCrypt(crypt.IV(), expected)
+		refMapleDecrypt(expected)
+		if !bytes.Equal(packet[4:], expected) ||
+			!bytes.Equal(packet[4:], body) {
+
+			t.Errorf("%d bytes: decrypted body differs from the reference",
+				n)
+		}
+	}
+}
+
+func TestCryptAllocs(t *testing.T) {
+	crypt := NewCryptWithProfile(testCryptIV, testProfile)
+	packet := make([]byte, 4+3000)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		crypt.Encrypt(packet)
+		crypt.Decrypt(packet[4:])
+	})
+	if allocs != 0 {
+		t.Errorf("Encrypt and Decrypt allocate %v times per call", allocs)
+	}
+}
+
+var benchCryptSizes = []int{16, 128, 1456, 4096, 0xFFFF}
+
+func BenchmarkEncrypt(b *testing.B) {
+	for _, n := range benchCryptSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			crypt := NewCryptWithProfile(testCryptIV, testProfile)
+			packet := make([]byte, 4+n)
+			b.SetBytes(int64(n))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				crypt.Encrypt(packet)
+			}
+		})
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	for _, n := range benchCryptSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			crypt := NewCryptWithProfile(testCryptIV, testProfile)
+			packet := make([]byte, n)
+			b.SetBytes(int64(n))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				crypt.Decrypt(packet)
+			}
+		})
+	}
+}
+
+// BenchmarkEncryptReference measures the original implementation for
+// comparison with BenchmarkEncrypt
+func BenchmarkEncryptReference(b *testing.B) {
+	for _, n := range benchCryptSizes[:3] {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			crypt := NewCryptWithProfile(testCryptIV, testProfile)
+			packet := make([]byte, n)
+			b.SetBytes(int64(n))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				refMapleCrypt(packet)
+				refAesCrypt(crypt.IV(), packet)
+			}
+		})
+	}
+}
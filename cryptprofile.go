@@ -0,0 +1,190 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package maplelib
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A Region is a MapleStory locale with its own packet encryption quirks
+type Region byte
+
+// Regions with a built-in CryptProfile
+const (
+	GMS  Region = iota // global maplestory
+	KMS                // korean maplestory
+	JMS                // japanese maplestory
+	CMS                // chinese maplestory
+	MSEA               // south east asian maplestory
+	EMS                // european maplestory
+	BMS                // brazilian maplestory
+)
+
+var regionNames = map[Region]string{
+	GMS:  "GMS",
+	KMS:  "KMS",
+	JMS:  "JMS",
+	CMS:  "CMS",
+	MSEA: "MSEA",
+	EMS:  "EMS",
+	BMS:  "BMS",
+}
+
+func (r Region) String() string {
+	if name, ok := regionNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Region(%d)", byte(r))
+}
+
+// A HeaderMode is the transform applied to the MapleStory version before
+// it's xored into the encrypted packet header
+type HeaderMode byte
+
+// Header modes
+const (
+	// HeaderInverted stores 0xFFFF minus the version, byte-swapped
+	HeaderInverted HeaderMode = iota
+	// HeaderPlain stores the version byte-swapped
+	HeaderPlain
+)
+
+// A CryptProfile describes how a region and version encrypt packets.
+// The built-in profiles returned by NewCryptProfile can be modified before
+// they're passed to NewCryptWithProfile.
+type CryptProfile struct {
+	Region  Region
+	Version uint16
+	AESKey  [32]byte
+	Shanda  bool       // apply maple's custom cipher before AES
+	Header  HeaderMode // version transform used in packet headers
+
+	// ShuffleTable is the substitution table used to shuffle the IV after
+	// each packet. nil means the default table.
+	ShuffleTable *[256]byte
+}
+
+// cryptProfiles holds the built-in profiles without a version and AES key
+var cryptProfiles = map[Region]CryptProfile{
+	GMS:  {Region: GMS, Shanda: true},
+	KMS:  {Region: KMS, Header: HeaderPlain},
+	JMS:  {Region: JMS, Header: HeaderPlain},
+	CMS:  {Region: CMS, Shanda: true},
+	MSEA: {Region: MSEA, Shanda: true},
+	EMS:  {Region: EMS, Shanda: true},
+	BMS:  {Region: BMS, Shanda: true},
+}
+
+// An aesKeyChange is an AES key a region uses from a version on
+type aesKeyChange struct {
+	version uint16
+	key     [32]byte
+}
+
+// aesKeys holds the AES keys of every region sorted by version. Every
+// region starts with the key returned by AESKey.
+var (
+	aesKeysMutex sync.Mutex
+	aesKeys      = map[Region][]aesKeyChange{
+		GMS:  {{0, aeskey}},
+		KMS:  {{0, aeskey}},
+		JMS:  {{0, aeskey}},
+		CMS:  {{0, aeskey}},
+		MSEA: {{0, aeskey}},
+		EMS:  {{0, aeskey}},
+		BMS:  {{0, aeskey}},
+	}
+)
+
+// SetAESKey makes the profiles of a region use key from the given version
+// on, until the next version that has its own key. Newer GMS versions rotate
+// the AES key, so their keys must be set before creating their profiles.
+func SetAESKey(region Region, version uint16, key [32]byte) error {
+	if _, ok := cryptProfiles[region]; !ok {
+		return fmt.Errorf("Unknown region %v", region)
+	}
+
+	aesKeysMutex.Lock()
+	defer aesKeysMutex.Unlock()
+
+	keys := aesKeys[region]
+	i := sort.Search(len(keys), func(i int) bool {
+		return keys[i].version >= version
+	})
+	if i < len(keys) && keys[i].version == version {
+		keys[i].key = key
+		return nil
+	}
+	keys = append(keys, aesKeyChange{})
+	copy(keys[i+1:], keys[i:])
+	keys[i] = aesKeyChange{version, key}
+	aesKeys[region] = keys
+	return nil
+}
+
+// versionAESKey returns the AES key a region uses for the given version
+func versionAESKey(region Region, version uint16) [32]byte {
+	aesKeysMutex.Lock()
+	defer aesKeysMutex.Unlock()
+
+	keys := aesKeys[region]
+	i := sort.Search(len(keys), func(i int) bool {
+		return keys[i].version > version
+	})
+	return keys[i-1].key
+}
+
+// NewCryptProfile returns the built-in profile for the given region and
+// version with the AES key set for that version, see SetAESKey.
+// Unknown regions give an error.
+func NewCryptProfile(region Region, version uint16) (CryptProfile, error) {
+	p, ok := cryptProfiles[region]
+	if !ok {
+		return p, fmt.Errorf("Unknown region %v", region)
+	}
+	p.Version = version
+	p.AESKey = versionAESKey(region, version)
+	return p, nil
+}
+
+// gmsProfile returns the GMS profile for the given version
+func gmsProfile(version uint16) CryptProfile {
+	p, _ := NewCryptProfile(GMS, version)
+	return p
+}
+
+func (p CryptProfile) String() string {
+	return fmt.Sprintf("%v v%d", p.Region, p.Version)
+}
+
+// encodeVersion applies the header transform to version
+func (m HeaderMode) encodeVersion(version uint16) uint16 {
+	if m == HeaderInverted {
+		version = 0xFFFF - version
+	}
+	return version>>8 | version<<8
+}
+
+// decodeVersion undoes encodeVersion
+func (m HeaderMode) decodeVersion(encoded uint16) uint16 {
+	version := encoded>>8 | encoded<<8
+	if m == HeaderInverted {
+		version = 0xFFFF - version
+	}
+	return version
+}
@@ -0,0 +1,194 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package maplelib
+
+import (
+	"bytes"
+	"testing"
+)
+
+// regionProfile returns the built-in profile of a region or fails the test
+func regionProfile(t *testing.T, region Region, version uint16) CryptProfile {
+	p, err := NewCryptProfile(region, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCryptProfileRoundTrip(t *testing.T) {
+	regions := []Region{GMS, KMS, JMS, CMS, MSEA, EMS, BMS}
+	body := testCryptBody(3000)
+
+	for _, region := range regions {
+		p := regionProfile(t, region, 95)
+		send := NewCryptWithProfile(testCryptIV, p)
+		recv := NewCryptWithProfile(testCryptIV, p)
+
+		packet := make([]byte, 4+len(body))
+		copy(packet[4:], body)
+		send.Encrypt(packet)
+
+		length, err := recv.CheckHeader(packet)
+		if err != nil || length != len(body) {
+			t.Errorf("%v: CheckHeader = %d, %v", p, length, err)
+			continue
+		}
+
+		recv.Decrypt(packet[4:])
+		if !bytes.Equal(packet[4:], body) {
+			t.Errorf("%v: decrypted packet doesn't match", p)
+		}
+	}
+}
+
+func TestCryptProfileUnknownRegion(t *testing.T) {
+	if _, err := NewCryptProfile(Region(10), 62); err == nil {
+		t.Error("NewCryptProfile accepted an unknown region")
+	}
+	if err := SetAESKey(Region(10), 62, aeskey); err == nil {
+		t.Error("SetAESKey accepted an unknown region")
+	}
+}
+
+func TestNewCryptDefaultsToGMS(t *testing.T) {
+	body := testCryptBody(100)
+	a := NewCrypt(testCryptIV, 62)
+	b := NewCryptWithProfile(testCryptIV, regionProfile(t, GMS, 62))
+
+	pa, pb := make([]byte, 4+len(body)), make([]byte, 4+len(body))
+	copy(pa[4:], body)
+	copy(pb[4:], body)
+	a.Encrypt(pa)
+	b.Encrypt(pb)
+	if !bytes.Equal(pa, pb) {
+		t.Error("NewCrypt doesn't match the GMS profile")
+	}
+}
+
+func TestSetAESKey(t *testing.T) {
+	// MSEA isn't used by the other tests, so changing its keys is safe
+	defer func(keys []aesKeyChange) {
+		aesKeysMutex.Lock()
+		aesKeys[MSEA] = keys
+		aesKeysMutex.Unlock()
+	}(aesKeys[MSEA])
+
+	var key150, key200 [32]byte
+	key150[0], key200[0] = 150, 200
+	if err := SetAESKey(MSEA, 200, key200); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAESKey(MSEA, 150, key150); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version uint16
+		key     [32]byte
+	}{
+		{62, aeskey},
+		{149, aeskey},
+		{150, key150},
+		{199, key150},
+		{200, key200},
+		{0xFFFF, key200},
+	}
+	for _, test := range tests {
+		if p := regionProfile(t, MSEA, test.version); p.AESKey != test.key {
+			t.Errorf("v%d: AESKey = % X, expected % X", test.version,
+				p.AESKey[:4], test.key[:4])
+		}
+	}
+
+	// replacing a key keeps the other versions
+	key200[1] = 1
+	if err := SetAESKey(MSEA, 200, key200); err != nil {
+		t.Fatal(err)
+	}
+	if p := regionProfile(t, MSEA, 250); p.AESKey != key200 {
+		t.Error("SetAESKey didn't replace the v200 key")
+	}
+	if p := regionProfile(t, MSEA, 150); p.AESKey != key150 {
+		t.Error("SetAESKey replaced the v150 key")
+	}
+	if p := regionProfile(t, GMS, 250); p.AESKey != aeskey {
+		t.Error("SetAESKey changed the keys of another region")
+	}
+}
+
+func TestCryptProfileOptions(t *testing.T) {
+	body := testCryptBody(100)
+	encrypt := func(p CryptProfile, shanda bool) []byte {
+		crypt := NewCryptWithProfile(testCryptIV, p)
+		packet := make([]byte, 4+len(body))
+		copy(packet[4:], body)
+		if shanda {
+			crypt.Encrypt(packet)
+		} else {
+			crypt.EncryptNoShanda(packet)
+		}
+		return packet
+	}
+
+	gms := regionProfile(t, GMS, 62)
+	kms := regionProfile(t, KMS, 62)
+
+	if !bytes.Equal(encrypt(kms, true)[4:], encrypt(gms, false)[4:]) {
+		t.Error("KMS doesn't match GMS without shanda")
+	}
+
+	// same version, different header transform
+	recv := NewCryptWithProfile(testCryptIV, kms)
+	if _, err := recv.CheckHeader(encrypt(gms, true)); err == nil {
+		t.Error("KMS accepted a GMS header")
+	}
+
+	custom := gms
+	custom.AESKey[0] ^= 0xFF
+	if bytes.Equal(encrypt(custom, true)[4:], encrypt(gms, true)[4:]) {
+		t.Error("AESKey is ignored")
+	}
+
+	var identity [256]byte
+	for i := range identity {
+		identity[i] = byte(i)
+	}
+	custom = gms
+	custom.ShuffleTable = &identity
+	a := NewCryptWithProfile(testCryptIV, gms)
+	b := NewCryptWithProfile(testCryptIV, custom)
+	a.Shuffle()
+	b.Shuffle()
+	if bytes.Equal(a.IV(), b.IV()) {
+		t.Error("ShuffleTable is ignored")
+	}
+}
+
+func TestHeaderMode(t *testing.T) {
+	if v := HeaderInverted.encodeVersion(62); v != 0xC1FF {
+		t.Errorf("inverted v62 = %04X, expected C1FF", v)
+	}
+	if v := HeaderPlain.encodeVersion(62); v != 0x3E00 {
+		t.Errorf("plain v62 = %04X, expected 3E00", v)
+	}
+
+	for _, mode := range []HeaderMode{HeaderInverted, HeaderPlain} {
+		if v := mode.decodeVersion(mode.encodeVersion(83)); v != 83 {
+			t.Errorf("mode %d: decoded version = %d, expected 83", mode, v)
+		}
+	}
+}
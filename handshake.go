@@ -0,0 +1,191 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package maplelib
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// handshakeHeaderSize is the size of the plain length that precedes the
+// handshake packet
+const handshakeHeaderSize = 2
+
+// maxHandshakeLength is the largest handshake packet that is accepted
+const maxHandshakeLength = 0x400
+
+// A Handshake is the unencrypted hello packet a server sends to a client
+// right after it connects. The IVs are named from the server's point of
+// view: the server decrypts received packets with RecvIV and encrypts sent
+// packets with SendIV.
+type Handshake struct {
+	Version uint16 // MapleStory version
+	Patch   string // minor version, such as "1"
+	RecvIV  [4]byte
+	SendIV  [4]byte
+	Locale  byte // region, for example 8 for GMS
+}
+
+// Encode encodes the handshake including the 2-byte length that precedes
+// it on the wire
+func (h *Handshake) Encode() Packet {
+	p := NewPacket()
+	p.Encode2(0) // placeholder for the length
+	p.Encode2(h.Version)
+	p.EncodeString(h.Patch)
+	p.Append(h.RecvIV[:])
+	p.Append(h.SendIV[:])
+	p.Encode1(h.Locale)
+	binary.LittleEndian.PutUint16(p, uint16(len(p)-handshakeHeaderSize))
+	return p
+}
+
+// DecodeHandshake decodes a handshake packet without its 2-byte length.
+// Data that follows the locale is ignored.
+func DecodeHandshake(p Packet) (h Handshake, err error) {
+	it := p.Begin()
+	if h.Version, err = it.Decode2(); err != nil {
+		return
+	}
+	if h.Patch, err = it.DecodeString(); err != nil {
+		return
+	}
+
+	iv, err := it.PopBytes(len(h.RecvIV) + len(h.SendIV))
+	if err != nil {
+		return
+	}
+	copy(h.RecvIV[:], iv)
+	copy(h.SendIV[:], iv[len(h.RecvIV):])
+
+	h.Locale, err = it.Decode1()
+	return
+}
+
+// ReadHandshake reads and decodes a handshake packet and its length
+func ReadHandshake(r io.Reader) (Handshake, error) {
+	var header [handshakeHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Handshake{}, err
+	}
+
+	length := int(binary.LittleEndian.Uint16(header[:]))
+	if length > maxHandshakeLength {
+		return Handshake{}, fmt.Errorf(
+			"Handshake is too long (%d bytes, max %d)", length,
+			maxHandshakeLength)
+	}
+
+	p := make(Packet, length)
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Handshake{}, err
+	}
+
+	return DecodeHandshake(p)
+}
+
+// ServerCrypts returns the keys a server uses to encrypt sent packets and
+// decrypt received packets after sending this handshake, using the GMS
+// profile for the handshake's version
+func (h *Handshake) ServerCrypts() (send, recv Crypt) {
+	return h.ServerCryptsProfile(gmsProfile(h.Version))
+}
+
+// ClientCrypts returns the keys a client uses to encrypt sent packets and
+// decrypt received packets after receiving this handshake, using the GMS
+// profile for the handshake's version
+func (h *Handshake) ClientCrypts() (send, recv Crypt) {
+	return h.ClientCryptsProfile(gmsProfile(h.Version))
+}
+
+// ServerCryptsProfile is like ServerCrypts but uses the given profile
+func (h *Handshake) ServerCryptsProfile(p CryptProfile) (send, recv Crypt) {
+	return NewCryptWithProfile(h.SendIV, p), NewCryptWithProfile(h.RecvIV, p)
+}
+
+// ClientCryptsProfile is like ClientCrypts but uses the given profile
+func (h *Handshake) ClientCryptsProfile(p CryptProfile) (send, recv Crypt) {
+	return NewCryptWithProfile(h.RecvIV, p), NewCryptWithProfile(h.SendIV, p)
+}
+
+// NewHandshake initializes a handshake with random IVs from crypto/rand
+func NewHandshake(version uint16, patch string, locale byte) (
+	Handshake, error) {
+
+	h := Handshake{Version: version, Patch: patch, Locale: locale}
+	if _, err := rand.Read(h.RecvIV[:]); err != nil {
+		return h, err
+	}
+	_, err := rand.Read(h.SendIV[:])
+	return h, err
+}
+
+// ServerHandshake sends a handshake with random IVs to a client that just
+// connected and returns the keys the server encrypts sent packets and
+// decrypts received packets with, using the GMS profile
+func ServerHandshake(conn io.Writer, version uint16, patch string,
+	locale byte) (send, recv Crypt, err error) {
+
+	return ServerHandshakeProfile(conn, gmsProfile(version), patch, locale)
+}
+
+// ServerHandshakeProfile is like ServerHandshake but uses the given profile
+// and sends its version
+func ServerHandshakeProfile(conn io.Writer, p CryptProfile, patch string,
+	locale byte) (send, recv Crypt, err error) {
+
+	h, err := NewHandshake(p.Version, patch, locale)
+	if err != nil {
+		return
+	}
+	if err = writeFull(conn, h.Encode()); err != nil {
+		return
+	}
+
+	send, recv = h.ServerCryptsProfile(p)
+	return
+}
+
+// ClientHandshake receives the handshake a server sends after connecting
+// and returns it along with the keys the client encrypts sent packets and
+// decrypts received packets with, using the GMS profile
+func ClientHandshake(conn io.Reader) (h Handshake, send, recv Crypt,
+	err error) {
+
+	return ClientHandshakeRegion(conn, GMS)
+}
+
+// ClientHandshakeRegion is like ClientHandshake but uses the profile of the
+// given region for the version the server sent, see NewCryptProfile
+func ClientHandshakeRegion(conn io.Reader, region Region) (h Handshake,
+	send, recv Crypt, err error) {
+
+	if h, err = ReadHandshake(conn); err != nil {
+		return
+	}
+
+	p, err := NewCryptProfile(region, h.Version)
+	if err != nil {
+		return
+	}
+	send, recv = h.ClientCryptsProfile(p)
+	return
+}
@@ -0,0 +1,182 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package maplelib
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	h := Handshake{
+		Version: 83,
+		Patch:   "1",
+		RecvIV:  [4]byte{0x01, 0x02, 0x03, 0x04},
+		SendIV:  [4]byte{0x05, 0x06, 0x07, 0x08},
+		Locale:  8,
+	}
+	out := []byte{
+		0x0E, 0x00, // length
+		0x53, 0x00, // version
+		0x01, 0x00, 0x31, // patch
+		0x01, 0x02, 0x03, 0x04, // recv iv
+		0x05, 0x06, 0x07, 0x08, // send iv
+		0x08, // locale
+	}
+
+	p := h.Encode()
+	if !bytes.Equal(p, out) {
+		t.Errorf("h.Encode() = % X, expected % X", []byte(p), out)
+	}
+
+	decoded, err := ReadHandshake(bytes.NewReader(out))
+	if err != nil || decoded != h {
+		t.Errorf("ReadHandshake = %+v, %v, expected %+v", decoded, err, h)
+	}
+
+	for i := 0; i < len(out)-2; i++ {
+		if _, err = DecodeHandshake(out[2 : 2+i]); err == nil {
+			t.Errorf("DecodeHandshake of %d bytes: expected an error", i)
+		}
+	}
+	if _, err = ReadHandshake(bytes.NewReader(out[:6])); err == nil {
+		t.Error("ReadHandshake of a truncated packet: expected an error")
+	}
+	if _, err = ReadHandshake(bytes.NewReader([]byte{0xFF, 0xFF})); err == nil {
+		t.Error("ReadHandshake of a huge packet: expected an error")
+	}
+}
+
+// testHandshake runs a server and a client handshake over a pipe and
+// exchanges a packet each way with the resulting sessions
+func testHandshake(t *testing.T,
+	serve func(w io.Writer) (send, recv Crypt, err error),
+	connect func(r io.Reader) (Handshake, Crypt, Crypt, error)) (
+	h Handshake, client, server *Session) {
+
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+
+	type result struct {
+		send, recv Crypt
+		err        error
+	}
+	done := make(chan result)
+	go func() {
+		var r result
+		r.send, r.recv, r.err = serve(s)
+		done <- r
+	}()
+
+	h, csend, crecv, err := connect(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	if h.Version != 83 || h.Patch != "1" {
+		t.Errorf("unexpected handshake %+v", h)
+	}
+	if h.RecvIV == h.SendIV {
+		t.Errorf("the IVs are not random: %+v", h)
+	}
+	if csend.MapleVersion() != 83 || crecv.MapleVersion() != 83 {
+		t.Errorf("client crypts = %v %v", csend, crecv)
+	}
+
+	client = NewSession(c, csend, crecv)
+	server = NewSession(s, r.send, r.recv)
+
+	errs := make(chan error)
+	go func() {
+		errs <- client.WritePacket(testSessionPacket(1))
+	}()
+	p, err := server.ReadPacket()
+	if err != nil || !bytes.Equal(p, testSessionPacket(1)) {
+		t.Errorf("server received %v, %v", p, err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		errs <- server.WritePacket(testSessionPacket(2))
+	}()
+	p, err = client.ReadPacket()
+	if err != nil || !bytes.Equal(p, testSessionPacket(2)) {
+		t.Errorf("client received %v, %v", p, err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServerClientHandshake(t *testing.T) {
+	h, client, _ := testHandshake(t,
+		func(w io.Writer) (Crypt, Crypt, error) {
+			return ServerHandshake(w, 83, "1", 8)
+		}, ClientHandshake)
+
+	if h.Locale != 8 {
+		t.Errorf("unexpected handshake %+v", h)
+	}
+	recv := client.RecvCrypt()
+	if region := recv.Profile().Region; region != GMS {
+		t.Errorf("the client uses the %v profile", region)
+	}
+}
+
+func TestServerClientHandshakeRegion(t *testing.T) {
+	kms := regionProfile(t, KMS, 83)
+	h, client, server := testHandshake(t,
+		func(w io.Writer) (Crypt, Crypt, error) {
+			return ServerHandshakeProfile(w, kms, "1", 1)
+		},
+		func(r io.Reader) (Handshake, Crypt, Crypt, error) {
+			return ClientHandshakeRegion(r, KMS)
+		})
+
+	if h.Locale != 1 {
+		t.Errorf("unexpected handshake %+v", h)
+	}
+	for _, s := range []*Session{client, server} {
+		send, recv := s.SendCrypt(), s.RecvCrypt()
+		if send.Profile() != kms || recv.Profile() != kms {
+			t.Errorf("session crypts use %v and %v", send.Profile(),
+				recv.Profile())
+		}
+	}
+
+	// a GMS key with the same IV can't read the headers of KMS packets
+	send := client.SendCrypt()
+	var iv [4]byte
+	copy(iv[:], send.IV())
+	gms := NewCrypt(iv, 83)
+	packet := make([]byte, 4+10)
+	send.Encrypt(packet)
+	if _, err := gms.CheckHeader(packet); err == nil {
+		t.Error("a GMS key accepted a KMS header")
+	}
+}
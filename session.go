@@ -0,0 +1,133 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package maplelib
+
+import (
+	"io"
+	"sync"
+)
+
+// A Session is an encrypted MapleStory connection over an io.ReadWriter
+// such as a net.Conn. It owns the send and receive keys, takes care of
+// framing packets with the encrypted header and shuffles the keys after
+// every packet.
+//
+// A Session is safe for one goroutine reading packets and one goroutine
+// writing packets at the same time. After an error, the stream is most
+// likely out of sync and the session should be closed.
+type Session struct {
+	rw         io.ReadWriter
+	readMutex  sync.Mutex // guards recv and header
+	recv       Crypt
+	header     [encryptedHeaderSize]byte
+	writeMutex sync.Mutex // guards send
+	send       Crypt
+}
+
+// NewSession initializes a session over rw that encrypts sent packets with
+// send and decrypts received packets with recv. The maximum lengths of sent
+// and received packets are the ones set on send and recv. For a server,
+// send and recv are built from the IVs it sent in the handshake, for a
+// client it's the other way around.
+func NewSession(rw io.ReadWriter, send, recv Crypt) *Session {
+	return &Session{rw: rw, send: send, recv: recv}
+}
+
+// SendCrypt returns a copy of the current key used to encrypt sent packets
+func (s *Session) SendCrypt() Crypt {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.send
+}
+
+// RecvCrypt returns a copy of the current key used to decrypt received packets
+func (s *Session) RecvCrypt() Crypt {
+	s.readMutex.Lock()
+	defer s.readMutex.Unlock()
+	return s.recv
+}
+
+// ReadPacket reads, decrypts and returns the next packet.
+// The returned packet doesn't include the encrypted header. The header is
+// verified with Crypt.CheckHeader before the body is read.
+func (s *Session) ReadPacket() (Packet, error) {
+	s.readMutex.Lock()
+	defer s.readMutex.Unlock()
+
+	if _, err := io.ReadFull(s.rw, s.header[:]); err != nil {
+		return nil, err
+	}
+
+	length, err := s.recv.CheckHeader(s.header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	p := make(Packet, length)
+	if _, err := io.ReadFull(s.rw, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	s.recv.Decrypt(p)
+	s.recv.Shuffle()
+	return p, nil
+}
+
+// WritePacket encrypts and writes a packet. p must not include space for
+// the encrypted header and is not modified. Packets longer than the
+// maximum length of the send key give a PacketTooLongError.
+func (s *Session) WritePacket(p Packet) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if max := s.send.MaxPacketLength(); len(p) > max {
+		return PacketTooLongError{len(p), max}
+	}
+
+	buf := make([]byte, encryptedHeaderSize+len(p))
+	copy(buf[encryptedHeaderSize:], p)
+
+	s.send.Encrypt(buf)
+	s.send.Shuffle()
+	return writeFull(s.rw, buf)
+}
+
+// writeFull writes the whole buffer to w even if w only accepts part of it
+// at a time
+func writeFull(w io.Writer, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := w.Write(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// Close closes the underlying connection if it implements io.Closer
+func (s *Session) Close() error {
+	if c, ok := s.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package maplelib
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+)
+
+var (
+	testServerIV = [4]byte{0xFE, 0xCA, 0xDD, 0xBA}
+	testClientIV = [4]byte{0x46, 0x72, 0x7A, 0xD2}
+)
+
+// testSessions returns the client and server ends of a session over
+// net.Pipe
+func testSessions() (client, server *Session) {
+	c, s := net.Pipe()
+	client = NewSession(c, NewCryptWithProfile(testClientIV, testProfile),
+		NewCryptWithProfile(testServerIV, testProfile))
+	server = NewSession(s, NewCryptWithProfile(testServerIV, testProfile),
+		NewCryptWithProfile(testClientIV, testProfile))
+	return
+}
+
+// testSessionPacket builds a distinct packet for the i-th message
+func testSessionPacket(i int) Packet {
+	p := NewPacket()
+	p.Encode2(uint16(i))
+	p.EncodeString("Hello world!")
+	p.Append(bytes.Repeat([]byte{byte(i)}, i*211%1700))
+	return p
+}
+
+func TestSession(t *testing.T) {
+	client, server := testSessions()
+	defer client.Close()
+	defer server.Close()
+
+	const count = 20
+	errs := make(chan error, 2)
+
+	// the client sends and receives at the same time
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := client.WritePacket(testSessionPacket(i)); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	go func() {
+		for i := 0; i < count; i++ {
+			p, err := client.ReadPacket()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(p, testSessionPacket(count-i)) {
+				t.Errorf("client packet %d = %v", i, p)
+			}
+		}
+		errs <- nil
+	}()
+
+	for i := 0; i < count; i++ {
+		p, err := server.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, testSessionPacket(i)) {
+			t.Errorf("server packet %d = %v", i, p)
+		}
+		if err = server.WritePacket(testSessionPacket(count - i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	csend, crecv := client.SendCrypt(), client.RecvCrypt()
+	ssend, srecv := server.SendCrypt(), server.RecvCrypt()
+	if !bytes.Equal(csend.IV(), srecv.IV()) ||
+		!bytes.Equal(crecv.IV(), ssend.IV()) {
+
+		t.Error("the client and server keys are out of sync")
+	}
+}
+
+// shortWriter accepts at most 3 bytes per write
+type shortWriter struct {
+	bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return w.Buffer.Write(p)
+}
+
+// testReadWriter reads from r and writes to w
+type testReadWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func TestSessionPartialIO(t *testing.T) {
+	var wire shortWriter
+	writer := NewSession(&testReadWriter{nil, &wire},
+		NewCryptWithProfile(testServerIV, testProfile),
+		NewCryptWithProfile(testClientIV, testProfile))
+
+	for i := 0; i < 3; i++ {
+		if err := writer.WritePacket(testSessionPacket(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the same packets encrypted by hand
+	var expected []byte
+	crypt := NewCryptWithProfile(testServerIV, testProfile)
+	for i := 0; i < 3; i++ {
+		buf := append(make([]byte, 4), testSessionPacket(i)...)
+		crypt.Encrypt(buf)
+		crypt.Shuffle()
+		expected = append(expected, buf...)
+	}
+	if !bytes.Equal(wire.Bytes(), expected) {
+		t.Error("written data doesn't match manually encrypted packets")
+	}
+
+	reader := NewSession(&testReadWriter{
+		iotest.OneByteReader(bytes.NewReader(wire.Bytes())), nil},
+		NewCryptWithProfile(testClientIV, testProfile),
+		NewCryptWithProfile(testServerIV, testProfile))
+
+	for i := 0; i < 3; i++ {
+		p, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, testSessionPacket(i)) {
+			t.Errorf("packet %d = %v", i, p)
+		}
+	}
+
+	if _, err := reader.ReadPacket(); err != io.EOF {
+		t.Errorf("err = %v at the end of the stream, expected EOF", err)
+	}
+
+	truncated := NewSession(&testReadWriter{
+		bytes.NewReader(wire.Bytes()[:10]), nil},
+		NewCryptWithProfile(testClientIV, testProfile),
+		NewCryptWithProfile(testServerIV, testProfile))
+	if _, err := truncated.ReadPacket(); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v for a truncated packet", err)
+	}
+
+	err := writer.WritePacket(make(Packet, DefaultMaxPacketLength+1))
+	if e, ok := err.(PacketTooLongError); !ok ||
+		e.Length != DefaultMaxPacketLength+1 ||
+		e.Max != DefaultMaxPacketLength {
+
+		t.Errorf("err = %v for a packet that is too long", err)
+	}
+}
+
+func TestSessionDesync(t *testing.T) {
+	client, server := testSessions()
+	defer client.Close()
+	defer server.Close()
+
+	// the server misses a shuffle, so the next header doesn't match its IV
+	desynced := NewCryptWithProfile(testClientIV, testProfile)
+	desynced.Shuffle()
+	server.recv = desynced
+
+	go client.WritePacket(testSessionPacket(1))
+	if _, err := server.ReadPacket(); err == nil {
+		t.Fatal("expected an error for a desynced IV")
+	} else if _, ok := err.(IVMismatchError); !ok {
+		t.Errorf("err = %v, expected an IVMismatchError", err)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"container/list"
+	"image"
+	"sync"
+	"unsafe"
+)
+
+// A CachingMapleDataProvider wraps a MapleDataProvider and keeps the imgs
+// it returns in memory, discarding the least recently used ones once their
+// estimated size exceeds a limit. Concurrent Get calls for the same img
+// share a single load. It's safe to use from multiple goroutines as long as
+// the wrapped provider is.
+//
+// The cached data is shared between all callers and must not be modified.
+// Canvas images count towards the size limit once they're decoded, so
+// decoding canvases of cached imgs can evict other imgs.
+type CachingMapleDataProvider struct {
+	MapleDataProvider
+	maxBytes int64
+
+	mutex   sync.Mutex // guards everything below
+	size    int64
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	loading map[string]*cacheCall
+}
+
+type cacheEntry struct {
+	path   string
+	data   MapleData
+	size   int64
+	cached bool // whether the entry is in the lru list
+}
+
+// A decodeWatcher is a canvas that can report the size of its image when
+// it's decoded
+type decodeWatcher interface {
+	watchDecode(fn func(size int64)) int64
+}
+
+// imageSize returns the memory in bytes used by the pixels of img
+func imageSize(img image.Image) int64 {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return int64(len(img.Pix))
+	case *image.RGBA:
+		return int64(len(img.Pix))
+	case *image.Paletted:
+		return int64(len(img.Pix)) + int64(len(img.Palette))*4
+	}
+
+	// assume 4 bytes per pixel for other formats
+	b := img.Bounds()
+	return int64(b.Dx()) * int64(b.Dy()) * 4
+}
+
+// A cacheCall is a load in progress that other callers can wait for
+type cacheCall struct {
+	wg   sync.WaitGroup
+	data MapleData
+	err  error
+}
+
+// NewCachingMapleDataProvider wraps p so that the imgs it returns are cached
+// up to an estimated total of maxBytes. A maxBytes of zero or less means no
+// limit.
+func NewCachingMapleDataProvider(p MapleDataProvider, maxBytes int64,
+) *CachingMapleDataProvider {
+
+	return &CachingMapleDataProvider{
+		MapleDataProvider: p,
+		maxBytes:          maxBytes,
+		lru:               list.New(),
+		entries:           map[string]*list.Element{},
+		loading:           map[string]*cacheCall{},
+	}
+}
+
+// Get returns the cached img at the given path or loads it from the wrapped
+// provider. Errors are not cached.
+func (c *CachingMapleDataProvider) Get(path string) (MapleData, error) {
+	c.mutex.Lock()
+	if el, ok := c.entries[path]; ok {
+		c.lru.MoveToFront(el)
+		c.mutex.Unlock()
+		return el.Value.(*cacheEntry).data, nil
+	}
+
+	if call, ok := c.loading[path]; ok {
+		c.mutex.Unlock()
+		call.wg.Wait()
+		return call.data, call.err
+	}
+
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.loading[path] = call
+	c.mutex.Unlock()
+
+	c.load(path, call)
+	return call.data, call.err
+}
+
+// load loads the img for call and caches it unless it was evicted while
+// loading
+func (c *CachingMapleDataProvider) load(path string, call *cacheCall) {
+	defer call.wg.Done()
+
+	entry := &cacheEntry{path: path}
+	var size int64
+	defer func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.loading[path] != call {
+			return // evicted or purged while loading
+		}
+		delete(c.loading, path)
+
+		if call.err == nil && call.data != nil {
+			entry.size += size
+			c.add(entry)
+		}
+	}()
+
+	call.data, call.err = c.MapleDataProvider.Get(path)
+	if call.err == nil && call.data != nil {
+		entry.data = call.data
+		size = EstimateDataSize(call.data) + c.watchImages(entry, call.data)
+	}
+}
+
+// watchImages makes the canvases in the tree of d grow entry when they're
+// decoded and returns the size of the images that are already decoded
+func (c *CachingMapleDataProvider) watchImages(entry *cacheEntry,
+	d MapleData) (size int64) {
+
+	if w, ok := d.Get().(decodeWatcher); ok {
+		size += w.watchDecode(func(n int64) { c.grow(entry, n) })
+	}
+	for _, child := range d.Children() {
+		size += c.watchImages(entry, child)
+	}
+	return
+}
+
+// grow adds the size of a newly decoded image to entry and evicts the least
+// recently used imgs until the cache fits the size limit again
+func (c *CachingMapleDataProvider) grow(entry *cacheEntry, n int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry.size += n
+	if entry.cached {
+		c.size += n
+		c.shrink()
+	}
+}
+
+// add inserts an img into the cache and evicts the least recently used imgs
+// until the cache fits the size limit
+func (c *CachingMapleDataProvider) add(entry *cacheEntry) {
+	if c.maxBytes > 0 && entry.size > c.maxBytes {
+		return // would evict everything else
+	}
+
+	c.entries[entry.path] = c.lru.PushFront(entry)
+	entry.cached = true
+	c.size += entry.size
+	c.shrink()
+}
+
+// shrink evicts the least recently used imgs until the cache fits the size
+// limit
+func (c *CachingMapleDataProvider) shrink() {
+	for c.maxBytes > 0 && c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *CachingMapleDataProvider) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	entry.cached = false
+	delete(c.entries, entry.path)
+	c.size -= entry.size
+}
+
+// Evict removes the img at the given path from the cache and reports
+// whether it was cached. A load of the img that is in progress completes
+// but its result is not cached.
+func (c *CachingMapleDataProvider) Evict(path string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, loading := c.loading[path]
+	delete(c.loading, path)
+
+	el, ok := c.entries[path]
+	if ok {
+		c.remove(el)
+	}
+	return ok || loading
+}
+
+// Purge removes all imgs from the cache
+func (c *CachingMapleDataProvider) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		el.Value.(*cacheEntry).cached = false
+	}
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+	c.loading = map[string]*cacheCall{}
+	c.size = 0
+}
+
+// Len returns the number of cached imgs
+func (c *CachingMapleDataProvider) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the estimated size in bytes of the cached imgs
+func (c *CachingMapleDataProvider) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+// dataNodeSize is a rough estimate of the memory used by a node regardless
+// of its name and value
+const dataNodeSize = int64(unsafe.Sizeof(WZIMGEntry{}))
+
+// EstimateDataSize returns a rough estimate of the memory in bytes used by
+// the tree of d, not counting decoded canvas images
+func EstimateDataSize(d MapleData) int64 {
+	size := dataNodeSize + int64(len(d.Name()))
+
+	switch v := d.Get().(type) {
+	case string:
+		size += int64(len(v))
+	case int16:
+		size += 2
+	case int32, float32:
+		size += 4
+	case int64, float64:
+		size += 8
+	case image.Point:
+		size += int64(unsafe.Sizeof(v))
+	case *FileStoredPngMapleCanvas:
+		size += int64(unsafe.Sizeof(*v)) + int64(len(v.filepath))
+	case *FileStoredMapleSound:
+		size += int64(unsafe.Sizeof(*v)) + int64(len(v.filepath))
+	case *PNGMapleCanvas:
+		size += int64(unsafe.Sizeof(*v))
+	case *ImgMapleSound:
+		size += int64(unsafe.Sizeof(*v)) + int64(len(v.header))
+	}
+
+	for _, child := range d.Children() {
+		size += EstimateDataSize(child)
+	}
+	return size
+}
@@ -0,0 +1,266 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testCountingProvider returns a small img for any path ending in .img and
+// counts how many times each path was loaded
+type testCountingProvider struct {
+	gate   chan struct{} // if not nil, loads block until it's closed
+	loads  sync.Map      // path -> *int32
+	canvas bool          // add a 64x64 canvas to the imgs
+}
+
+func (p *testCountingProvider) Get(path string) (MapleData, error) {
+	if p.gate != nil {
+		<-p.gate
+	}
+
+	n, _ := p.loads.LoadOrStore(path, new(int32))
+	atomic.AddInt32(n.(*int32), 1)
+
+	if path == "missing.img" {
+		return nil, notFoundError(path, nil)
+	}
+
+	img := NewWZIMGEntry(path, PROPERTY, nil)
+	val := NewWZIMGEntry("x", INT, nil)
+	val.data = int32(1)
+	img.addChild(val)
+
+	if p.canvas {
+		canvas := NewWZIMGEntry("canvas", CANVAS, nil)
+		canvas.data = NewPNGMapleCanvas(64, 64, FormatARGB8888, 0,
+			testDeflate(make([]byte, 64*64*4)), nil)
+		img.addChild(canvas)
+	}
+	return img, nil
+}
+
+func (p *testCountingProvider) Root() MapleDataDirectoryEntry { return nil }
+
+func (p *testCountingProvider) count(path string) int32 {
+	n, ok := p.loads.Load(path)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt32(n.(*int32))
+}
+
+func TestCachingMapleDataProvider(t *testing.T) {
+	p := &testCountingProvider{}
+	imgsize := EstimateDataSize(func() MapleData {
+		d, _ := p.Get("z.img") // same name length as the others
+		return d
+	}())
+
+	// room for 2 imgs
+	c := NewCachingMapleDataProvider(p, imgsize*2+imgsize/2)
+
+	a1, _ := c.Get("a.img")
+	a2, err := c.Get("a.img")
+	if err != nil || a1 != a2 || p.count("a.img") != 1 {
+		t.Errorf("a.img was loaded %d times, expected 1", p.count("a.img"))
+	}
+	if GetIntD(a2.ChildByPath("x"), 0) != 1 {
+		t.Errorf("cached a.img/x is wrong")
+	}
+
+	c.Get("b.img")
+	c.Get("a.img") // b is now the least recently used
+	c.Get("c.img")
+	if c.Len() != 2 || c.Size() != imgsize*2 {
+		t.Errorf("cache holds %d imgs, %d bytes", c.Len(), c.Size())
+	}
+
+	c.Get("a.img")
+	c.Get("b.img")
+	if p.count("a.img") != 1 || p.count("b.img") != 2 {
+		t.Errorf("expected b.img to be evicted, loads: a=%d b=%d",
+			p.count("a.img"), p.count("b.img"))
+	}
+
+	if !c.Evict("b.img") || c.Evict("b.img") {
+		t.Errorf("Evict didn't report the cached img correctly")
+	}
+	c.Get("b.img")
+	if p.count("b.img") != 3 {
+		t.Errorf("b.img was loaded %d times, expected 3", p.count("b.img"))
+	}
+
+	c.Purge()
+	if c.Len() != 0 || c.Size() != 0 {
+		t.Errorf("Purge left %d imgs, %d bytes", c.Len(), c.Size())
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = c.Get("missing.img"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("missing.img: err = %v", err)
+		}
+	}
+	if p.count("missing.img") != 2 {
+		t.Errorf("errors should not be cached")
+	}
+
+	small := NewCachingMapleDataProvider(p, imgsize/2)
+	small.Get("a.img")
+	if small.Len() != 0 {
+		t.Errorf("imgs bigger than the limit should not be cached")
+	}
+}
+
+func TestCachingMapleDataProviderImages(t *testing.T) {
+	p := &testCountingProvider{canvas: true}
+	d, _ := p.Get("z.img")
+	imgsize := EstimateDataSize(d)
+	const pixels = 64 * 64 * 4
+
+	// room for 2 imgs but only one decoded canvas
+	c := NewCachingMapleDataProvider(p, imgsize*2+pixels+pixels/2)
+
+	a, _ := c.Get("a.img")
+	b, _ := c.Get("b.img")
+	if c.Size() != imgsize*2 {
+		t.Fatalf("size = %d, expected %d", c.Size(), imgsize*2)
+	}
+
+	if GetImage(a.ChildByPath("canvas")) == nil {
+		t.Fatal("failed to decode a.img/canvas")
+	}
+	if c.Size() != imgsize*2+pixels || c.Len() != 2 {
+		t.Errorf("after decoding a.img/canvas: size = %d, len = %d",
+			c.Size(), c.Len())
+	}
+
+	// a.img is the least recently used
+	GetImage(b.ChildByPath("canvas"))
+	if c.Size() != imgsize+pixels || c.Len() != 1 {
+		t.Errorf("after decoding b.img/canvas: size = %d, len = %d",
+			c.Size(), c.Len())
+	}
+	if c.Evict("a.img") || !c.Evict("b.img") || c.Size() != 0 {
+		t.Errorf("b.img should be the only cached img, size = %d", c.Size())
+	}
+}
+
+func TestCachingMapleDataProviderXmlImages(t *testing.T) {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/0100100.img.xml": `<?xml version="1.0"?>
+<imgdir name="0100100.img">
+	<imgdir name="stand">
+		<canvas name="0" width="1" height="1"/>
+	</imgdir>
+</imgdir>`,
+	})
+	writeTestPNG(t, filepath.Join(dir, "Mob.wz", "0100100.img", "stand",
+		"0.png"), color.NRGBA{0xFF, 0x00, 0x00, 0xFF})
+
+	x, err := NewXml(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCachingMapleDataProvider(x, 0)
+	mob, err := c.Get("Mob.wz/0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := c.Size()
+
+	stand := mob.ChildByPath("stand/0")
+	if stand.Get() != stand.Get() {
+		t.Error("every Get of an xml canvas returns a different canvas")
+	}
+	if GetImage(stand) == nil {
+		t.Fatal("failed to decode stand/0")
+	}
+	if c.Size() != size+4 {
+		t.Errorf("size after decoding stand/0 = %d, expected %d", c.Size(),
+			size+4)
+	}
+
+	// the cached data keeps the decoded image
+	again, _ := c.Get("Mob.wz/0100100.img")
+	if GetImage(again.ChildByPath("stand/0")) != GetImage(stand) ||
+		c.Size() != size+4 {
+
+		t.Errorf("stand/0 was decoded again, size = %d", c.Size())
+	}
+}
+
+func TestCachingMapleDataProviderConcurrent(t *testing.T) {
+	p := &testCountingProvider{gate: make(chan struct{})}
+	c := NewCachingMapleDataProvider(p, 0)
+
+	const goroutines = 50
+	results := make([]MapleData, goroutines)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = c.Get(fmt.Sprintf("%d.img", i%2))
+		}(i)
+	}
+	close(p.gate)
+	wg.Wait()
+
+	if p.count("0.img") != 1 || p.count("1.img") != 1 {
+		t.Errorf("concurrent loads were not shared: 0.img=%d 1.img=%d",
+			p.count("0.img"), p.count("1.img"))
+	}
+	for i, d := range results {
+		if d == nil || d != results[i%2] {
+			t.Errorf("goroutine %d got a different img", i)
+		}
+	}
+}
+
+func TestCanvasConcurrentLoad(t *testing.T) {
+	compressed := testDeflate([]byte{0x00, 0x00, 0xFF, 0xFF})
+	canvas := NewPNGMapleCanvas(1, 1, FormatARGB8888, 0, compressed, nil)
+
+	var wg sync.WaitGroup
+	images := make(chan interface{}, 20)
+	for i := 0; i < cap(images); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			img, err := canvas.Load()
+			if err != nil {
+				t.Error(err)
+			}
+			images <- img
+		}()
+	}
+	wg.Wait()
+	close(images)
+
+	first := <-images
+	for img := range images {
+		if img != first {
+			t.Errorf("concurrent loads decoded the canvas more than once")
+		}
+	}
+}
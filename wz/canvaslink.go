@@ -0,0 +1,235 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Names of the string properties a canvas uses to link to another canvas
+// that holds its actual bitmap
+const (
+	InlinkProperty  = "_inlink"  // path relative to the same img
+	OutlinkProperty = "_outlink" // path starting with the wz file's name
+	SourceProperty  = "source"   // same as _outlink, used by newer data
+)
+
+// A BrokenLinkError is returned when a canvas links to data that doesn't
+// exist or is not a canvas
+type BrokenLinkError struct {
+	Path     string // full data path of the linking canvas
+	Property string // name of the link property
+	Link     string // value of the link property
+	Err      error  // underlying error, if any
+}
+
+func (e *BrokenLinkError) Error() string {
+	msg := fmt.Sprintf("Canvas %s has a broken %s link to %s",
+		e.Path, e.Property, e.Link)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *BrokenLinkError) Unwrap() error { return e.Err }
+
+// A CanvasLinkCycleError is returned when a chain of canvas links leads back
+// to a canvas that was already visited
+type CanvasLinkCycleError struct {
+	Chain []string // full data paths of the canvases that form the cycle
+}
+
+func (e *CanvasLinkCycleError) Error() string {
+	return "Canvas link cycle detected: " + strings.Join(e.Chain, " -> ")
+}
+
+// canvasLinks is embedded by providers to implement SetLinkProvider and
+// LinkProvider. owner is the provider that embeds it.
+type canvasLinks struct {
+	owner MapleDataProvider
+	links MapleDataProvider
+}
+
+// SetLinkProvider sets the provider that canvas _outlink and source links
+// are resolved through. By default, they are resolved through the provider
+// itself, which for a single wz file only works for links to the same file.
+func (c *canvasLinks) SetLinkProvider(p MapleDataProvider) { c.links = p }
+
+// LinkProvider returns the provider that canvas links are resolved through
+func (c *canvasLinks) LinkProvider() MapleDataProvider {
+	if c.links != nil {
+		return c.links
+	}
+	return c.owner
+}
+
+// A dataProviderOwner is MapleData that knows which provider it came from
+type dataProviderOwner interface {
+	dataProvider() MapleDataProvider
+}
+
+// hasCanvasLink checks if the canvas d has any link property
+func hasCanvasLink(d MapleData) bool {
+	return d.ChildByPath(InlinkProperty) != nil ||
+		d.ChildByPath(OutlinkProperty) != nil ||
+		d.ChildByPath(SourceProperty) != nil
+}
+
+// ResolveCanvas follows the _inlink, _outlink and source properties of the
+// canvas d through the provider that owns it and returns the canvas data
+// that holds the actual bitmap. If d doesn't link anywhere, it's returned
+// as is.
+// Links that point to missing data or to data that is not a canvas are
+// reported as a *BrokenLinkError, and chains of links that loop are
+// reported as a *CanvasLinkCycleError.
+func ResolveCanvas(d MapleData) (MapleData, error) {
+	if d == nil {
+		return nil, errors.New("No canvas data")
+	}
+
+	var chain []string
+	for {
+		path := GetFullDataPath(d)
+		for i, p := range chain {
+			if p == path {
+				return nil, &CanvasLinkCycleError{
+					Chain: append(append([]string(nil), chain[i:]...), path),
+				}
+			}
+		}
+		chain = append(chain, path)
+
+		var property, link string
+		for _, name := range []string{InlinkProperty, OutlinkProperty,
+			SourceProperty} {
+
+			if val := GetString(d.ChildByPath(name)); val != nil {
+				property, link = name, *val
+				break
+			}
+		}
+
+		if property == "" {
+			return d, nil
+		}
+
+		var target MapleData
+		var err error
+		if property == InlinkProperty {
+			target = imgRoot(d).ChildByPath(link)
+		} else {
+			target, err = getLink(d, link)
+		}
+
+		if err == nil && target != nil && target.Type() != CANVAS {
+			err = fmt.Errorf("%s is not a canvas", GetFullDataPath(target))
+		}
+		if err != nil || target == nil {
+			return nil, &BrokenLinkError{
+				Path:     path,
+				Property: property,
+				Link:     link,
+				Err:      err,
+			}
+		}
+
+		d = target
+	}
+}
+
+// ResolveImage follows the links of the canvas d and returns the decoded
+// image of the canvas that holds the actual bitmap. See ResolveCanvas.
+func ResolveImage(d MapleData) (*image.Image, error) {
+	target, err := ResolveCanvas(d)
+	if err != nil {
+		return nil, err
+	}
+
+	canvas, ok := target.Get().(MapleCanvas)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a canvas", GetFullDataPath(target))
+	}
+
+	return LoadCanvas(canvas)
+}
+
+// imgRoot walks d up to the root node of its img
+func imgRoot(d MapleData) MapleData {
+	for {
+		parent, ok := d.Parent().(MapleData)
+		if !ok || parent == nil {
+			return d
+		}
+		d = parent
+	}
+}
+
+// getLink retrieves the data at a link in the form
+// "Wz/dir/file.img/path/to/data" from the provider that owns d
+func getLink(d MapleData, link string) (MapleData, error) {
+	owner, ok := d.(dataProviderOwner)
+	if !ok || owner.dataProvider() == nil {
+		return nil, fmt.Errorf("%s doesn't belong to a provider",
+			GetFullDataPath(d))
+	}
+
+	return GetLinkedData(owner.dataProvider(), link)
+}
+
+// GetLinkedData retrieves the data at a link in the form
+// "Wz/dir/file.img/path/to/data" used by canvas outlinks from p.
+// The first segment is the name of the wz file without extension. It's
+// matched against a directory named like the wz file in wz xml trees or
+// dropped if p is the wz file itself.
+func GetLinkedData(p MapleDataProvider, link string) (MapleData, error) {
+	segments := strings.Split(link, "/")
+
+	i := 0
+	for i < len(segments) && !strings.HasSuffix(segments[i], ".img") {
+		i++
+	}
+	if i == len(segments) {
+		return nil, fmt.Errorf("Link %s doesn't contain an img", link)
+	}
+
+	imgpath := segments[:i+1]
+	candidates := []string{strings.Join(imgpath, "/")}
+	if i > 0 {
+		withExt := append([]string{imgpath[0] + ".wz"}, imgpath[1:]...)
+		candidates = append([]string{strings.Join(withExt, "/")}, candidates...)
+		candidates = append(candidates, strings.Join(imgpath[1:], "/"))
+	}
+
+	var err error
+	for _, candidate := range candidates {
+		var img MapleData
+		img, err = p.Get(candidate)
+		if err != nil || img == nil {
+			continue
+		}
+
+		if i == len(segments)-1 {
+			return img, nil
+		}
+		return img.ChildByPath(strings.Join(segments[i+1:], "/")), nil
+	}
+
+	return nil, err
+}
@@ -0,0 +1,192 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testLinkCanvas(links ...testProp) testProp {
+	return testProp{kind: "canvas",
+		value: testCanvas{width: 1, height: 1, format: 2,
+			data: testDeflate([]byte{0, 0, 0, 0})},
+		children: links}
+}
+
+func TestWZFileCanvasLink(t *testing.T) {
+	tree := testWzTree()
+	img := &tree.imgs[0]
+
+	inlink := testLinkCanvas(testProp{name: InlinkProperty, kind: "string",
+		value: "stand/0"})
+	inlink.name = "in"
+	outlink := testLinkCanvas(testProp{name: OutlinkProperty, kind: "string",
+		value: "Mob/0100100.img/link/in"})
+	outlink.name = "out"
+	broken := testLinkCanvas(testProp{name: InlinkProperty, kind: "string",
+		value: "stand/2"})
+	broken.name = "broken"
+	loop := testLinkCanvas(testProp{name: InlinkProperty, kind: "string",
+		value: "link/loop"})
+	loop.name = "loop"
+	img.props = append(img.props, testProp{name: "link", kind: "sub",
+		children: []testProp{inlink, outlink, broken, loop}})
+
+	path := writeTestWz(t, encodeTestWz(tree, 83, GMSKey))
+	f, err := NewWZFile(path, 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := f.Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"link/in", "link/out"} {
+		canvas := GetImage(data.ChildByPath(name))
+		if canvas == nil {
+			t.Errorf("0100100.img/%s: failed to follow link", name)
+			continue
+		}
+		testCanvasPixels(t, "0100100.img/"+name, *canvas,
+			map[image.Point]color.NRGBA{
+				{0, 0}: {0xFF, 0x00, 0x00, 0xFF},
+				{1, 0}: {0x00, 0x00, 0xFF, 0x80},
+			})
+	}
+
+	target, err := ResolveCanvas(data.ChildByPath("link/out"))
+	if err != nil || GetFullDataPath(target) != "0100100.img/stand/0" {
+		t.Errorf("ResolveCanvas(link/out) = %v, %v", target, err)
+	}
+
+	_, err = ResolveImage(data.ChildByPath("link/broken"))
+	var linkErr *BrokenLinkError
+	if !errors.As(err, &linkErr) || linkErr.Link != "stand/2" ||
+		linkErr.Property != InlinkProperty {
+
+		t.Errorf("link/broken: err = %v, expected a broken _inlink", err)
+	}
+
+	_, err = ResolveImage(data.ChildByPath("link/loop"))
+	var cycleErr *CanvasLinkCycleError
+	if !errors.As(err, &cycleErr) || len(cycleErr.Chain) < 2 ||
+		cycleErr.Chain[0] != cycleErr.Chain[len(cycleErr.Chain)-1] {
+
+		t.Errorf("link/loop: err = %v, expected a cycle error", err)
+	}
+}
+
+func writeTestPNG(t *testing.T, path string, c color.NRGBA) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, c)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err = png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXmlCanvasLink(t *testing.T) {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/0100100.img.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<imgdir name="0100100.img">
+	<imgdir name="stand">
+		<canvas name="0" width="1" height="1"/>
+		<canvas name="1" width="1" height="1">
+			<string name="_inlink" value="stand/0"/>
+		</canvas>
+	</imgdir>
+</imgdir>`,
+		"Skill.wz/000.img.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<imgdir name="000.img">
+	<canvas name="icon" width="1" height="1">
+		<string name="_outlink" value="Mob/0100100.img/stand/1"/>
+	</canvas>
+	<canvas name="missing" width="1" height="1">
+		<string name="source" value="Mob/0100100.img/stand/5"/>
+	</canvas>
+</imgdir>`,
+	})
+
+	red := color.NRGBA{0xFF, 0x00, 0x00, 0xFF}
+	writeTestPNG(t, filepath.Join(dir, "Mob.wz", "0100100.img", "stand",
+		"0.png"), red)
+
+	x, err := NewXml(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mob, err := x.Get("Mob.wz/0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// canvases reached through Children must find their png files as well
+	for _, child := range mob.ChildByPath("stand").Children() {
+		canvas := GetImage(child)
+		if canvas == nil {
+			t.Errorf("0100100.img/stand/%s: failed to load image",
+				child.Name())
+			continue
+		}
+		testCanvasPixels(t, "0100100.img/stand/"+child.Name(), *canvas,
+			map[image.Point]color.NRGBA{{0, 0}: red})
+	}
+
+	skill, err := x.Get("Skill.wz/000.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canvas := GetImage(skill.ChildByPath("icon"))
+	if canvas == nil {
+		t.Fatalf("000.img/icon: failed to follow _outlink")
+	}
+	testCanvasPixels(t, "000.img/icon", *canvas,
+		map[image.Point]color.NRGBA{{0, 0}: red})
+
+	c, ok := skill.ChildByPath("missing").Get().(CanvasLoader)
+	if !ok {
+		t.Fatalf("000.img/missing is not a canvas loader")
+	}
+	_, err = c.Load()
+	var linkErr *BrokenLinkError
+	if !errors.As(err, &linkErr) || linkErr.Property != SourceProperty ||
+		linkErr.Path != "000.img/missing" {
+
+		t.Errorf("000.img/missing: err = %v, expected a broken source", err)
+	}
+	if GetImage(skill.ChildByPath("missing")) != nil {
+		t.Errorf("000.img/missing: GetImage of a broken link isn't nil")
+	}
+}
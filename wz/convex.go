@@ -0,0 +1,101 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import "image"
+
+// A Convex is a polygon stored in a wz CONVEX node, used by map and
+// reactor data for hit areas. The points are in the order they are stored.
+type Convex []image.Point
+
+// convexFromChildren builds a Convex from the VECTOR children of d
+func convexFromChildren(d MapleData) Convex {
+	res := make(Convex, 0)
+	for _, child := range d.Children() {
+		if pt, ok := child.Get().(image.Point); ok {
+			res = append(res, pt)
+		}
+	}
+	return res
+}
+
+// Bounds returns the smallest rectangle that contains all of the points.
+// Like every image.Rectangle, Max is exclusive, so it's one past the
+// largest coordinates.
+func (c Convex) Bounds() image.Rectangle {
+	if len(c) == 0 {
+		return image.Rectangle{}
+	}
+
+	res := image.Rectangle{Min: c[0], Max: c[0]}
+	for _, pt := range c[1:] {
+		if pt.X < res.Min.X {
+			res.Min.X = pt.X
+		}
+		if pt.Y < res.Min.Y {
+			res.Min.Y = pt.Y
+		}
+		if pt.X > res.Max.X {
+			res.Max.X = pt.X
+		}
+		if pt.Y > res.Max.Y {
+			res.Max.Y = pt.Y
+		}
+	}
+
+	res.Max = res.Max.Add(image.Pt(1, 1))
+	return res
+}
+
+// Contains checks if pt is inside the polygon or on its edges.
+// It works for any simple polygon, not only convex ones.
+func (c Convex) Contains(pt image.Point) bool {
+	if len(c) == 0 || !pt.In(c.Bounds()) {
+		return false
+	}
+
+	inside := false
+	for i, j := 0, len(c)-1; i < len(c); j, i = i, i+1 {
+		a, b := c[i], c[j]
+
+		if onSegment(pt, a, b) {
+			return true
+		}
+
+		// cast a ray to the right and count how many edges it crosses
+		if (a.Y > pt.Y) != (b.Y > pt.Y) {
+			// x coordinate of the edge at pt.Y, compared without division
+			lhs := (pt.X - a.X) * (b.Y - a.Y)
+			rhs := (b.X - a.X) * (pt.Y - a.Y)
+			if (b.Y > a.Y && lhs < rhs) || (b.Y < a.Y && lhs > rhs) {
+				inside = !inside
+			}
+		}
+	}
+
+	return inside
+}
+
+// onSegment checks if pt lies on the segment between a and b
+func onSegment(pt, a, b image.Point) bool {
+	cross := (b.X-a.X)*(pt.Y-a.Y) - (b.Y-a.Y)*(pt.X-a.X)
+	if cross != 0 {
+		return false
+	}
+
+	return pt.X >= min(a.X, b.X) && pt.X <= max(a.X, b.X) &&
+		pt.Y >= min(a.Y, b.Y) && pt.Y <= max(a.Y, b.Y)
+}
@@ -0,0 +1,104 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestConvex(t *testing.T) {
+	// a triangle and a concave arrow shape
+	triangle := Convex{{0, 0}, {10, 0}, {0, 10}}
+	arrow := Convex{{0, 0}, {10, 5}, {0, 10}, {4, 5}}
+
+	tests := []struct {
+		c        Convex
+		pt       image.Point
+		expected bool
+	}{
+		{triangle, image.Pt(1, 1), true},
+		{triangle, image.Pt(0, 0), true},
+		{triangle, image.Pt(5, 5), true},
+		{triangle, image.Pt(0, 5), true},
+		{triangle, image.Pt(6, 6), false},
+		{triangle, image.Pt(-1, 0), false},
+		{arrow, image.Pt(6, 5), true},
+		{arrow, image.Pt(2, 5), false},
+		{arrow, image.Pt(4, 5), true},
+		{Convex{}, image.Pt(0, 0), false},
+	}
+
+	for _, test := range tests {
+		if got := test.c.Contains(test.pt); got != test.expected {
+			t.Errorf("%v.Contains(%v) = %v, expected %v",
+				test.c, test.pt, got, test.expected)
+		}
+	}
+
+	if b := arrow.Bounds(); b != image.Rect(0, 0, 11, 11) {
+		t.Errorf("bounds = %v", b)
+	}
+}
+
+func TestGetConvex(t *testing.T) {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Reactor.wz/1002000.img.xml": `<?xml version="1.0"?>
+<imgdir name="1002000.img">
+	<convex name="area">
+		<vector name="0" x="-20" y="-40"/>
+		<vector name="1" x="20" y="-40"/>
+		<vector name="2" x="0" y="0"/>
+	</convex>
+</imgdir>`,
+	})
+
+	x, err := NewXml(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := x.Get("Reactor.wz/1002000.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Convex{{-20, -40}, {20, -40}, {0, 0}}
+	if c := GetConvex(img.ChildByPath("area")); c == nil ||
+		!reflect.DeepEqual(*c, expected) {
+
+		t.Errorf("xml area = %v, expected %v", c, expected)
+	}
+	if c := GetConvexD(img.ChildByPath("missing"), nil); c != nil {
+		t.Errorf("missing convex = %v, expected nil", c)
+	}
+
+	f, err := NewWZFile(writeTestWz(t, encodeTestWz(testWzTree(), 83, BMSKey)), 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	bimg, err := f.Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = Convex{{0, 0}, {10, 0}}
+	if c := GetConvexD(bimg.ChildByPath("area"), nil); !reflect.DeepEqual(c, expected) {
+		t.Errorf("binary area = %v, expected %v", c, expected)
+	}
+}
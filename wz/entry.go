@@ -22,15 +22,15 @@ package wz
 // types of wz entries
 type Entry struct {
 	name     string
-	size     int // not used in wz xmls
-	checksum int // not used in wz xmls
-	offset   int // not used in wz xmls
+	size     int // only used in binary wz files
+	checksum int // only used in binary wz files
+	offset   int // only used in binary wz files
 	parent   MapleDataEntity
 }
 
 // NewEntry initializes a generic wz entry object
-// NOTE: esize and echecksum are not used in wx xml reading and can be
-// left zeroed
+// NOTE: esize and echecksum are only used in binary wz files and can be
+// left zeroed for wz xml
 func NewEntry(ename string, esize, echecksum int, eparent MapleDataEntity,
 ) *Entry {
 
@@ -47,3 +47,6 @@ func (e *Entry) Size() int               { return e.size }
 func (e *Entry) Checksum() int           { return e.checksum }
 func (e *Entry) Offset() int             { return e.offset }
 func (e *Entry) Parent() MapleDataEntity { return e.parent }
+
+// SetOffset sets the absolute offset of the entry's data inside the wz file
+func (e *Entry) SetOffset(offset int) { e.offset = offset }
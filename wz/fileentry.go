@@ -20,11 +20,11 @@ package wz
 // A FileEntry holds the information for a wz file entry
 type FileEntry struct {
 	*Entry
-	feoffset int // not used in xml wz, todo: check if we can use Entry's offset
 }
 
 // NewFileEntry initializes a new wz file entry object
-// NOTE: size and checksum are not used in wz xml parsing and can be left zeroed
+// NOTE: size and checksum are only used in binary wz files and can be left
+// zeroed for wz xml
 func NewFileEntry(name string, size, checksum int, parent MapleDataEntity,
 ) *FileEntry {
 
@@ -32,11 +32,3 @@ func NewFileEntry(name string, size, checksum int, parent MapleDataEntity,
 		Entry: NewEntry(name, size, checksum, parent),
 	}
 }
-
-func (e *FileEntry) Offset() int {
-	return e.feoffset
-}
-
-func (e *FileEntry) SetOffset(offset int) {
-	e.feoffset = offset
-}
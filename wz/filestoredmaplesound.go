@@ -0,0 +1,224 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// A FileStoredMapleSound is a sound that was extracted from a wz file and
+// stored next to the wz xml as a .mp3 or .wav file
+type FileStoredMapleSound struct {
+	filepath string     // path without the extension
+	fsys     fs.FS      // file system that holds the file, nil for the os one
+	mutex    sync.Mutex // guards duration, format and err
+	duration time.Duration
+	format   *WaveFormat
+	err      error // error from loading the header, if any
+}
+
+// NewFileStoredMapleSound initializes a new FileStoredMapleSound object
+// with the given path (without extension) and duration.
+// If duration is zero, it will be estimated from the file.
+func NewFileStoredMapleSound(path string, duration time.Duration,
+) *FileStoredMapleSound {
+
+	return &FileStoredMapleSound{
+		filepath: path,
+		duration: duration,
+	}
+}
+
+func (f *FileStoredMapleSound) Duration() time.Duration {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.duration == 0 {
+		f.load()
+	}
+	return f.duration
+}
+
+func (f *FileStoredMapleSound) Format() *WaveFormat {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.load()
+	return f.format
+}
+
+func (f *FileStoredMapleSound) Reader() (io.Reader, error) {
+	payload, _, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(payload), nil
+}
+
+// load parses the header of the sound file if it wasn't parsed already.
+// Failures are remembered so the file isn't read again on every call.
+func (f *FileStoredMapleSound) load() {
+	if f.format != nil || f.err != nil {
+		return
+	}
+
+	payload, format, err := f.read()
+	if err != nil {
+		f.err = err
+		return
+	}
+
+	f.format = format
+	if f.duration == 0 && format.AvgBytesPerSec != 0 {
+		f.duration = time.Duration(len(payload)) * time.Second /
+			time.Duration(format.AvgBytesPerSec)
+	}
+}
+
+// read reads the sound file and returns its payload and header
+func (f *FileStoredMapleSound) read() ([]byte, *WaveFormat, error) {
+	data, err := readFile(f.fsys, f.filepath+".mp3")
+	if err == nil {
+		format, err := parseMP3Header(data)
+		return data, format, err
+	}
+
+	data, err = readFile(f.fsys, f.filepath+".wav")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseWave(data)
+}
+
+// parseWave extracts the payload and header of a RIFF wave file
+func parseWave(data []byte) ([]byte, *WaveFormat, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" ||
+		string(data[8:12]) != "WAVE" {
+
+		return nil, nil, errors.New("Not a valid wave file")
+	}
+
+	var format *WaveFormat
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size < 0 || pos+size > len(data) {
+			break
+		}
+
+		switch id {
+		case "fmt ":
+			var err error
+			format, err = ParseWaveFormat(data[pos : pos+size])
+			if err != nil {
+				return nil, nil, err
+			}
+
+		case "data":
+			if format == nil {
+				return nil, nil, errors.New("Wave data chunk before fmt chunk")
+			}
+			return data[pos : pos+size], format, nil
+		}
+
+		pos += size + size&1
+	}
+
+	return nil, nil, errors.New("Wave file has no data chunk")
+}
+
+var mp3Bitrates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mp3SampleRates = [3]int{44100, 48000, 32000}
+
+// parseMP3Header builds a MPEGLAYER3WAVEFORMAT header from the first
+// frame of a mp3 file
+func parseMP3Header(data []byte) (*WaveFormat, error) {
+	pos := 0
+
+	// skip the id3v2 tag
+	if len(data) >= 10 && string(data[0:3]) == "ID3" {
+		pos = 10 + (int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 |
+			int(data[9]))
+	}
+
+	for ; pos+4 <= len(data); pos++ {
+		if data[pos] != 0xFF || data[pos+1]&0xE0 != 0xE0 {
+			continue
+		}
+
+		version := data[pos+1] >> 3 & 3 // 3 = mpeg1, 2 = mpeg2, 0 = mpeg2.5
+		layer := data[pos+1] >> 1 & 3   // 1 = layer III
+		bitrateIndex := int(data[pos+2] >> 4)
+		rateIndex := int(data[pos+2] >> 2 & 3)
+		padding := int(data[pos+2] >> 1 & 1)
+		mode := data[pos+3] >> 6 // 3 = mono
+
+		if version == 1 || layer != 1 || bitrateIndex == 0 ||
+			bitrateIndex == 15 || rateIndex == 3 {
+
+			continue
+		}
+
+		table := 0
+		rate := mp3SampleRates[rateIndex]
+		frameFactor := 144
+		switch version {
+		case 2:
+			table = 1
+			rate /= 2
+			frameFactor = 72
+		case 0:
+			table = 1
+			rate /= 4
+			frameFactor = 72
+		}
+
+		bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+		channels := uint16(2)
+		if mode == 3 {
+			channels = 1
+		}
+
+		extra := make([]byte, 12)
+		binary.LittleEndian.PutUint16(extra[0:], 1) // MPEGLAYER3_ID_MPEG
+		binary.LittleEndian.PutUint32(extra[2:], 0) // MPEGLAYER3_FLAG_PADDING_ISO
+		binary.LittleEndian.PutUint16(extra[6:],
+			uint16(frameFactor*bitrate/rate+padding))
+		binary.LittleEndian.PutUint16(extra[8:], 1)
+		binary.LittleEndian.PutUint16(extra[10:], 1393)
+
+		return &WaveFormat{
+			FormatTag:      WaveFormatMP3,
+			Channels:       channels,
+			SamplesPerSec:  uint32(rate),
+			AvgBytesPerSec: uint32(bitrate / 8),
+			BlockAlign:     1,
+			Extra:          extra,
+		}, nil
+	}
+
+	return nil, errors.New("No mp3 frame header found")
+}
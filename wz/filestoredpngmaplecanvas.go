@@ -18,8 +18,9 @@ package wz
 import (
 	"image"
 	_ "image/png" // must be loaded to decode png files
-	"os"
+	"io/fs"
 	"path/filepath"
+	"sync"
 )
 
 // 90% of this package is ported directly from OdinMS, so credits to them
@@ -28,9 +29,16 @@ import (
 // about a png image extracted from a wz file
 type FileStoredPngMapleCanvas struct {
 	filepath string
+	fsys     fs.FS // file system that holds the png, nil for the os one
 	width    int
 	height   int
+	mutex    sync.Mutex // guards img and decoded
 	img      *image.Image
+	linked   MapleData // canvas data whose link properties must be followed
+
+	// decoded is called with the size of the image when the canvas decodes
+	// its own image
+	decoded func(size int64)
 }
 
 // NewFileStoredPngMapleCanvas initializes a new FileStoredPngMapleCanvas object
@@ -48,30 +56,69 @@ func NewFileStoredPngMapleCanvas(w, h int, path string,
 func (f *FileStoredPngMapleCanvas) Height() int { return f.height }
 func (f *FileStoredPngMapleCanvas) Width() int  { return f.width }
 func (f *FileStoredPngMapleCanvas) Image() *image.Image {
-	f.loadImageIfNecessary()
-	return f.img
+	img, _ := f.Load()
+	return img
+}
+
+// Load loads the png file, or the image of the canvas this canvas links to,
+// and returns it. It's safe to call from multiple goroutines.
+func (f *FileStoredPngMapleCanvas) Load() (*image.Image, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	loaded := f.img != nil
+	err := f.loadImageIfNecessary()
+	if !loaded && f.img != nil && f.linked == nil && f.decoded != nil {
+		f.decoded(imageSize(*f.img))
+	}
+	return f.img, err
+}
+
+// watchDecode makes the canvas report the size of its image to fn once it
+// decodes it and returns the size of the image if it's already decoded.
+// Images of linked canvases belong to the canvas they link to.
+func (f *FileStoredPngMapleCanvas) watchDecode(fn func(size int64)) int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.decoded = fn
+	if f.img == nil || f.linked != nil {
+		return 0
+	}
+	return imageSize(*f.img)
 }
 
 // setTestPathPrefix is internally used to append the absolute path to the
 // image's path when running unit tests that are stored in temporary folders
 func (f *FileStoredPngMapleCanvas) setTestPathPrefix(prefix string) {
+	if f.fsys != nil || filepath.IsAbs(f.filepath) {
+		return // already resolved by the provider
+	}
 	f.filepath = filepath.Join(prefix, f.filepath)
 }
 
-func (f *FileStoredPngMapleCanvas) loadImageIfNecessary() {
+func (f *FileStoredPngMapleCanvas) loadImageIfNecessary() error {
 	if f.img != nil {
-		return
+		return nil
+	}
+
+	if f.linked != nil {
+		img, err := ResolveImage(f.linked)
+		if err != nil {
+			return err
+		}
+		f.img = img
+		return nil
 	}
 
-	file, err := os.Open(f.filepath)
+	file, err := openFile(f.fsys, f.filepath)
 	if err != nil {
-		return
+		return err
 	}
 	defer file.Close()
 	img, _, err := image.Decode(file)
 	if err != nil {
-		return
+		return err
 	}
 
 	f.img = &img
+	return nil
 }
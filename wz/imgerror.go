@@ -0,0 +1,103 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of errors reported by ImgError. Use errors.Is to check for them.
+var (
+	ErrMalformedImg = errors.New("Malformed img")
+	ErrNotFound     = errors.New("Wz data not found")
+)
+
+// An ImgError is returned when an img can't be found or loaded
+type ImgError struct {
+	Kind   error  // ErrMalformedImg or ErrNotFound
+	Path   string // path of the img relative to the provider
+	Line   int    // line of the problem in xml imgs, 0 if unknown
+	Column int    // column of the problem in xml imgs, 0 if unknown
+	Msg    string // description of the problem, if any
+	Err    error  // underlying error, if any
+}
+
+func (e *ImgError) Error() string {
+	msg := e.Kind.Error() + ": " + e.Path
+	if e.Line > 0 {
+		msg += fmt.Sprintf(":%d", e.Line)
+		if e.Column > 0 {
+			msg += fmt.Sprintf(":%d", e.Column)
+		}
+	}
+	if e.Msg != "" {
+		msg += ": " + e.Msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is the kind of this error
+func (e *ImgError) Is(target error) bool { return target == e.Kind }
+
+func (e *ImgError) Unwrap() error { return e.Err }
+
+// notFoundError returns an ErrNotFound ImgError for the given path
+func notFoundError(path string, err error) *ImgError {
+	return &ImgError{Kind: ErrNotFound, Path: path, Err: err}
+}
+
+// malformedImgError wraps err into an ErrMalformedImg ImgError for the given
+// path. If err already is an ImgError, its path is filled in instead.
+// The line of xml syntax errors is extracted automatically.
+func malformedImgError(path string, err error) *ImgError {
+	var imgErr *ImgError
+	if errors.As(err, &imgErr) {
+		imgErr.Path = path
+		return imgErr
+	}
+
+	res := &ImgError{Kind: ErrMalformedImg, Path: path, Err: err}
+	var syntaxErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		res.Line = syntaxErr.Line
+		res.Msg = syntaxErr.Msg
+		res.Err = nil
+	}
+	return res
+}
+
+// A ValidationError holds all the problems found while validating a tree
+// of imgs
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d problems found:\n%s", len(e.Errors),
+		strings.Join(msgs, "\n"))
+}
+
+func (e *ValidationError) Unwrap() []error { return e.Errors }
@@ -0,0 +1,88 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+// 90% of this package is ported directly from OdinMS, so credits to them
+
+import (
+	"io"
+	"time"
+)
+
+// An ImgMapleSound is a sound stored in a binary wz file
+type ImgMapleSound struct {
+	duration int // milliseconds
+	header   []byte
+	format   *WaveFormat
+	r        io.ReaderAt
+	offset   int64
+	length   int
+}
+
+// newImgMapleSound initializes an ImgMapleSound whose payload is stored at
+// the given offset in r. key is used to decrypt the WAVEFORMATEX header in
+// case it's encrypted.
+func newImgMapleSound(duration int, header []byte, r io.ReaderAt,
+	offset int64, length int, key *WzKey) *ImgMapleSound {
+
+	s := &ImgMapleSound{
+		duration: duration,
+		header:   header,
+		r:        r,
+		offset:   offset,
+		length:   length,
+	}
+
+	if len(header) <= soundHeaderSize+1 {
+		return s
+	}
+
+	wav := header[soundHeaderSize+1:]
+	s.format = parseSoundFormat(wav)
+	if s.format == nil && key != nil {
+		// some regions encrypt the header with the wz key
+		dec := make([]byte, len(wav))
+		for i := range dec {
+			dec[i] = wav[i] ^ key.At(i)
+		}
+		s.format = parseSoundFormat(dec)
+	}
+
+	return s
+}
+
+// parseSoundFormat parses a WAVEFORMATEX header that must fill the whole
+// buffer, returns nil if it doesn't
+func parseSoundFormat(b []byte) *WaveFormat {
+	format, err := ParseWaveFormat(b)
+	if err != nil || waveFormatSize+len(format.Extra) != len(b) {
+		return nil
+	}
+	return format
+}
+
+func (s *ImgMapleSound) Duration() time.Duration {
+	return time.Duration(s.duration) * time.Millisecond
+}
+
+func (s *ImgMapleSound) Format() *WaveFormat { return s.format }
+
+// Header returns the raw media type header as stored in the wz file
+func (s *ImgMapleSound) Header() []byte { return s.header }
+
+func (s *ImgMapleSound) Reader() (io.Reader, error) {
+	return io.NewSectionReader(s.r, s.offset, int64(s.length)), nil
+}
@@ -17,7 +17,10 @@ package wz
 
 // 90% of this package is ported directly from OdinMS, so credits to them
 
-import "image"
+import (
+	"errors"
+	"image"
+)
 
 // A MapleCanvas is a generic interface for png files extracted from wz files
 type MapleCanvas interface {
@@ -26,3 +29,28 @@ type MapleCanvas interface {
 	Image() *image.Image
 	setTestPathPrefix(prefix string)
 }
+
+// A CanvasLoader is a MapleCanvas that can tell why its image can't be
+// loaded. All the canvases of this package implement it.
+type CanvasLoader interface {
+	MapleCanvas
+	// Load returns the decoded image or the reason it can't be loaded
+	Load() (*image.Image, error)
+}
+
+// LoadCanvas returns the decoded image of a canvas like Image, with the
+// error of Load for canvases that implement CanvasLoader
+func LoadCanvas(c MapleCanvas) (*image.Image, error) {
+	switch c := c.(type) {
+	case nil:
+		return nil, errors.New("No canvas")
+	case CanvasLoader:
+		return c.Load()
+	}
+
+	img := c.Image()
+	if img == nil {
+		return nil, errors.New("Failed to load the canvas image")
+	}
+	return img, nil
+}
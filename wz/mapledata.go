@@ -128,13 +128,22 @@ func GetIntD(d MapleData, defval int32) int32 {
 }
 
 // GetIntConvert returns a pointer to the data's value as an int32.
-// If the data's value is a string, it will convert the string to int32.
+// If the data's value is a short or a string, it will convert it to int32.
 // returns nil if the value is not a valid int32.
 func GetIntConvert(d MapleData) *int32 {
 	if d == nil {
 		return nil
 	}
 
+	if d.Type() == SHORT {
+		val, ok := d.Get().(int16)
+		if !ok {
+			return nil
+		}
+		res := int32(val)
+		return &res
+	}
+
 	if d.Type() == STRING {
 		pstr := GetString(d)
 		if pstr == nil {
@@ -154,7 +163,7 @@ func GetIntConvert(d MapleData) *int32 {
 }
 
 // GetIntConvertD returns the data's value as an int32.
-// If the data's value is a string, it will convert the string to int32.
+// If the data's value is a short or a string, it will convert it to int32.
 // If the value can't be retrieved, defval will be returned.
 func GetIntConvertD(d MapleData, defval int32) int32 {
 	res := GetIntConvert(d)
@@ -166,11 +175,13 @@ func GetIntConvertD(d MapleData, defval int32) int32 {
 
 // GetImage returns a pointer to the data's value as a pointer to image.Image.
 // returns nil if the value is not a valid maple canvas.
+// Linked canvases return the image they link to, or nil if the link can't
+// be resolved. Use ResolveImage to find out why.
 func GetImage(d MapleData) *image.Image {
 	if d == nil {
 		return nil
 	}
-	val, ok := d.Get().(*FileStoredPngMapleCanvas)
+	val, ok := d.Get().(MapleCanvas)
 	if !ok {
 		return nil
 	}
@@ -210,6 +221,52 @@ func GetPointD(d MapleData, defval image.Point) image.Point {
 	return *res
 }
 
+// GetConvex returns a pointer to the data's value as a Convex.
+// returns nil if the value is not a valid Convex.
+func GetConvex(d MapleData) *Convex {
+	if d == nil {
+		return nil
+	}
+	val, ok := d.Get().(Convex)
+	if !ok {
+		return nil
+	}
+	return &val
+}
+
+// GetConvexD returns the data's value as a Convex.
+// If the value can't be retrieved, defval will be returned.
+func GetConvexD(d MapleData, defval Convex) Convex {
+	res := GetConvex(d)
+	if res == nil {
+		return defval
+	}
+	return *res
+}
+
+// GetSound returns the data's value as a MapleSound.
+// returns nil if the value is not a valid sound.
+func GetSound(d MapleData) MapleSound {
+	if d == nil {
+		return nil
+	}
+	val, ok := d.Get().(MapleSound)
+	if !ok {
+		return nil
+	}
+	return val
+}
+
+// GetSoundD returns the data's value as a MapleSound.
+// If the value can't be retrieved, defval will be returned.
+func GetSoundD(d MapleData, defval MapleSound) MapleSound {
+	res := GetSound(d)
+	if res == nil {
+		return defval
+	}
+	return res
+}
+
 // GetFullDataPath returns the full, absolute path to the data
 // by walking the mapledata backwards to the root node.
 func GetFullDataPath(d MapleData) string {
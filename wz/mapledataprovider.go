@@ -17,10 +17,7 @@ package wz
 
 // 90% of this package is ported directly from OdinMS, so credits to them
 
-import (
-	"errors"
-	"os"
-)
+import "os"
 
 // A MapleDataProvider is a generic interface for an object that
 // parses or provides wz data
@@ -30,9 +27,11 @@ type MapleDataProvider interface {
 }
 
 // NewMapleDataProvider analyzes the given path and provides the appropriate
-// MapleDataProvider for the format if supported. If the format is not supported
-// it will return nil.
-// At the moment, only wz xml files are supported.
+// MapleDataProvider for the format if supported. If the format is not
+// supported it will return nil.
+// Directories are opened as wz xml with NewXml, use NewXmlTree directly for
+// the faster parser. Files are opened as binary wz files and their version
+// and region key are detected automatically.
 func NewMapleDataProvider(path string) (res MapleDataProvider, err error) {
 	res = nil
 	file, err := os.Open(path)
@@ -42,10 +41,17 @@ func NewMapleDataProvider(path string) (res MapleDataProvider, err error) {
 	defer file.Close()
 
 	fi, err := file.Stat()
-	if !fi.IsDir() { // TODO: support Wz files
-		err = errors.New("Wz files are not supported yet, please use wz xml's")
+	if err != nil {
 		return
 	}
 
+	if !fi.IsDir() {
+		wzfile, werr := openWZFile(path, -1, nil)
+		if werr != nil {
+			return nil, werr
+		}
+		return wzfile, nil
+	}
+
 	return NewXml(path)
 }
@@ -15,6 +15,8 @@
 
 package wz
 
+import "strconv"
+
 // 90% of this package is ported directly from OdinMS, so credits to them
 
 // A MapleDataType is an integer that describes the datatype of a wz entry
@@ -39,4 +41,34 @@ const (
 	UNKNOWN_TYPE
 	UNKNOWN_EXTENDED_TYPE
 	INVALID
+	LONG
 )
+
+var mapleDataTypeNames = [...]string{
+	NONE:                  "NONE",
+	IMG_0x00:              "IMG_0x00",
+	SHORT:                 "SHORT",
+	INT:                   "INT",
+	FLOAT:                 "FLOAT",
+	DOUBLE:                "DOUBLE",
+	STRING:                "STRING",
+	EXTENDED:              "EXTENDED",
+	PROPERTY:              "PROPERTY",
+	CANVAS:                "CANVAS",
+	VECTOR:                "VECTOR",
+	CONVEX:                "CONVEX",
+	SOUND:                 "SOUND",
+	UOL:                   "UOL",
+	UNKNOWN_TYPE:          "UNKNOWN_TYPE",
+	UNKNOWN_EXTENDED_TYPE: "UNKNOWN_EXTENDED_TYPE",
+	INVALID:               "INVALID",
+	LONG:                  "LONG",
+}
+
+// String returns the name of the data type
+func (t MapleDataType) String() string {
+	if t < 0 || int(t) >= len(mapleDataTypeNames) {
+		return "MapleDataType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return mapleDataTypeNames[t]
+}
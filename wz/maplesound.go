@@ -0,0 +1,168 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Wave format tags of the sounds stored in wz files
+const (
+	WaveFormatPCM = 0x0001
+	WaveFormatMP3 = 0x0055
+)
+
+// waveFormatSize is the size of a WAVEFORMATEX header without extra data
+const waveFormatSize = 18
+
+// A MapleSound is a generic interface for audio extracted from wz files
+type MapleSound interface {
+	// Duration returns the length of the sound
+	Duration() time.Duration
+	// Format returns the WAVEFORMATEX header of the sound or nil if
+	// the sound has no valid header
+	Format() *WaveFormat
+	// Reader returns a reader over the raw audio payload, which is
+	// mp3 frames or pcm samples depending on the format
+	Reader() (io.Reader, error)
+}
+
+// A WaveFormat holds the fields of a WAVEFORMATEX header
+type WaveFormat struct {
+	FormatTag      uint16
+	Channels       uint16
+	SamplesPerSec  uint32
+	AvgBytesPerSec uint32
+	BlockAlign     uint16
+	BitsPerSample  uint16
+	Extra          []byte // format specific data that follows the header
+}
+
+// A MP3Format holds the mp3 specific fields of a MPEGLAYER3WAVEFORMAT header
+type MP3Format struct {
+	ID             uint16
+	Flags          uint32
+	BlockSize      uint16
+	FramesPerBlock uint16
+	CodecDelay     uint16
+}
+
+// ParseWaveFormat parses a little endian WAVEFORMATEX header
+func ParseWaveFormat(b []byte) (*WaveFormat, error) {
+	if len(b) < waveFormatSize-2 {
+		return nil, errors.New("WAVEFORMATEX header is too short")
+	}
+
+	res := &WaveFormat{
+		FormatTag:      binary.LittleEndian.Uint16(b[0:]),
+		Channels:       binary.LittleEndian.Uint16(b[2:]),
+		SamplesPerSec:  binary.LittleEndian.Uint32(b[4:]),
+		AvgBytesPerSec: binary.LittleEndian.Uint32(b[8:]),
+		BlockAlign:     binary.LittleEndian.Uint16(b[12:]),
+		BitsPerSample:  binary.LittleEndian.Uint16(b[14:]),
+	}
+
+	// plain WAVEFORMAT headers don't have the cbSize field
+	if len(b) >= waveFormatSize {
+		extra := int(binary.LittleEndian.Uint16(b[16:]))
+		if waveFormatSize+extra > len(b) {
+			return nil, errors.New("WAVEFORMATEX extra data is truncated")
+		}
+		res.Extra = append([]byte(nil), b[waveFormatSize:waveFormatSize+extra]...)
+	}
+
+	return res, nil
+}
+
+// Bytes encodes the header as a little endian WAVEFORMATEX structure
+func (w *WaveFormat) Bytes() []byte {
+	res := make([]byte, waveFormatSize+len(w.Extra))
+	binary.LittleEndian.PutUint16(res[0:], w.FormatTag)
+	binary.LittleEndian.PutUint16(res[2:], w.Channels)
+	binary.LittleEndian.PutUint32(res[4:], w.SamplesPerSec)
+	binary.LittleEndian.PutUint32(res[8:], w.AvgBytesPerSec)
+	binary.LittleEndian.PutUint16(res[12:], w.BlockAlign)
+	binary.LittleEndian.PutUint16(res[14:], w.BitsPerSample)
+	binary.LittleEndian.PutUint16(res[16:], uint16(len(w.Extra)))
+	copy(res[waveFormatSize:], w.Extra)
+	return res
+}
+
+// MP3 returns the mp3 specific fields of the header.
+// returns nil if the format is not mp3 or the fields are missing.
+func (w *WaveFormat) MP3() *MP3Format {
+	if w.FormatTag != WaveFormatMP3 || len(w.Extra) < 12 {
+		return nil
+	}
+
+	return &MP3Format{
+		ID:             binary.LittleEndian.Uint16(w.Extra[0:]),
+		Flags:          binary.LittleEndian.Uint32(w.Extra[2:]),
+		BlockSize:      binary.LittleEndian.Uint16(w.Extra[6:]),
+		FramesPerBlock: binary.LittleEndian.Uint16(w.Extra[8:]),
+		CodecDelay:     binary.LittleEndian.Uint16(w.Extra[10:]),
+	}
+}
+
+// SoundExtension returns the file extension (.mp3 or .wav) that
+// ExportSound will produce for the given sound
+func SoundExtension(s MapleSound) string {
+	format := s.Format()
+	if format == nil || format.FormatTag == WaveFormatMP3 {
+		return ".mp3"
+	}
+	return ".wav"
+}
+
+// ExportSound writes the sound as a playable file to w.
+// mp3 sounds are written as raw mp3 frames, every other format is wrapped
+// in a RIFF wave file. See SoundExtension.
+func ExportSound(w io.Writer, s MapleSound) error {
+	r, err := s.Reader()
+	if err != nil {
+		return err
+	}
+
+	if SoundExtension(s) == ".mp3" {
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	format := s.Format().Bytes()
+	header := make([]byte, 0, 28+len(format))
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header,
+		uint32(4+8+len(format)+8+len(payload)))
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(format)))
+	header = append(header, format...)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(payload)))
+
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
@@ -0,0 +1,221 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testPCMFormat = &WaveFormat{
+	FormatTag:      WaveFormatPCM,
+	Channels:       2,
+	SamplesPerSec:  44100,
+	AvgBytesPerSec: 176400,
+	BlockAlign:     4,
+	BitsPerSample:  16,
+}
+
+// testSoundHeader builds the media type header of a sound property,
+// optionally encrypting the WAVEFORMATEX with key
+func testSoundHeader(format *WaveFormat, key *WzKey) []byte {
+	wav := format.Bytes()
+	if key != nil {
+		for i := range wav {
+			wav[i] ^= key.At(i)
+		}
+	}
+
+	header := make([]byte, soundHeaderSize, soundHeaderSize+1+len(wav))
+	header = append(header, byte(len(wav)))
+	return append(header, wav...)
+}
+
+func checkTestSound(t *testing.T, name string, s MapleSound,
+	duration time.Duration, format *WaveFormat, payload []byte) {
+
+	if s == nil {
+		t.Errorf("%s: not a sound", name)
+		return
+	}
+
+	if s.Duration() != duration {
+		t.Errorf("%s: duration = %v, expected %v", name, s.Duration(), duration)
+	}
+	if !reflect.DeepEqual(s.Format(), format) {
+		t.Errorf("%s: format = %+v, expected %+v", name, s.Format(), format)
+	}
+
+	r, err := s.Reader()
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	data, _ := io.ReadAll(r)
+	if !bytes.Equal(data, payload) {
+		t.Errorf("%s: payload = % X, expected % X", name, data, payload)
+	}
+}
+
+func TestWZFileSound(t *testing.T) {
+	payload := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tree := testWzTree()
+	tree.imgs = append(tree.imgs, testImg{
+		name: "Bgm00.img",
+		props: []testProp{
+			{name: "FloralLife", kind: "sound", value: testSound{
+				duration: 1500,
+				header:   testSoundHeader(testPCMFormat, nil),
+				data:     payload,
+			}},
+			{name: "Encrypted", kind: "sound", value: testSound{
+				duration: 20,
+				header:   testSoundHeader(testPCMFormat, GMSKey),
+				data:     payload[:4],
+			}},
+		},
+	})
+
+	f, err := NewWZFile(writeTestWz(t, encodeTestWz(tree, 83, GMSKey)), 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := f.Get("Bgm00.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := GetSound(img.ChildByPath("FloralLife"))
+	checkTestSound(t, "FloralLife", s, 1500*time.Millisecond, testPCMFormat,
+		payload)
+	checkTestSound(t, "Encrypted", GetSound(img.ChildByPath("Encrypted")),
+		20*time.Millisecond, testPCMFormat, payload[:4])
+
+	if ext := SoundExtension(s); ext != ".wav" {
+		t.Errorf("pcm sound extension = %s", ext)
+	}
+
+	var b bytes.Buffer
+	if err = ExportSound(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	data, format, err := parseWave(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, payload) || !reflect.DeepEqual(format, testPCMFormat) {
+		t.Errorf("exported wave = %+v % X", format, data)
+	}
+}
+
+func TestXmlSound(t *testing.T) {
+	// wave sidecar
+	payload := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	var b bytes.Buffer
+	ExportSound(&b, newImgMapleSound(0, testSoundHeader(testPCMFormat, nil),
+		bytes.NewReader(payload), 0, len(payload), nil))
+
+	// mpeg1 layer III, 128kbps, 44100hz, joint stereo
+	mp3 := make([]byte, 417*2)
+	copy(mp3, []byte{0xFF, 0xFB, 0x90, 0x44})
+	copy(mp3[417:], []byte{0xFF, 0xFB, 0x90, 0x44})
+
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Sound.wz/Bgm00.img.xml": `<?xml version="1.0"?>
+<imgdir name="Bgm00.img">
+	<sound name="FloralLife" length="1500"/>
+	<sound name="Title"/>
+</imgdir>`,
+		"Sound.wz/Bgm00.img/FloralLife.wav": b.String(),
+		"Sound.wz/Bgm00.img/Title.mp3":      string(mp3),
+	})
+
+	x, err := NewXml(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := x.Get("Sound.wz/Bgm00.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := GetSound(img.ChildByPath("FloralLife"))
+	if s == nil {
+		t.Fatalf("Bgm00.img/FloralLife is not a sound")
+	}
+	checkTestSound(t, "FloralLife", s, 1500*time.Millisecond, testPCMFormat,
+		payload)
+
+	s = GetSound(img.ChildByPath("Title"))
+	if s == nil {
+		t.Fatalf("Bgm00.img/Title is not a sound")
+	}
+
+	mp3format := &WaveFormat{
+		FormatTag:      WaveFormatMP3,
+		Channels:       2,
+		SamplesPerSec:  44100,
+		AvgBytesPerSec: 16000,
+		BlockAlign:     1,
+		Extra: []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0xA1, 0x01,
+			0x01, 0x00, 0x71, 0x05},
+	}
+	checkTestSound(t, "Title", s, 52125*time.Microsecond, mp3format, mp3)
+
+	if m := s.Format().MP3(); m == nil || m.BlockSize != 417 {
+		t.Errorf("Title: mp3 fields = %+v", m)
+	}
+	if ext := SoundExtension(s); ext != ".mp3" {
+		t.Errorf("mp3 sound extension = %s", ext)
+	}
+}
+
+func TestParseMP3Header(t *testing.T) {
+	// id3v2 tag with 10 bytes of data that look like a mpeg2 frame header,
+	// followed by a mpeg1 layer III, 128kbps, 44100hz frame
+	data := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 10,
+		0xFF, 0xF3, 0x10, 0xC4, 0, 0, 0, 0, 0, 0,
+		0xFF, 0xFB, 0x90, 0x44}
+
+	format, err := parseMP3Header(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.SamplesPerSec != 44100 || format.AvgBytesPerSec != 16000 {
+		t.Errorf("mp3 after an id3 tag = %d hz, %d bytes/s",
+			format.SamplesPerSec, format.AvgBytesPerSec)
+	}
+}
+
+func TestFileStoredSoundError(t *testing.T) {
+	fsys := fstest.MapFS{}
+	s := &FileStoredMapleSound{filepath: "Title", fsys: fsys}
+	if s.Format() != nil {
+		t.Fatal("a missing sound file has a format")
+	}
+
+	// the failure is cached, the file isn't looked up again
+	fsys["Title.mp3"] = &fstest.MapFile{Data: []byte{0xFF, 0xFB, 0x90, 0x44}}
+	if s.Format() != nil || s.Duration() != 0 {
+		t.Error("the sound file was read again after failing")
+	}
+}
@@ -0,0 +1,330 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// A MemMapleData is a node of a mutable tree held in memory. It can be built
+// from scratch or copied from any MapleData with NewMemMapleDataFrom, then
+// edited and written back with WriteXmlImg.
+type MemMapleData struct {
+	name     string
+	datatype MapleDataType
+	data     interface{}
+	parent   *MemMapleData
+	children []*MemMapleData
+	provider MapleDataProvider // only set on the root node
+}
+
+// NewMemProperty initializes an empty PROPERTY node, such as an img root.
+// Like every other node, it can only be added to a parent if its name is
+// valid, see Rename.
+func NewMemProperty(name string) *MemMapleData {
+	return &MemMapleData{name: name, datatype: PROPERTY}
+}
+
+// NewMemMapleData initializes a node with the given value. See SetValue for
+// the values each data type accepts and Rename for valid names.
+func NewMemMapleData(name string, datatype MapleDataType, value interface{},
+) (*MemMapleData, error) {
+
+	if err := checkMemName(name); err != nil {
+		return nil, err
+	}
+	if err := checkMemValue(datatype, value); err != nil {
+		return nil, err
+	}
+	return &MemMapleData{name: name, datatype: datatype, data: value}, nil
+}
+
+// NewMemMapleDataFrom copies d and all of its children into a new tree.
+// Values are copied as they are, so canvases and sounds still load their
+// data from where d loads it.
+func NewMemMapleDataFrom(d MapleData) *MemMapleData {
+	res := memCopy(d, nil)
+	if owner, ok := d.(dataProviderOwner); ok {
+		res.provider = owner.dataProvider()
+	}
+	return res
+}
+
+// memCopy recursively copies d to a new node with the given parent
+func memCopy(d MapleData, parent *MemMapleData) *MemMapleData {
+	res := &MemMapleData{
+		name:     d.Name(),
+		datatype: d.Type(),
+		parent:   parent,
+	}
+	if res.datatype != CONVEX {
+		res.data = d.Get()
+	}
+	for _, child := range d.Children() {
+		res.children = append(res.children, memCopy(child, res))
+	}
+	return res
+}
+
+// checkMemName checks that name can be used as a path segment
+func checkMemName(name string) error {
+	if name == "" || name == "." || name == ".." ||
+		strings.Contains(name, "/") {
+
+		return fmt.Errorf("Invalid data name %q", name)
+	}
+	return nil
+}
+
+// checkMemValue checks that value is a valid go value for the data type
+func checkMemValue(datatype MapleDataType, value interface{}) error {
+	ok := false
+	switch datatype {
+	case PROPERTY, CONVEX, IMG_0x00:
+		ok = value == nil
+	case DOUBLE:
+		_, ok = value.(float64)
+	case FLOAT:
+		_, ok = value.(float32)
+	case LONG:
+		_, ok = value.(int64)
+	case INT:
+		_, ok = value.(int32)
+	case SHORT:
+		_, ok = value.(int16)
+	case STRING, UOL:
+		_, ok = value.(string)
+	case VECTOR:
+		_, ok = value.(image.Point)
+	case CANVAS:
+		_, ok = value.(MapleCanvas)
+	case SOUND:
+		_, ok = value.(MapleSound)
+	default:
+		return fmt.Errorf("Cannot create %v data", datatype)
+	}
+
+	if !ok {
+		return fmt.Errorf("Cannot store a %T value in %v data", value, datatype)
+	}
+	return nil
+}
+
+// hasChildren checks if nodes of the data type can have children
+func hasChildren(datatype MapleDataType) bool {
+	return datatype == PROPERTY || datatype == CANVAS || datatype == CONVEX
+}
+
+// dataProvider returns the provider canvas links are resolved through
+func (d *MemMapleData) dataProvider() MapleDataProvider {
+	for d.parent != nil {
+		d = d.parent
+	}
+	return d.provider
+}
+
+// child returns the direct child with the given name or nil
+func (d *MemMapleData) child(name string) *MemMapleData {
+	for _, c := range d.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ChildByPath finds and returns a value by path relative to this node.
+// ".." segments walk to the parent node.
+func (d *MemMapleData) ChildByPath(path string) MapleData {
+	cur := d
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			cur = cur.parent
+		} else {
+			cur = cur.child(segment)
+		}
+
+		if cur == nil {
+			return nil
+		}
+	}
+
+	return cur
+}
+
+// Children returns the children nodes of this node
+func (d *MemMapleData) Children() []MapleData {
+	res := make([]MapleData, len(d.children))
+	for i, c := range d.children {
+		res[i] = c
+	}
+	return res
+}
+
+// Get returns the value of this node as an interface, see WZIMGEntry.Get
+func (d *MemMapleData) Get() interface{} {
+	if d.datatype == CONVEX {
+		return convexFromChildren(d)
+	}
+	return d.data
+}
+
+// Type returns the maple data type of this node
+func (d *MemMapleData) Type() MapleDataType { return d.datatype }
+
+// Name returns the name of this node
+func (d *MemMapleData) Name() string { return d.name }
+
+// Parent returns the parent node of this node or nil for the root
+func (d *MemMapleData) Parent() MapleDataEntity {
+	if d.parent == nil {
+		return nil
+	}
+	return d.parent
+}
+
+// SetValue changes the type and value of this node. The value must have the
+// go type Get returns for the data type: float64, float32, int64, int32,
+// int16, string, image.Point, MapleCanvas or MapleSound. PROPERTY, CONVEX and
+// IMG_0x00 nodes have a nil value. Only PROPERTY, CANVAS and CONVEX nodes
+// can have children.
+func (d *MemMapleData) SetValue(datatype MapleDataType, value interface{},
+) error {
+
+	if err := checkMemValue(datatype, value); err != nil {
+		return err
+	}
+	if len(d.children) > 0 && !hasChildren(datatype) {
+		return fmt.Errorf("%s has children, it can't become %v data",
+			GetFullDataPath(d), datatype)
+	}
+	if datatype == CONVEX {
+		for _, c := range d.children {
+			if c.datatype != VECTOR {
+				return fmt.Errorf("%s has non-VECTOR children, it can't "+
+					"become CONVEX data", GetFullDataPath(d))
+			}
+		}
+	}
+
+	d.datatype = datatype
+	d.data = value
+	return nil
+}
+
+// SetChildByPath sets the value of the node at path relative to this node,
+// creating it and any missing PROPERTY node along the path. See SetValue.
+func (d *MemMapleData) SetChildByPath(path string, datatype MapleDataType,
+	value interface{}) (*MemMapleData, error) {
+
+	segments := strings.Split(path, "/")
+	cur := d
+	for _, segment := range segments[:len(segments)-1] {
+		next := cur.child(segment)
+		if next == nil {
+			next = NewMemProperty(segment)
+			if err := cur.AddChild(next); err != nil {
+				return nil, err
+			}
+		}
+		cur = next
+	}
+
+	name := segments[len(segments)-1]
+	child := cur.child(name)
+	var err error
+	if child != nil {
+		err = child.SetValue(datatype, value)
+	} else if child, err = NewMemMapleData(name, datatype, value); err == nil {
+		err = cur.AddChild(child)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// AddChild appends child, which must not have a parent and must have a
+// valid name (see Rename), to the children of this node
+func (d *MemMapleData) AddChild(child *MemMapleData) error {
+	if err := checkMemName(child.name); err != nil {
+		return err
+	}
+
+	switch {
+	case !hasChildren(d.datatype):
+		return fmt.Errorf("%s is %v data, it can't have children",
+			GetFullDataPath(d), d.datatype)
+	case d.datatype == CONVEX && child.datatype != VECTOR:
+		return fmt.Errorf("%s is CONVEX data, it can only have VECTOR "+
+			"children", GetFullDataPath(d))
+	case child.parent != nil:
+		return fmt.Errorf("%s already has a parent", GetFullDataPath(child))
+	case d.child(child.name) != nil:
+		return fmt.Errorf("%s already has a child named %q",
+			GetFullDataPath(d), child.name)
+	}
+
+	for p := d; p != nil; p = p.parent {
+		if p == child {
+			return fmt.Errorf("%s can't be added to its own children",
+				GetFullDataPath(child))
+		}
+	}
+
+	child.parent = d
+	child.provider = nil
+	d.children = append(d.children, child)
+	return nil
+}
+
+// RemoveChild detaches the child with the given name and returns it, or
+// returns nil if there is no such child
+func (d *MemMapleData) RemoveChild(name string) *MemMapleData {
+	for i, c := range d.children {
+		if c.name == name {
+			d.children = append(d.children[:i:i], d.children[i+1:]...)
+			c.parent = nil
+			return c
+		}
+	}
+	return nil
+}
+
+// Rename changes the name of this node. The name can't be empty, "." or
+// "..", contain slashes or be the name of a sibling.
+func (d *MemMapleData) Rename(name string) error {
+	if err := checkMemName(name); err != nil {
+		return err
+	}
+	if d.parent != nil && name != d.name && d.parent.child(name) != nil {
+		return fmt.Errorf("%s already has a child named %q",
+			GetFullDataPath(d.parent), name)
+	}
+	d.name = name
+	return nil
+}
+
+// Clone returns a deep copy of this node and its children without a
+// parent. Canvas and sound values are shared with the copy.
+func (d *MemMapleData) Clone() *MemMapleData {
+	res := memCopy(d, nil)
+	res.provider = d.dataProvider()
+	return res
+}
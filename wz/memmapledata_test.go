@@ -0,0 +1,170 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMemMapleData(t *testing.T) {
+	img := NewMemProperty("9999999.img")
+	steps := []struct {
+		path     string
+		datatype MapleDataType
+		value    interface{}
+	}{
+		{"info/level", INT, int32(10)},
+		{"info/speed", SHORT, int16(-20)},
+		{"info/name", STRING, "Custom"},
+		{"info/level", INT, int32(11)},
+		{"foothold", CONVEX, nil},
+		{"foothold/0", VECTOR, image.Pt(1, 2)},
+		{"foothold/1", VECTOR, image.Pt(3, 4)},
+	}
+	for _, step := range steps {
+		_, err := img.SetChildByPath(step.path, step.datatype, step.value)
+		if err != nil {
+			t.Fatalf("%s: %v", step.path, err)
+		}
+	}
+
+	if GetIntD(img.ChildByPath("info/level"), 0) != 11 {
+		t.Error("info/level was not updated")
+	}
+	if len(img.ChildByPath("info").Children()) != 3 {
+		t.Error("info/level was added twice")
+	}
+	convex := GetConvex(img.ChildByPath("foothold"))
+	if convex == nil || !reflect.DeepEqual(*convex,
+		Convex{image.Pt(1, 2), image.Pt(3, 4)}) {
+
+		t.Errorf("foothold = %v", convex)
+	}
+	if img.ChildByPath("info/level/../..") != img {
+		t.Error("info/level/../.. is not the img")
+	}
+	if path := GetFullDataPath(img.ChildByPath("info/name")); path !=
+		"9999999.img/info/name" {
+
+		t.Errorf("full path = %s", path)
+	}
+
+	clone := img.Clone()
+	info := img.ChildByPath("info").(*MemMapleData)
+	if err := info.Rename("stats"); err != nil {
+		t.Fatal(err)
+	}
+	if removed := img.RemoveChild("foothold"); removed == nil ||
+		removed.Parent() != nil {
+
+		t.Error("foothold was not removed")
+	}
+	if img.ChildByPath("info") != nil || img.ChildByPath("foothold") != nil ||
+		img.ChildByPath("stats/speed") == nil {
+
+		t.Error("the img was not edited")
+	}
+	if clone.ChildByPath("info/speed") == nil ||
+		clone.ChildByPath("foothold/1") == nil {
+
+		t.Error("editing the img changed its clone")
+	}
+
+	var b bytes.Buffer
+	if err := WriteXmlImg(&b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ParseXmlImg(strings.NewReader(b.String()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareTestData(t, "9999999.img", img, read)
+}
+
+func TestMemMapleDataErrors(t *testing.T) {
+	img := NewMemProperty("x.img")
+	level, err := img.SetChildByPath("info/level", INT, int32(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := img.ChildByPath("info").(*MemMapleData)
+	convex, _ := NewMemMapleData("convex", CONVEX, nil)
+	img.AddChild(convex)
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"wrong go type", level.SetValue(INT, 1)},
+		{"nil value", level.SetValue(STRING, nil)},
+		{"unsupported type", level.SetValue(EXTENDED, nil)},
+		{"children of INT", level.AddChild(NewMemProperty("x"))},
+		{"property becomes INT", info.SetValue(INT, int32(1))},
+		{"property in convex", convex.AddChild(NewMemProperty("0"))},
+		{"child with parent", img.AddChild(level)},
+		{"duplicate name", info.AddChild(NewMemProperty("level"))},
+		{"cycle", info.AddChild(img)},
+		{"rename to sibling", info.Rename("convex")},
+		{"rename with slash", info.Rename("a/b")},
+		{"empty child name", info.AddChild(NewMemProperty(""))},
+		{"child name with slash", info.AddChild(NewMemProperty("x/y"))},
+		{"dot child name", info.AddChild(NewMemProperty(".."))},
+	}
+	for _, test := range tests {
+		if test.err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	if _, err = NewMemMapleData("x", VECTOR, "1,2"); err == nil {
+		t.Error("NewMemMapleData: expected an error")
+	}
+	for _, name := range []string{"", "a/b", "."} {
+		if _, err = NewMemMapleData(name, INT, int32(1)); err == nil {
+			t.Errorf("NewMemMapleData(%q): expected an error", name)
+		}
+	}
+	if _, err = img.SetChildByPath("a//b", INT, int32(1)); err == nil {
+		t.Error("SetChildByPath with an empty segment: expected an error")
+	}
+	if img.RemoveChild("missing") != nil {
+		t.Error("RemoveChild returned a missing child")
+	}
+}
+
+func TestNewMemMapleDataFrom(t *testing.T) {
+	x, err := NewXmlTree(testfilesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range testImgPaths(x.Root(), "") {
+		img, err := x.Get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mem := NewMemMapleDataFrom(img)
+		compareTestData(t, path, img, mem)
+		if mem.dataProvider() != x {
+			t.Errorf("%s: canvas links are not resolved through the tree",
+				path)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"strings"
+)
+
+// An OverlayMapleDataProvider stacks several providers, such as custom
+// content patches on top of the original data. Get returns the img from
+// the top-most provider that has it. With img merging enabled, the imgs of
+// all the providers that have the path are merged instead, so a patch only
+// needs to contain the properties it adds or overrides.
+//
+// The returned data is made of OverlayMapleData nodes, which report the
+// provider each node comes from.
+type OverlayMapleDataProvider struct {
+	layers []MapleDataProvider // top-most first
+	merge  bool
+	root   *DirectoryEntry
+}
+
+// NewOverlayMapleDataProvider stacks the given providers. The first one is
+// the top-most layer and the last one is the bottom layer. The directory
+// tree returned by Root is merged from the layers when the overlay is
+// created.
+func NewOverlayMapleDataProvider(layers ...MapleDataProvider,
+) *OverlayMapleDataProvider {
+
+	o := &OverlayMapleDataProvider{layers: layers}
+	for _, layer := range layers {
+		root := layer.Root()
+		if root == nil {
+			continue
+		}
+		if o.root == nil {
+			o.root = NewDirectoryEntry(root.Name(), 0, 0, nil)
+		}
+		mergeDirectory(o.root, root)
+	}
+	if o.root == nil {
+		o.root = EmptyDirectoryEntry()
+	}
+
+	return o
+}
+
+// mergeDirectory adds the subdirectories and files of src that dst doesn't
+// have yet to dst
+func mergeDirectory(dst *DirectoryEntry, src MapleDataDirectoryEntry) {
+	for _, sub := range src.Subdirectories() {
+		subdst, ok := dst.GetEntry(sub.Name()).(*DirectoryEntry)
+		if !ok {
+			if dst.GetEntry(sub.Name()) != nil {
+				continue // shadowed by a file
+			}
+			subdst = NewDirectoryEntry(sub.Name(), sub.Size(), sub.Checksum(),
+				dst)
+			dst.AddDirectory(subdst)
+		}
+		mergeDirectory(subdst, sub)
+	}
+
+	for _, f := range src.Files() {
+		if dst.GetEntry(f.Name()) == nil {
+			dst.AddFile(NewFileEntry(f.Name(), f.Size(), f.Checksum(), dst))
+		}
+	}
+}
+
+// SetMergeImgs enables or disables merging imgs across layers. It's disabled
+// by default.
+func (o *OverlayMapleDataProvider) SetMergeImgs(merge bool) { o.merge = merge }
+
+// Layers returns the stacked providers, top-most first. The layer indices
+// reported by OverlayMapleData refer to this slice.
+func (o *OverlayMapleDataProvider) Layers() []MapleDataProvider {
+	return o.layers
+}
+
+// Root returns the merged directory tree of all the layers
+func (o *OverlayMapleDataProvider) Root() MapleDataDirectoryEntry {
+	return o.root
+}
+
+// Get returns the img at the given path from the top-most layer that has
+// it, or the merge of all the layers that have it if img merging is
+// enabled. Layers that don't have the img must return an error that matches
+// ErrNotFound, any other error is returned.
+// If a node has a different type in two layers, the upper one hides the
+// lower one completely. Otherwise, the value comes from the upper layer and
+// the children of both are merged, with new children appended after the
+// ones of the lower layer.
+func (o *OverlayMapleDataProvider) Get(path string) (MapleData, error) {
+	var imgs []MapleData
+	var layers []int
+
+	for i, layer := range o.layers {
+		img, err := layer.Get(path)
+		if errors.Is(err, ErrNotFound) || err == nil && img == nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		imgs = append(imgs, img)
+		layers = append(layers, i)
+		if !o.merge {
+			break
+		}
+	}
+
+	if len(imgs) == 0 {
+		return nil, notFoundError(path, nil)
+	}
+	return newOverlayMapleData(nil, imgs, layers), nil
+}
+
+// An OverlayMapleData is a node of an img returned by
+// OverlayMapleDataProvider. It wraps the nodes with the same path in all
+// the merged layers.
+type OverlayMapleData struct {
+	parent   *OverlayMapleData
+	nodes    []MapleData // top-most first
+	layers   []int       // layer index of each node
+	children []*OverlayMapleData
+}
+
+// overlayNodes collects the nodes with the same path across layers
+type overlayNodes struct {
+	nodes  []MapleData
+	layers []int
+}
+
+// newOverlayMapleData merges nodes, which must all have the same path
+func newOverlayMapleData(parent *OverlayMapleData, nodes []MapleData,
+	layers []int) *OverlayMapleData {
+
+	// lower layers with a different type are hidden
+	n := 1
+	for n < len(nodes) && nodes[n].Type() == nodes[0].Type() {
+		n++
+	}
+
+	d := &OverlayMapleData{parent: parent, nodes: nodes[:n],
+		layers: layers[:n]}
+
+	// merge children bottom up so the original order is preserved
+	var names []string
+	merged := map[string]*overlayNodes{}
+
+	for i := n - 1; i >= 0; i-- {
+		for _, child := range nodes[i].Children() {
+			m, ok := merged[child.Name()]
+			if !ok {
+				m = &overlayNodes{}
+				merged[child.Name()] = m
+				names = append(names, child.Name())
+			}
+			m.nodes = append([]MapleData{child}, m.nodes...)
+			m.layers = append([]int{layers[i]}, m.layers...)
+		}
+	}
+
+	for _, name := range names {
+		m := merged[name]
+		d.children = append(d.children, newOverlayMapleData(d, m.nodes,
+			m.layers))
+	}
+
+	return d
+}
+
+// Layer returns the index of the layer the value of this node comes from
+func (d *OverlayMapleData) Layer() int { return d.layers[0] }
+
+// Layers returns the indices of all the layers that define this node,
+// top-most first
+func (d *OverlayMapleData) Layers() []int { return d.layers }
+
+// Unwrap returns the node of the top-most layer that defines this node
+func (d *OverlayMapleData) Unwrap() MapleData { return d.nodes[0] }
+
+func (d *OverlayMapleData) Name() string { return d.nodes[0].Name() }
+
+func (d *OverlayMapleData) Parent() MapleDataEntity {
+	if d.parent == nil {
+		return nil
+	}
+	return d.parent
+}
+
+func (d *OverlayMapleData) Type() MapleDataType { return d.nodes[0].Type() }
+
+func (d *OverlayMapleData) Get() interface{} {
+	if d.Type() == CONVEX {
+		return convexFromChildren(d)
+	}
+	return d.nodes[0].Get()
+}
+
+func (d *OverlayMapleData) Children() []MapleData {
+	res := make([]MapleData, len(d.children))
+	for i, c := range d.children {
+		res[i] = c
+	}
+	return res
+}
+
+// ChildByPath finds and returns a value by path relative to this node.
+// ".." segments walk to the parent node.
+func (d *OverlayMapleData) ChildByPath(path string) MapleData {
+	cur := d
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			cur = cur.parent
+		} else {
+			var next *OverlayMapleData
+			for _, c := range cur.children {
+				if c.Name() == segment {
+					next = c
+					break
+				}
+			}
+			cur = next
+		}
+
+		if cur == nil {
+			return nil
+		}
+	}
+
+	return cur
+}
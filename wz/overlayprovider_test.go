@@ -0,0 +1,153 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// testOverlay returns an overlay of a patch layer on top of a base layer
+func testOverlay(t *testing.T, merge bool) *OverlayMapleDataProvider {
+	base, err := NewXmlTreeFS(fstest.MapFS{
+		"Mob.wz/0100100.img.xml": {Data: []byte(`<imgdir name="0100100.img">
+	<imgdir name="info">
+		<int name="level" value="2"/>
+		<int name="speed" value="1"/>
+	</imgdir>
+	<imgdir name="stand">
+		<int name="0" value="0"/>
+	</imgdir>
+</imgdir>`)},
+		"Mob.wz/0100101.img.xml": {Data: []byte(`<imgdir name="0100101.img"/>`)},
+	}, "Base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := NewXmlTreeFS(fstest.MapFS{
+		"Mob.wz/0100100.img.xml": {Data: []byte(`<imgdir name="0100100.img">
+	<imgdir name="info">
+		<int name="speed" value="5"/>
+		<string name="name" value="Custom"/>
+	</imgdir>
+	<int name="stand" value="3"/>
+</imgdir>`)},
+		"Mob.wz/9999999.img.xml": {Data: []byte(`<imgdir name="9999999.img"/>`)},
+		"Custom.wz/0.img.xml":    {Data: []byte(`<imgdir name="0.img"/>`)},
+	}, "Patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewOverlayMapleDataProvider(patch, base)
+	o.SetMergeImgs(merge)
+	return o
+}
+
+func TestOverlayMapleDataProvider(t *testing.T) {
+	o := testOverlay(t, false)
+
+	var paths []string
+	err := Walk(o, "", func(path string, e MapleDataEntity, err error) error {
+		paths = append(paths, path)
+		if _, isdir := e.(MapleDataDirectoryEntry); !isdir {
+			return SkipDir
+		}
+		return err
+	})
+	expected := []string{"", "Custom.wz", "Custom.wz/0.img", "Mob.wz",
+		"Mob.wz/0100100.img", "Mob.wz/0100101.img", "Mob.wz/9999999.img"}
+	if err != nil || !reflect.DeepEqual(paths, expected) {
+		t.Errorf("paths = %q, %v, expected %q", paths, err, expected)
+	}
+
+	img, err := o.Get("Mob.wz/0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ChildByPath("info/level") != nil {
+		t.Error("info/level should be hidden by the patch layer")
+	}
+	speed := img.ChildByPath("info/speed").(*OverlayMapleData)
+	if GetIntD(speed, 0) != 5 || speed.Layer() != 0 {
+		t.Errorf("info/speed = %d from layer %d, expected 5 from layer 0",
+			GetIntD(speed, 0), speed.Layer())
+	}
+
+	img, err = o.Get("Mob.wz/0100101.img")
+	if err != nil || img.(*OverlayMapleData).Layer() != 1 {
+		t.Errorf("0100101.img: %v, expected layer 1", err)
+	}
+	if _, err = o.Get("Mob.wz/missing.img"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing.img: err = %v", err)
+	}
+}
+
+func TestOverlayMapleDataProviderMerge(t *testing.T) {
+	o := testOverlay(t, true)
+
+	img, err := o.Get("Mob.wz/0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetFullDataPath(img.ChildByPath("info/speed")) !=
+		"0100100.img/info/speed" {
+
+		t.Errorf("wrong path %q", GetFullDataPath(img.ChildByPath("info/speed")))
+	}
+
+	info := img.ChildByPath("info").(*OverlayMapleData)
+	if !reflect.DeepEqual(info.Layers(), []int{0, 1}) {
+		t.Errorf("info layers = %v, expected [0 1]", info.Layers())
+	}
+
+	var names []string
+	for _, child := range info.Children() {
+		names = append(names, child.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"level", "speed", "name"}) {
+		t.Errorf("info children = %q", names)
+	}
+
+	tests := []struct {
+		path  string
+		value interface{}
+		layer int
+	}{
+		{"info/level", int32(2), 1},
+		{"info/speed", int32(5), 0},
+		{"info/name", "Custom", 0},
+		{"stand", int32(3), 0},
+	}
+	for _, test := range tests {
+		d := img.ChildByPath(test.path).(*OverlayMapleData)
+		if d.Get() != test.value || d.Layer() != test.layer {
+			t.Errorf("%s = %v from layer %d, expected %v from layer %d",
+				test.path, d.Get(), d.Layer(), test.value, test.layer)
+		}
+	}
+
+	// the patch changed stand's type, so the base children are hidden
+	if img.ChildByPath("stand/0") != nil {
+		t.Error("stand/0 should be hidden by the patch layer")
+	}
+	if img.ChildByPath("info/..") != img {
+		t.Error("info/.. is not the img")
+	}
+}
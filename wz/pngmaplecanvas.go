@@ -0,0 +1,583 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+// 90% of this package is ported directly from OdinMS, so credits to them
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// Pixel formats of canvases stored in binary wz files
+const (
+	FormatARGB4444    = 1    // 16-bit argb, 4 bits per channel
+	FormatARGB8888    = 2    // 32-bit argb, 8 bits per channel
+	FormatRGB565      = 513  // 16-bit rgb without alpha
+	FormatRGB565Block = 517  // one 16-bit rgb color for every 16x16 block
+	FormatDXT3        = 1026 // dxt3 compressed blocks with explicit alpha
+	FormatDXT5        = 2050 // dxt5 compressed blocks with interpolated alpha
+)
+
+// ErrCanvasFormat is returned when canvases are encoded in a pixel format
+// that can only be decoded, such as the DXT formats.
+// Use errors.Is to check for it.
+var ErrCanvasFormat = errors.New("Canvas format can't be encoded")
+
+// A PNGMapleCanvas is a canvas stored in a binary wz file as zlib compressed
+// raw pixel data. The pixels are decoded the first time the image is
+// requested.
+type PNGMapleCanvas struct {
+	width   int
+	height  int
+	format  int
+	format2 int
+	r       io.ReaderAt
+	offset  int64
+	length  int
+	key     *WzKey
+	mutex   sync.Mutex // guards img and decoded
+	img     *image.Image
+	linked  MapleData // canvas data whose link properties must be followed
+
+	// decoded is called with the size of the image when the canvas decodes
+	// its own image
+	decoded func(size int64)
+}
+
+// NewPNGMapleCanvas initializes a new PNGMapleCanvas object with the given
+// size, format and compressed pixel data.
+// key is used to decrypt the data in case it's stored in encrypted chunks
+// and can be nil if the data is plain zlib.
+func NewPNGMapleCanvas(w, h, format, format2 int, compressed []byte,
+	key *WzKey) *PNGMapleCanvas {
+
+	return newPNGMapleCanvas(w, h, format, format2, bytes.NewReader(compressed),
+		0, len(compressed), key)
+}
+
+// newPNGMapleCanvas initializes a PNGMapleCanvas whose compressed pixel data
+// is stored at the given offset in r
+func newPNGMapleCanvas(w, h, format, format2 int, r io.ReaderAt,
+	offset int64, length int, key *WzKey) *PNGMapleCanvas {
+
+	if key == nil {
+		key = BMSKey
+	}
+
+	return &PNGMapleCanvas{
+		width:   w,
+		height:  h,
+		format:  format,
+		format2: format2,
+		r:       r,
+		offset:  offset,
+		length:  length,
+		key:     key,
+	}
+}
+
+func (c *PNGMapleCanvas) Height() int { return c.height }
+func (c *PNGMapleCanvas) Width() int  { return c.width }
+
+// Format returns the raw pixel format of the canvas as stored in the wz file
+func (c *PNGMapleCanvas) Format() int { return c.format }
+
+// Format2 returns the secondary format byte of the canvas as stored in the
+// wz file
+func (c *PNGMapleCanvas) Format2() int { return c.format2 }
+
+// Image decodes and returns the canvas' image.
+// returns nil if the pixel data is corrupted or the format is not supported.
+func (c *PNGMapleCanvas) Image() *image.Image {
+	img, _ := c.Load()
+	return img
+}
+
+// Load decodes the canvas' image, or the image of the canvas this canvas
+// links to, and returns it. It's safe to call from multiple goroutines.
+func (c *PNGMapleCanvas) Load() (*image.Image, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	loaded := c.img != nil
+	err := c.loadImageIfNecessary()
+	if !loaded && c.img != nil && c.linked == nil && c.decoded != nil {
+		c.decoded(imageSize(*c.img))
+	}
+	return c.img, err
+}
+
+// watchDecode makes the canvas report the size of its image to fn once it
+// decodes it and returns the size of the image if it's already decoded.
+// Images of linked canvases belong to the canvas they link to.
+func (c *PNGMapleCanvas) watchDecode(fn func(size int64)) int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.decoded = fn
+	if c.img == nil || c.linked != nil {
+		return 0
+	}
+	return imageSize(*c.img)
+}
+
+func (c *PNGMapleCanvas) setTestPathPrefix(prefix string) {}
+
+// CompressedData returns the compressed pixel data as stored in the wz file
+func (c *PNGMapleCanvas) CompressedData() ([]byte, error) {
+	res := make([]byte, c.length)
+	_, err := c.r.ReadAt(res, c.offset)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return res, err
+}
+
+func (c *PNGMapleCanvas) loadImageIfNecessary() error {
+	if c.img != nil {
+		return nil
+	}
+
+	if c.linked != nil {
+		img, err := ResolveImage(c.linked)
+		if err != nil {
+			return err
+		}
+		c.img = img
+		return nil
+	}
+
+	compressed, err := c.CompressedData()
+	if err != nil {
+		return err
+	}
+
+	img, err := DecodeCanvas(c.width, c.height, c.format, c.format2,
+		compressed, c.key)
+	if err != nil {
+		return err
+	}
+
+	c.img = &img
+	return nil
+}
+
+// maxCanvasDimension is the largest width or height DecodeCanvas accepts, so
+// that corrupted sizes don't lead to huge allocations
+const maxCanvasDimension = 16384
+
+// DecodeCanvas inflates and decodes the compressed pixel data of a canvas
+// stored in a binary wz file.
+// Newer wz files split the pixel format between format and format2 (for
+// example DXT3 is stored as format 2 and format2 4). If that combination is
+// not a known format, format2 is the power of two the bitmap was downscaled
+// by and the decoded image is scaled back up to w x h.
+// Regions that encrypt canvases store the zlib stream in chunks that are
+// xored with the wz key, key is used to decrypt them.
+func DecodeCanvas(w, h, format, format2 int, compressed []byte, key *WzKey,
+) (image.Image, error) {
+
+	scale := 0
+	switch combined := format + format2<<8; {
+	case format2 == 0:
+	case canvasDataSize(combined, 1, 1) > 0:
+		format = combined
+	default:
+		scale = format2
+	}
+
+	if scale < 0 || 1<<uint(scale) > maxCanvasDimension {
+		return nil, fmt.Errorf("Invalid canvas scale factor 2^%d", scale)
+	}
+	sw := (w + 1<<uint(scale) - 1) >> uint(scale)
+	sh := (h + 1<<uint(scale) - 1) >> uint(scale)
+
+	if w <= 0 || h <= 0 || w > maxCanvasDimension ||
+		h > maxCanvasDimension {
+
+		if scale != 0 {
+			return nil, fmt.Errorf("Invalid canvas size %dx%d after "+
+				"scaling %dx%d up by 2^%d (format2)", w, h, sw, sh, scale)
+		}
+		return nil, fmt.Errorf("Invalid canvas size %dx%d", w, h)
+	}
+
+	size := canvasDataSize(format, sw, sh)
+	if size <= 0 {
+		return nil, fmt.Errorf("Unsupported canvas format %d", format)
+	}
+
+	data, err := inflateCanvas(compressed, size, key)
+	if err != nil {
+		return nil, err
+	}
+
+	img := decodePixels(format, sw, sh, data)
+	if scale != 0 {
+		img = upscale(img, w, h, scale)
+	}
+
+	return img, nil
+}
+
+// canvasDataSize returns the size of the inflated pixel data for the given
+// format and size or 0 if the format is unknown
+func canvasDataSize(format, w, h int) int {
+	switch format {
+	case FormatARGB4444, FormatRGB565:
+		return w * h * 2
+	case FormatARGB8888:
+		return w * h * 4
+	case FormatRGB565Block:
+		return ((w + 15) / 16) * ((h + 15) / 16) * 2
+	case FormatDXT3, FormatDXT5:
+		return ((w + 3) / 4) * ((h + 3) / 4) * 16
+	}
+	return 0
+}
+
+// isZlibHeader checks if the data starts with one of the zlib headers
+// used by the client
+func isZlibHeader(data []byte) bool {
+	if len(data) < 2 || data[0] != 0x78 {
+		return false
+	}
+
+	switch data[1] {
+	case 0x01, 0x5E, 0x9C, 0xDA:
+		return true
+	}
+	return false
+}
+
+// decryptCanvas returns the plain zlib stream of compressed pixel data,
+// decrypting it first if it's stored in encrypted chunks
+func decryptCanvas(compressed []byte, key *WzKey) ([]byte, error) {
+	if isZlibHeader(compressed) {
+		return compressed, nil
+	}
+
+	if key == nil {
+		key = BMSKey
+	}
+
+	var plain []byte
+	for pos := 0; pos+4 <= len(compressed); {
+		blocksize := int(binary.LittleEndian.Uint32(compressed[pos:]))
+		pos += 4
+		if blocksize < 0 || pos+blocksize > len(compressed) {
+			return nil, errCorrupt
+		}
+
+		keys := key.keystream(blocksize)
+		for i := 0; i < blocksize; i++ {
+			plain = append(plain, compressed[pos+i]^keys[i])
+		}
+		pos += blocksize
+	}
+
+	if !isZlibHeader(plain) {
+		return nil, errCorrupt
+	}
+	return plain, nil
+}
+
+// inflateCanvas decompresses size bytes of pixel data, decrypting the
+// zlib stream first if it's stored in encrypted chunks
+func inflateCanvas(compressed []byte, size int, key *WzKey) ([]byte, error) {
+	compressed, err := decryptCanvas(compressed, key)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	// the buffer grows with the inflated data rather than being allocated
+	// upfront, so a bogus size can't allocate more than the stream holds.
+	// the adler checksum is not verified because the client doesn't either
+	res, err := io.ReadAll(io.LimitReader(zr, int64(size)))
+	if err == nil && len(res) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inflate canvas: %v", err)
+	}
+
+	return res, nil
+}
+
+// expand4 expands a 4-bit color channel to 8 bits
+func expand4(v uint16) uint8 { return uint8(v&0xF) * 0x11 }
+
+// rgb565 expands a 16-bit rgb565 color to 8 bits per channel
+func rgb565(v uint16) color.NRGBA {
+	r := uint8(v >> 11 & 0x1F)
+	g := uint8(v >> 5 & 0x3F)
+	b := uint8(v & 0x1F)
+	return color.NRGBA{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 0xFF}
+}
+
+// decodePixels decodes raw pixel data of a known format and size
+func decodePixels(format, w, h int, data []byte) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	pix := img.Pix
+
+	switch format {
+	case FormatARGB4444:
+		for i := 0; i < w*h; i++ {
+			v := binary.LittleEndian.Uint16(data[i*2:])
+			pix[i*4+0] = expand4(v >> 8)
+			pix[i*4+1] = expand4(v >> 4)
+			pix[i*4+2] = expand4(v)
+			pix[i*4+3] = expand4(v >> 12)
+		}
+
+	case FormatARGB8888:
+		for i := 0; i < w*h; i++ {
+			pix[i*4+0] = data[i*4+2]
+			pix[i*4+1] = data[i*4+1]
+			pix[i*4+2] = data[i*4+0]
+			pix[i*4+3] = data[i*4+3]
+		}
+
+	case FormatRGB565:
+		for i := 0; i < w*h; i++ {
+			img.SetNRGBA(i%w, i/w, rgb565(binary.LittleEndian.Uint16(data[i*2:])))
+		}
+
+	case FormatRGB565Block:
+		bw := (w + 15) / 16
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				i := (y/16)*bw + x/16
+				img.SetNRGBA(x, y, rgb565(binary.LittleEndian.Uint16(data[i*2:])))
+			}
+		}
+
+	case FormatDXT3, FormatDXT5:
+		decodeDXT(img, format, data)
+	}
+
+	return img
+}
+
+// EncodeCanvas encodes the top left w x h pixels of img in the given pixel
+// format and compresses them the way DecodeCanvas expects. Pixels outside
+// of img are transparent. DXT3 and DXT5 can't be encoded and give an
+// ErrCanvasFormat error like any other unknown format.
+// Encoding a decoded canvas again in the same format gives the same data.
+func EncodeCanvas(w, h, format int, img image.Image) ([]byte, error) {
+	if err := checkCanvasFormat(format); err != nil {
+		return nil, err
+	}
+
+	if w <= 0 || h <= 0 || w > maxCanvasDimension ||
+		h > maxCanvasDimension {
+
+		return nil, fmt.Errorf("Invalid canvas size %dx%d", w, h)
+	}
+
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write(encodePixels(format, w, h, img))
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// checkCanvasFormat checks that EncodeCanvas supports a pixel format
+func checkCanvasFormat(format int) error {
+	switch format {
+	case FormatARGB4444, FormatARGB8888, FormatRGB565, FormatRGB565Block:
+		return nil
+	}
+	return fmt.Errorf("%w: %d", ErrCanvasFormat, format)
+}
+
+// quantize scales an 8-bit color channel down to the given amount of bits,
+// rounding to the nearest value
+func quantize(v uint8, bits uint) uint16 {
+	max := 1<<bits - 1
+	return uint16((int(v)*max + 127) / 255)
+}
+
+// toRGB565 packs a color into 16 bits without alpha
+func toRGB565(c color.NRGBA) uint16 {
+	return quantize(c.R, 5)<<11 | quantize(c.G, 6)<<5 | quantize(c.B, 5)
+}
+
+// encodePixels encodes the pixels of img as raw pixel data of a supported
+// format, the opposite of decodePixels
+func encodePixels(format, w, h int, img image.Image) []byte {
+	data := make([]byte, canvasDataSize(format, w, h))
+	bounds := img.Bounds()
+	at := func(x, y int) color.NRGBA {
+		c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+		return color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+
+	switch format {
+	case FormatARGB4444:
+		for i := 0; i < w*h; i++ {
+			c := at(i%w, i/w)
+			v := quantize(c.A, 4)<<12 | quantize(c.R, 4)<<8 |
+				quantize(c.G, 4)<<4 | quantize(c.B, 4)
+			binary.LittleEndian.PutUint16(data[i*2:], v)
+		}
+
+	case FormatARGB8888:
+		for i := 0; i < w*h; i++ {
+			c := at(i%w, i/w)
+			data[i*4+0] = c.B
+			data[i*4+1] = c.G
+			data[i*4+2] = c.R
+			data[i*4+3] = c.A
+		}
+
+	case FormatRGB565:
+		for i := 0; i < w*h; i++ {
+			binary.LittleEndian.PutUint16(data[i*2:], toRGB565(at(i%w, i/w)))
+		}
+
+	case FormatRGB565Block:
+		// each block is the average color of its pixels
+		bw := (w + 15) / 16
+		for by := 0; by*16 < h; by++ {
+			for bx := 0; bx < bw; bx++ {
+				var r, g, b, n int
+				for y := by * 16; y < by*16+16 && y < h; y++ {
+					for x := bx * 16; x < bx*16+16 && x < w; x++ {
+						c := at(x, y)
+						r += int(c.R)
+						g += int(c.G)
+						b += int(c.B)
+						n++
+					}
+				}
+
+				avg := color.NRGBA{uint8((r + n/2) / n), uint8((g + n/2) / n),
+					uint8((b + n/2) / n), 0xFF}
+				binary.LittleEndian.PutUint16(data[(by*bw+bx)*2:],
+					toRGB565(avg))
+			}
+		}
+	}
+
+	return data
+}
+
+// decodeDXT decodes dxt3 or dxt5 compressed 4x4 blocks into img
+func decodeDXT(img *image.NRGBA, format int, data []byte) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	bw := (w + 3) / 4
+	bh := (h + 3) / 4
+
+	var alpha [16]uint8
+	var colors [4]color.NRGBA
+
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			block := data[(by*bw+bx)*16:]
+
+			if format == FormatDXT3 {
+				bits := binary.LittleEndian.Uint64(block)
+				for i := range alpha {
+					alpha[i] = expand4(uint16(bits >> uint(i*4)))
+				}
+			} else {
+				dxt5Alpha(&alpha, block)
+			}
+
+			c0 := binary.LittleEndian.Uint16(block[8:])
+			c1 := binary.LittleEndian.Uint16(block[10:])
+			colors[0] = rgb565(c0)
+			colors[1] = rgb565(c1)
+			colors[2] = mixColor(colors[0], colors[1], 2, 1)
+			colors[3] = mixColor(colors[0], colors[1], 1, 2)
+			indices := binary.LittleEndian.Uint32(block[12:])
+
+			for i := 0; i < 16; i++ {
+				x := bx*4 + i%4
+				y := by*4 + i/4
+				if x >= w || y >= h {
+					continue
+				}
+
+				c := colors[indices>>uint(i*2)&3]
+				c.A = alpha[i]
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// mixColor interpolates two colors with the given weights
+func mixColor(a, b color.NRGBA, wa, wb int) color.NRGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8((int(x)*wa + int(y)*wb) / (wa + wb))
+	}
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xFF}
+}
+
+// dxt5Alpha decodes the interpolated alpha values of a dxt5 block
+func dxt5Alpha(alpha *[16]uint8, block []byte) {
+	var levels [8]int
+	levels[0] = int(block[0])
+	levels[1] = int(block[1])
+
+	if levels[0] > levels[1] {
+		for i := 1; i < 7; i++ {
+			levels[i+1] = ((7-i)*levels[0] + i*levels[1]) / 7
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			levels[i+1] = ((5-i)*levels[0] + i*levels[1]) / 5
+		}
+		levels[6] = 0
+		levels[7] = 0xFF
+	}
+
+	var bits uint64
+	for i := 0; i < 6; i++ {
+		bits |= uint64(block[2+i]) << uint(i*8)
+	}
+
+	for i := range alpha {
+		alpha[i] = uint8(levels[bits>>uint(i*3)&7])
+	}
+}
+
+// upscale scales img up by 2^scale to w x h using nearest neighbour
+func upscale(img *image.NRGBA, w, h, scale int) *image.NRGBA {
+	res := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			res.SetNRGBA(x, y, img.NRGBAAt(x>>uint(scale), y>>uint(scale)))
+		}
+	}
+	return res
+}
@@ -0,0 +1,254 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func testDeflate(data []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// testEncryptChunks stores zlib data in key-encrypted chunks like some
+// regions do
+func testEncryptChunks(data []byte, key *WzKey, chunk int) []byte {
+	var b bytes.Buffer
+	for len(data) > 0 {
+		n := chunk
+		if n > len(data) {
+			n = len(data)
+		}
+		binary.Write(&b, binary.LittleEndian, uint32(n))
+		for i := 0; i < n; i++ {
+			b.WriteByte(data[i] ^ key.At(i))
+		}
+		data = data[n:]
+	}
+	return b.Bytes()
+}
+
+func testCanvasPixels(t *testing.T, name string, img image.Image,
+	expected map[image.Point]color.NRGBA) {
+
+	for pt, c := range expected {
+		got := color.NRGBAModel.Convert(img.At(pt.X, pt.Y)).(color.NRGBA)
+		if got != c {
+			t.Errorf("%s: pixel %v = %v, expected %v", name, pt, got, c)
+		}
+	}
+}
+
+func TestDecodeCanvas(t *testing.T) {
+	red := color.NRGBA{0xFF, 0x00, 0x00, 0xFF}
+	blue := color.NRGBA{0x00, 0x00, 0xFF, 0xFF}
+
+	tests := []struct {
+		name            string
+		w, h            int
+		format, format2 int
+		data            []byte
+		expected        map[image.Point]color.NRGBA
+	}{
+		{"argb4444", 2, 1, FormatARGB4444, 0,
+			[]byte{0x00, 0xFF, 0x3F, 0x80},
+			map[image.Point]color.NRGBA{
+				{0, 0}: red,
+				{1, 0}: {0x00, 0x33, 0xFF, 0x88},
+			}},
+		{"argb8888", 2, 1, FormatARGB8888, 0,
+			[]byte{0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x80},
+			map[image.Point]color.NRGBA{
+				{0, 0}: red,
+				{1, 0}: {0x00, 0x00, 0xFF, 0x80},
+			}},
+		{"rgb565", 2, 1, FormatRGB565, 0,
+			[]byte{0x00, 0xF8, 0x1F, 0x00},
+			map[image.Point]color.NRGBA{{0, 0}: red, {1, 0}: blue}},
+		{"rgb565 split format", 2, 1, 1, 2,
+			[]byte{0x00, 0xF8, 0x1F, 0x00},
+			map[image.Point]color.NRGBA{{0, 0}: red, {1, 0}: blue}},
+		{"rgb565 blocks", 32, 16, FormatRGB565Block, 0,
+			[]byte{0x00, 0xF8, 0x1F, 0x00},
+			map[image.Point]color.NRGBA{
+				{0, 0}: red, {15, 15}: red, {16, 0}: blue, {31, 15}: blue,
+			}},
+		{"dxt3", 4, 4, FormatDXT3, 0,
+			[]byte{
+				0xF0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+				0x00, 0xF8, 0x1F, 0x00, 0xE4, 0x00, 0x00, 0x00,
+			},
+			map[image.Point]color.NRGBA{
+				{0, 0}: {0xFF, 0x00, 0x00, 0x00},
+				{1, 0}: blue,
+				{2, 0}: {0xAA, 0x00, 0x55, 0xFF},
+				{3, 0}: {0x55, 0x00, 0xAA, 0xFF},
+				{3, 3}: red,
+			}},
+		{"dxt5", 4, 4, 2, 8,
+			[]byte{
+				0xFF, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0xF8, 0x1F, 0x00, 0x01, 0x00, 0x00, 0x00,
+			},
+			map[image.Point]color.NRGBA{
+				{0, 0}: {0x00, 0x00, 0xFF, 0xFF},
+				{1, 0}: {0xFF, 0x00, 0x00, 0x00},
+				{2, 0}: red,
+			}},
+		{"argb8888 scaled", 4, 2, FormatARGB8888, 1,
+			[]byte{0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0xFF},
+			map[image.Point]color.NRGBA{
+				{0, 0}: red, {1, 1}: red, {2, 0}: blue, {3, 1}: blue,
+			}},
+	}
+
+	for _, test := range tests {
+		compressed := testDeflate(test.data)
+
+		img, err := DecodeCanvas(test.w, test.h, test.format, test.format2,
+			compressed, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if img.Bounds() != image.Rect(0, 0, test.w, test.h) {
+			t.Errorf("%s: bounds = %v", test.name, img.Bounds())
+		}
+		testCanvasPixels(t, test.name, img, test.expected)
+
+		// same data through the canvas, stored in encrypted chunks
+		encrypted := testEncryptChunks(compressed, GMSKey, 5)
+		c := NewPNGMapleCanvas(test.w, test.h, test.format, test.format2,
+			encrypted, GMSKey)
+		pimg := c.Image()
+		if pimg == nil {
+			t.Errorf("%s: failed to decode encrypted chunks", test.name)
+			continue
+		}
+		testCanvasPixels(t, test.name+" (encrypted)", *pimg, test.expected)
+	}
+
+	if _, err := DecodeCanvas(1, 1, 3, 0, testDeflate([]byte{0}), nil); err == nil {
+		t.Errorf("decoding an unknown format should fail")
+	}
+	if _, err := DecodeCanvas(2, 2, FormatARGB8888, 0,
+		testDeflate([]byte{1, 2, 3}), nil); err == nil {
+
+		t.Errorf("decoding truncated pixel data should fail")
+	}
+
+	// a corrupted size must fail before anything is allocated for it
+	huge := maxCanvasDimension + 1
+	_, err := DecodeCanvas(huge, huge, FormatARGB8888, 0,
+		testDeflate([]byte{1, 2, 3, 4}), nil)
+	if err == nil {
+		t.Errorf("decoding a %dx%d canvas should fail", huge, huge)
+	}
+
+	// the error of a scaled canvas mentions the scaling
+	_, err = DecodeCanvas(huge, 4, FormatARGB8888, 2,
+		testDeflate([]byte{1, 2, 3, 4}), nil)
+	if err == nil || !strings.Contains(err.Error(), "2^2") {
+		t.Errorf("decoding a scaled %dx4 canvas: unexpected error %v", huge,
+			err)
+	}
+	_, err = DecodeCanvas(4, 4, FormatARGB8888, 40,
+		testDeflate([]byte{1, 2, 3, 4}), nil)
+	if err == nil {
+		t.Error("decoding a canvas scaled by 2^40 should fail")
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = DecodeCanvas(maxCanvasDimension, maxCanvasDimension,
+		FormatARGB8888, 0, testDeflate([]byte{1, 2, 3, 4}), nil)
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Errorf("decoding truncated pixel data should fail")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 16<<20 {
+		t.Errorf("decoding a truncated canvas allocated %d bytes", n)
+	}
+}
+
+func TestWZFileCanvas(t *testing.T) {
+	path := writeTestWz(t, encodeTestWz(testWzTree(), 83, GMSKey))
+
+	f, err := NewWZFile(path, 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := f.Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canvas := GetImage(img.ChildByPath("stand/0"))
+	if canvas == nil {
+		t.Fatalf("0100100.img/stand/0: failed to decode canvas")
+	}
+
+	testCanvasPixels(t, "0100100.img/stand/0", *canvas,
+		map[image.Point]color.NRGBA{
+			{0, 0}: {0xFF, 0x00, 0x00, 0xFF},
+			{1, 0}: {0x00, 0x00, 0xFF, 0x80},
+		})
+}
+
+// testImageCanvas is a canvas that doesn't implement CanvasLoader
+type testImageCanvas struct{ img *image.Image }
+
+func (c testImageCanvas) Height() int                     { return 1 }
+func (c testImageCanvas) Width() int                      { return 1 }
+func (c testImageCanvas) Image() *image.Image             { return c.img }
+func (c testImageCanvas) setTestPathPrefix(prefix string) {}
+
+func TestLoadCanvas(t *testing.T) {
+	var img image.Image = image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	if res, err := LoadCanvas(testImageCanvas{&img}); err != nil ||
+		res != &img {
+
+		t.Errorf("LoadCanvas = %v, %v", res, err)
+	}
+	if _, err := LoadCanvas(testImageCanvas{}); err == nil {
+		t.Error("LoadCanvas of a canvas without image: expected an error")
+	}
+	if _, err := LoadCanvas(nil); err == nil {
+		t.Error("LoadCanvas(nil): expected an error")
+	}
+
+	// the error of Load is kept
+	c := NewPNGMapleCanvas(1, 1, FormatARGB8888, 0, []byte{1, 2, 3, 4}, nil)
+	_, err := c.Load()
+	if _, err2 := LoadCanvas(c); err == nil || err2 == nil ||
+		err.Error() != err2.Error() {
+
+		t.Errorf("LoadCanvas = %v, Load = %v", err2, err)
+	}
+}
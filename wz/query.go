@@ -0,0 +1,605 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Query selects data with a path made of "/" separated segments. Each
+// segment is one of:
+//
+//	name     a child with the given name, * matches any run of characters
+//	         (for example * or stand*)
+//	**       the current node and all of its descendants
+//	..       the parent node
+//	1..3     children with numeric names in the range, either bound can be
+//	         omitted (2.. or ..5)
+//
+// followed by any number of predicates that filter the matched nodes:
+//
+//	[path]         the node has data at path
+//	[path=value]   the data at path equals value, compared as numbers if
+//	               both sides are numbers. Other operators are !=, <, <=, >
+//	               and >=. The path "." is the node itself and value can be
+//	               quoted with "" to include ] or spaces.
+//
+// For example, Mob.wz/*.img[info/boss=1] selects every boss mob img and
+// move/*/delay[.>=200] every slow move frame delay. Backslashes escape
+// special characters in names and quoted values, names that contain escapes
+// are matched literally.
+type Query struct {
+	src      string
+	segments []querySegment
+}
+
+// A QueryMatch is a node selected by a query
+type QueryMatch struct {
+	Path string // full path of the node
+	Data MapleData
+}
+
+// A QuerySyntaxError is returned by ParseQuery for malformed queries
+type QuerySyntaxError struct {
+	Query  string // the query that was parsed
+	Offset int    // byte offset of the problem in the query
+	Msg    string // description of the problem
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("Query syntax error at column %d: %s\n\t%s\n\t%s^",
+		e.Offset+1, e.Msg, e.Query, strings.Repeat(" ", e.Offset))
+}
+
+type querySegmentKind int
+
+const (
+	queryName querySegmentKind = iota
+	queryDescendants
+	queryParent
+	queryRange
+)
+
+type querySegment struct {
+	kind       querySegmentKind
+	name       string // for queryName, with * wildcards
+	wildcard   bool   // name contains wildcards
+	low, high  int    // for queryRange
+	predicates []queryPredicate
+}
+
+type queryPredicate struct {
+	path  string
+	op    string // empty for existence checks
+	value string
+}
+
+// ParseQuery parses a query. Errors are reported as *QuerySyntaxError.
+func ParseQuery(query string) (*Query, error) {
+	p := &queryParser{src: query}
+	q := &Query{src: query}
+
+	if query == "" {
+		return nil, p.errorf(0, "empty query")
+	}
+
+	for {
+		seg, err := p.segment()
+		if err != nil {
+			return nil, err
+		}
+		q.segments = append(q.segments, seg)
+
+		if p.pos == len(p.src) {
+			return q, nil
+		}
+		p.pos++ // skip '/'
+	}
+}
+
+// MustParseQuery is like ParseQuery but panics on syntax errors
+func MustParseQuery(query string) *Query {
+	q, err := ParseQuery(query)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (q *Query) String() string { return q.src }
+
+// queryParser holds the state of ParseQuery
+type queryParser struct {
+	src string
+	pos int
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{},
+) error {
+	return &QuerySyntaxError{Query: p.src, Offset: pos,
+		Msg: fmt.Sprintf(format, args...)}
+}
+
+// segment parses a segment up to the next '/' or the end of the query
+func (p *queryParser) segment() (querySegment, error) {
+	var seg querySegment
+	start := p.pos
+
+	var name strings.Builder
+	escaped := false
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '/' || c == '[' || c == ']' {
+			break
+		}
+		if c == '\\' {
+			if p.pos+1 == len(p.src) {
+				return seg, p.errorf(p.pos, "nothing to escape")
+			}
+			p.pos++
+			name.WriteByte(p.src[p.pos])
+			escaped = true
+		} else {
+			if c == '*' {
+				seg.wildcard = true
+			}
+			name.WriteByte(c)
+		}
+		p.pos++
+	}
+	raw := p.src[start:p.pos]
+
+	switch {
+	case raw == "":
+		return seg, p.errorf(start, "empty segment")
+	case raw == "**":
+		seg.kind = queryDescendants
+	case raw == "..":
+		seg.kind = queryParent
+	case strings.Contains(raw, "**"):
+		return seg, p.errorf(start+strings.Index(raw, "**"),
+			"** must be a whole segment")
+	case !escaped && strings.Contains(raw, ".."):
+		if err := p.rangeSegment(&seg, raw, start); err != nil {
+			return seg, err
+		}
+	default:
+		seg.kind = queryName
+		seg.name = name.String()
+		if escaped {
+			seg.wildcard = false // escaped names are matched literally
+		}
+	}
+
+	for p.pos < len(p.src) && p.src[p.pos] != '/' {
+		if p.src[p.pos] != '[' {
+			return seg, p.errorf(p.pos, "unexpected %q", p.src[p.pos])
+		}
+		pred, err := p.predicate()
+		if err != nil {
+			return seg, err
+		}
+		seg.predicates = append(seg.predicates, pred)
+	}
+
+	if seg.kind == queryParent && len(seg.predicates) > 0 {
+		return seg, p.errorf(start, ".. can't have predicates")
+	}
+
+	return seg, nil
+}
+
+// rangeSegment parses a numeric range such as 1..3, 2.. or ..5
+func (p *queryParser) rangeSegment(seg *querySegment, raw string, start int,
+) error {
+	seg.kind = queryRange
+	bounds := strings.SplitN(raw, "..", 2)
+	seg.low, seg.high = 0, int(^uint(0)>>1)
+
+	var err error
+	if bounds[0] != "" {
+		if seg.low, err = strconv.Atoi(bounds[0]); err != nil {
+			return p.errorf(start, "invalid range start %q", bounds[0])
+		}
+	}
+	if bounds[1] != "" {
+		if seg.high, err = strconv.Atoi(bounds[1]); err != nil {
+			return p.errorf(start+len(bounds[0])+2, "invalid range end %q",
+				bounds[1])
+		}
+	}
+	if seg.low > seg.high {
+		return p.errorf(start, "empty range %s", raw)
+	}
+	return nil
+}
+
+var queryOperators = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// predicate parses a [path op value] predicate
+func (p *queryParser) predicate() (queryPredicate, error) {
+	var pred queryPredicate
+	open := p.pos
+	p.pos++ // skip '['
+
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune("=!<>]", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	pred.path = strings.TrimSpace(p.src[start:p.pos])
+	if pred.path == "" {
+		return pred, p.errorf(start, "expected a path in predicate")
+	}
+	if p.pos == len(p.src) {
+		return pred, p.errorf(open, "unterminated predicate, expected ]")
+	}
+
+	if p.src[p.pos] == ']' {
+		p.pos++
+		return pred, nil
+	}
+
+	for _, op := range queryOperators {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			pred.op = op
+			break
+		}
+	}
+	if pred.op == "" {
+		return pred, p.errorf(p.pos, "expected an operator")
+	}
+	p.pos += len(pred.op)
+
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		quote := p.pos
+		p.pos++
+		var value strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return pred, p.errorf(quote, "unterminated string")
+			}
+			c := p.src[p.pos]
+			if c == '"' {
+				p.pos++
+				break
+			}
+			if c == '\\' && p.pos+1 < len(p.src) {
+				p.pos++
+				c = p.src[p.pos]
+			}
+			value.WriteByte(c)
+			p.pos++
+		}
+		pred.value = value.String()
+		for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+			p.pos++
+		}
+	} else {
+		start = p.pos
+		for p.pos < len(p.src) && p.src[p.pos] != ']' {
+			p.pos++
+		}
+		pred.value = strings.TrimSpace(p.src[start:p.pos])
+	}
+
+	if p.pos == len(p.src) || p.src[p.pos] != ']' {
+		return pred, p.errorf(p.pos, "expected ] to close the predicate "+
+			"opened at column %d", open+1)
+	}
+	p.pos++
+	return pred, nil
+}
+
+// Match evaluates the query relative to d and returns the matching nodes in
+// document order
+func (q *Query) Match(d MapleData) []QueryMatch {
+	nodes, _ := q.eval([]queryNode{{data: d}}, nil)
+	return matches(nodes)
+}
+
+// MatchProvider evaluates the query relative to the root directory of p and
+// returns the matching data nodes. The first segments match directories and
+// img files, which are loaded from p as they are reached, and the rest match
+// the data inside the imgs. Directories themselves are never returned.
+func (q *Query) MatchProvider(p MapleDataProvider) ([]QueryMatch, error) {
+	nodes, err := q.eval([]queryNode{{dir: p.Root()}}, p)
+	if err != nil {
+		return nil, err
+	}
+	return matches(nodes), nil
+}
+
+// QueryData parses query and evaluates it relative to d
+func QueryData(d MapleData, query string) ([]QueryMatch, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Match(d), nil
+}
+
+// QueryProvider parses query and evaluates it relative to the root
+// directory of p. See Query.MatchProvider.
+func QueryProvider(p MapleDataProvider, query string) ([]QueryMatch, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.MatchProvider(p)
+}
+
+// A queryNode is either a directory or data reached while evaluating a query
+type queryNode struct {
+	dir    MapleDataDirectoryEntry
+	data   MapleData
+	prefix string // path of the directory that holds dir or the img of data
+}
+
+func (n queryNode) path() string {
+	if n.data != nil {
+		return n.prefix + GetFullDataPath(n.data)
+	}
+	return n.prefix + n.dir.Name()
+}
+
+func matches(nodes []queryNode) []QueryMatch {
+	var res []QueryMatch
+	for _, n := range nodes {
+		if n.data != nil {
+			res = append(res, QueryMatch{Path: n.path(), Data: n.data})
+		}
+	}
+	return res
+}
+
+// eval applies all the segments of the query to nodes
+func (q *Query) eval(nodes []queryNode, p MapleDataProvider) (
+	[]queryNode, error) {
+
+	for _, seg := range q.segments {
+		var next []queryNode
+		seen := map[string]bool{}
+
+		for _, n := range nodes {
+			candidates, err := seg.step(n, p)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, c := range candidates {
+				if !seg.filter(c) {
+					continue
+				}
+				path := c.path()
+				if seen[path] {
+					continue
+				}
+				seen[path] = true
+				next = append(next, c)
+			}
+		}
+
+		nodes = next
+	}
+
+	return nodes, nil
+}
+
+// step returns the nodes the segment selects from n before predicates
+func (seg *querySegment) step(n queryNode, p MapleDataProvider) (
+	[]queryNode, error) {
+
+	if n.data != nil {
+		return seg.stepData(n), nil
+	}
+
+	var res []queryNode
+	subprefix := n.prefix
+	if n.dir.Parent() != nil || n.prefix != "" {
+		subprefix += n.dir.Name() + "/"
+	}
+
+	switch seg.kind {
+	case queryParent:
+		return nil, nil
+
+	case queryDescendants:
+		res = append(res, n)
+		for _, sub := range n.dir.Subdirectories() {
+			subs, err := seg.step(queryNode{dir: sub, prefix: subprefix}, p)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, subs...)
+		}
+		for _, f := range n.dir.Files() {
+			img, err := p.Get(subprefix + f.Name())
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, seg.stepData(
+				queryNode{data: img, prefix: subprefix})...)
+		}
+		return res, nil
+	}
+
+	for _, sub := range n.dir.Subdirectories() {
+		if seg.matchName(sub.Name()) {
+			res = append(res, queryNode{dir: sub, prefix: subprefix})
+		}
+	}
+	for _, f := range n.dir.Files() {
+		if !seg.matchName(f.Name()) {
+			continue
+		}
+		img, err := p.Get(subprefix + f.Name())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, queryNode{data: img, prefix: subprefix})
+	}
+	return res, nil
+}
+
+// stepData returns the data nodes the segment selects from data node n
+func (seg *querySegment) stepData(n queryNode) []queryNode {
+	wrap := func(d MapleData) queryNode {
+		return queryNode{data: d, prefix: n.prefix}
+	}
+
+	switch seg.kind {
+	case queryParent:
+		parent, ok := n.data.Parent().(MapleData)
+		if !ok || parent == nil {
+			return nil
+		}
+		return []queryNode{wrap(parent)}
+
+	case queryDescendants:
+		res := []queryNode{n}
+		for _, child := range n.data.Children() {
+			res = append(res, seg.stepData(wrap(child))...)
+		}
+		return res
+
+	case queryName:
+		// ChildByPath would interpret escaped slashes and dots
+		if !seg.wildcard && seg.name != ".." &&
+			!strings.Contains(seg.name, "/") {
+
+			child := n.data.ChildByPath(seg.name)
+			if child == nil {
+				return nil
+			}
+			return []queryNode{wrap(child)}
+		}
+	}
+
+	var res []queryNode
+	for _, child := range n.data.Children() {
+		if seg.matchName(child.Name()) {
+			res = append(res, wrap(child))
+		}
+	}
+	return res
+}
+
+// matchName checks if a child name is selected by a name or range segment
+func (seg *querySegment) matchName(name string) bool {
+	switch seg.kind {
+	case queryRange:
+		i, err := strconv.Atoi(name)
+		return err == nil && i >= seg.low && i <= seg.high
+	case queryName:
+		if !seg.wildcard {
+			return name == seg.name
+		}
+		return matchWildcard(seg.name, name)
+	}
+	return false
+}
+
+// matchWildcard matches name against a pattern where * matches any run of
+// characters
+func matchWildcard(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return len(name) >= len(last) && strings.HasSuffix(name, last)
+}
+
+// filter checks the predicates of the segment against n. Directories never
+// satisfy predicates.
+func (seg *querySegment) filter(n queryNode) bool {
+	if len(seg.predicates) == 0 {
+		return true
+	}
+	if n.data == nil {
+		return false
+	}
+
+	for _, pred := range seg.predicates {
+		if !pred.match(n.data) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pred *queryPredicate) match(d MapleData) bool {
+	target := d
+	if pred.path != "." {
+		target = d.ChildByPath(pred.path)
+	}
+	if target == nil {
+		return false
+	}
+	if pred.op == "" {
+		return true
+	}
+
+	val := target.Get()
+	var cmp int
+
+	a, aok := toFloat64(val)
+	b, bok := toFloat64(pred.value)
+	if aok && bok {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	} else {
+		s, ok := val.(string)
+		if !ok {
+			return pred.op == "!="
+		}
+		cmp = strings.Compare(s, pred.value)
+	}
+
+	switch pred.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default: // ">="
+		return cmp >= 0
+	}
+}
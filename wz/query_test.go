@@ -0,0 +1,177 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testQueryPaths(res []QueryMatch) []string {
+	paths := make([]string, len(res))
+	for i, m := range res {
+		paths[i] = m.Path
+	}
+	return paths
+}
+
+func TestQueryData(t *testing.T) {
+	img, err := ParseXmlImg(strings.NewReader(`<imgdir name="0100100.img">
+	<imgdir name="info">
+		<int name="boss" value="1"/>
+		<string name="elemAttr" value="L3"/>
+	</imgdir>
+	<imgdir name="move">
+		<canvas name="0" width="1" height="1">
+			<int name="delay" value="180"/>
+		</canvas>
+		<canvas name="1" width="1" height="1">
+			<int name="delay" value="240"/>
+		</canvas>
+		<canvas name="2" width="1" height="1"/>
+		<canvas name="10" width="1" height="1">
+			<string name="delay" value="300"/>
+		</canvas>
+	</imgdir>
+	<imgdir name="stand">
+		<canvas name="0" width="1" height="1">
+			<int name="delay" value="100"/>
+		</canvas>
+	</imgdir>
+	<imgdir name="a[b]">
+		<int name="x/y" value="1"/>
+	</imgdir>
+</imgdir>`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"move/*/delay", []string{"0100100.img/move/0/delay",
+			"0100100.img/move/1/delay", "0100100.img/move/10/delay"}},
+		{"move/*/delay[.>200]", []string{"0100100.img/move/1/delay",
+			"0100100.img/move/10/delay"}},
+		{"move/0..1", []string{"0100100.img/move/0", "0100100.img/move/1"}},
+		{"move/2..", []string{"0100100.img/move/2", "0100100.img/move/10"}},
+		{"move/*[delay]", []string{"0100100.img/move/0", "0100100.img/move/1",
+			"0100100.img/move/10"}},
+		{"**/delay[. = 100]", []string{"0100100.img/stand/0/delay"}},
+		{"s*/0", []string{"0100100.img/stand/0"}},
+		{"*[info/boss=1]/..", nil},
+		{"info[boss=1][elemAttr=\"L3\"]", []string{"0100100.img/info"}},
+		{"info[elemAttr!=L3]", nil},
+		{"info/boss/..", []string{"0100100.img/info"}},
+		{`a\[b\]/x\/y`, []string{"0100100.img/a[b]/x/y"}},
+		{"nothing/**", nil},
+	}
+
+	for _, test := range tests {
+		res, err := QueryData(img, test.query)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		paths := testQueryPaths(res)
+		if len(paths) == 0 {
+			paths = nil
+		}
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("%s = %q, expected %q", test.query, paths, test.expected)
+		}
+	}
+
+	// ** matches the node itself and every descendant, without duplicates
+	res := MustParseQuery("**/**").Match(img.ChildByPath("stand"))
+	if len(res) != 3 {
+		t.Errorf("**/** = %q", testQueryPaths(res))
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{"", 0},
+		{"a//b", 2},
+		{"a/**b", 2},
+		{"a[b", 1},
+		{"a[]", 2},
+		{"a[b=c", 5},
+		{"a[b!c]", 3},
+		{`a[b="c]`, 4},
+		{"a]", 1},
+		{"3..1", 0},
+		{"x..1", 0},
+		{"1..y", 3},
+		{"..[a]", 0},
+		{`a\`, 1},
+	}
+
+	for _, test := range tests {
+		_, err := ParseQuery(test.query)
+		var syntaxErr *QuerySyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: err = %v, expected a *QuerySyntaxError", test.query,
+				err)
+			continue
+		}
+		if syntaxErr.Offset != test.offset {
+			t.Errorf("%q: error at %d, expected %d: %v", test.query,
+				syntaxErr.Offset, test.offset, err)
+		}
+	}
+
+	_, err := ParseQuery("move/*[delay")
+	if err == nil || !strings.Contains(err.Error(), "\tmove/*[delay\n\t      ^") {
+		t.Errorf("error message doesn't point at the problem: %v", err)
+	}
+}
+
+func TestQueryProvider(t *testing.T) {
+	x, err := NewXmlTree(testfilesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := QueryProvider(x, "Mob.wz/*.img[info/elemAttr=L3]/info/level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := testQueryPaths(res)
+	if !reflect.DeepEqual(paths, []string{"Mob.wz/0210100.img/info/level"}) {
+		t.Errorf("mobs with elemAttr L3 = %q", paths)
+	}
+	if res[0].Data.Get() != int32(6) {
+		t.Errorf("Mob.wz/0210100.img/info/level = %v", res[0].Data.Get())
+	}
+
+	res, err = QueryProvider(x, "**/info/speed[.=180]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = testQueryPaths(res)
+	if !reflect.DeepEqual(paths,
+		[]string{"TamingMob.wz/0003.img/info/speed"}) {
+
+		t.Errorf("speed 180 = %q", paths)
+	}
+}
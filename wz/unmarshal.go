@@ -0,0 +1,342 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An UnknownDataError is reported by UnmarshalStrict for data that isn't
+// mapped to any field
+type UnknownDataError struct {
+	Path string // full data path of the unmapped data
+}
+
+func (e *UnknownDataError) Error() string {
+	return e.Path + " is not mapped to any field"
+}
+
+// Unmarshal stores the data of d in the value pointed to by v.
+//
+// Struct fields are looked up with the path in their wz tag relative to the
+// struct's data, or by their name (case insensitive) if they have no tag.
+// The tag can be followed by options separated by commas:
+//
+//	Speed int    `wz:"info/speed,default=100"` // used if info/speed is absent
+//	Boss  bool   `wz:"info/boss,optional"`     // not reported by strict mode
+//	Temp  string `wz:"-"`                      // ignored
+//
+// Default values can't contain commas. Embedded structs without a tag are
+// read from the same data as the struct that embeds them.
+//
+// Values are converted like Value does. Slices are filled with the children
+// that have numeric names ("0", "1", ...) in numeric order, maps with all the
+// children keyed by their name, pointers are allocated only if the data
+// exists and MapleData fields receive the data node itself. Fields of
+// interface types such as MapleCanvas, MapleSound and image.Point receive
+// the value of Get.
+//
+// The first problem is returned as a *TypeError. Missing data is left as the
+// zero value. A default value that can't be converted to its field is
+// reported as a *TypeError for the missing data with the default as a
+// STRING value.
+func Unmarshal(d MapleData, v interface{}) error {
+	return unmarshal(d, v, false)
+}
+
+// UnmarshalStrict works like Unmarshal but also reports data that isn't
+// mapped to any field as *UnknownDataError and fields without data, a
+// default value or the optional flag as *MissingDataError. Pointer fields
+// are always optional. All the problems are returned at once as a
+// *ValidationError.
+func UnmarshalStrict(d MapleData, v interface{}) error {
+	return unmarshal(d, v, true)
+}
+
+func unmarshal(d MapleData, v interface{}, strict bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("Unmarshal requires a non-nil pointer, got %T", v)
+	}
+	if d == nil {
+		return &MissingDataError{}
+	}
+
+	u := &unmarshaler{strict: strict}
+	u.value(d, rv.Elem())
+
+	switch {
+	case len(u.errs) == 0:
+		return nil
+	case strict:
+		return &ValidationError{Errors: u.errs}
+	default:
+		return u.errs[0]
+	}
+}
+
+// unmarshaler holds the state of Unmarshal
+type unmarshaler struct {
+	strict bool
+	errs   []error
+}
+
+var mapleDataType = reflect.TypeOf((*MapleData)(nil)).Elem()
+
+// value stores the data of d in v
+func (u *unmarshaler) value(d MapleData, v reflect.Value) {
+	t := v.Type()
+	if t == mapleDataType {
+		v.Set(reflect.ValueOf(d))
+		return
+	}
+
+	val := d.Get()
+	if val != nil && reflect.TypeOf(val).AssignableTo(t) {
+		v.Set(reflect.ValueOf(val))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		u.value(d, elem.Elem())
+		v.Set(elem)
+
+	case reflect.Struct:
+		var consumed []string
+		u.fields(d, v, &consumed)
+		if u.strict {
+			u.unknown(d, consumed)
+		}
+
+	case reflect.Slice:
+		u.slice(d, v)
+
+	case reflect.Map:
+		u.mapValue(d, v)
+
+	case reflect.Interface:
+		// interface{} fields can hold the nil value of a property
+		if val != nil || t.NumMethod() > 0 {
+			u.typeError(d, val, t)
+		}
+
+	default:
+		if !convertValue(val, v) {
+			u.typeError(d, val, t)
+		}
+	}
+}
+
+func (u *unmarshaler) typeError(d MapleData, val interface{}, t reflect.Type) {
+	u.errs = append(u.errs, &TypeError{
+		Path:     GetFullDataPath(d),
+		Type:     d.Type(),
+		Value:    val,
+		Expected: t.String(),
+	})
+}
+
+// fields fills the fields of the struct v with the children of d and
+// appends the paths it looked up to consumed
+func (u *unmarshaler) fields(d MapleData, v reflect.Value, consumed *[]string) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, hasTag := sf.Tag.Lookup("wz")
+		if tag == "-" {
+			continue
+		}
+
+		if sf.Anonymous && !hasTag && sf.Type.Kind() == reflect.Struct {
+			u.fields(d, v.Field(i), consumed)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+
+		path, opts := parseWzTag(tag)
+		var child MapleData
+		if path == "" {
+			child = childByNameFold(d, sf.Name)
+			path = sf.Name
+			if child != nil {
+				path = child.Name()
+			}
+		} else {
+			child = d.ChildByPath(path)
+		}
+		*consumed = append(*consumed, path)
+
+		field := v.Field(i)
+		if child != nil {
+			u.value(child, field)
+			continue
+		}
+
+		fullpath := GetFullDataPath(d) + "/" + path
+		switch {
+		case opts.hasDefault:
+			if !convertValue(opts.def, field) {
+				u.errs = append(u.errs, &TypeError{
+					Path:     fullpath,
+					Type:     STRING,
+					Value:    opts.def,
+					Expected: sf.Type.String(),
+				})
+			}
+		case u.strict && !opts.optional && field.Kind() != reflect.Ptr:
+			u.errs = append(u.errs, &MissingDataError{Path: fullpath})
+		}
+	}
+}
+
+// wzTagOptions holds the options that follow the path in a wz tag
+type wzTagOptions struct {
+	def        string
+	hasDefault bool
+	optional   bool
+}
+
+func parseWzTag(tag string) (path string, opts wzTagOptions) {
+	parts := strings.Split(tag, ",")
+	path = parts[0]
+
+	for _, opt := range parts[1:] {
+		switch {
+		case strings.HasPrefix(opt, "default="):
+			opts.def = strings.TrimPrefix(opt, "default=")
+			opts.hasDefault = true
+		case opt == "optional":
+			opts.optional = true
+		}
+	}
+
+	return
+}
+
+// childByNameFold finds the direct child of d named like name, preferring an
+// exact match over a case insensitive one
+func childByNameFold(d MapleData, name string) MapleData {
+	if child := d.ChildByPath(name); child != nil {
+		return child
+	}
+	for _, child := range d.Children() {
+		if strings.EqualFold(child.Name(), name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// unknown reports the children of d that are not covered by the consumed
+// paths
+func (u *unmarshaler) unknown(d MapleData, consumed []string) {
+	for _, child := range d.Children() {
+		name := child.Name()
+		var nested []string
+		whole := false
+
+		for _, path := range consumed {
+			if path == name {
+				whole = true
+				break
+			}
+			if strings.HasPrefix(path, name+"/") {
+				nested = append(nested, path[len(name)+1:])
+			}
+		}
+
+		switch {
+		case whole:
+		case len(nested) > 0:
+			u.unknown(child, nested)
+		default:
+			u.errs = append(u.errs,
+				&UnknownDataError{Path: GetFullDataPath(child)})
+		}
+	}
+}
+
+// numberedChildren returns the children of d with numeric names in numeric
+// order, reporting the others as unknown in strict mode
+func (u *unmarshaler) numberedChildren(d MapleData) []MapleData {
+	type numbered struct {
+		index int
+		data  MapleData
+	}
+
+	var children []numbered
+	for _, child := range d.Children() {
+		index, err := strconv.Atoi(child.Name())
+		if err != nil {
+			if u.strict {
+				u.errs = append(u.errs,
+					&UnknownDataError{Path: GetFullDataPath(child)})
+			}
+			continue
+		}
+		children = append(children, numbered{index, child})
+	}
+
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].index < children[j].index
+	})
+
+	res := make([]MapleData, len(children))
+	for i, c := range children {
+		res[i] = c.data
+	}
+	return res
+}
+
+func (u *unmarshaler) slice(d MapleData, v reflect.Value) {
+	children := u.numberedChildren(d)
+	s := reflect.MakeSlice(v.Type(), len(children), len(children))
+	for i, child := range children {
+		u.value(child, s.Index(i))
+	}
+	v.Set(s)
+}
+
+func (u *unmarshaler) mapValue(d MapleData, v reflect.Value) {
+	t := v.Type()
+	m := reflect.MakeMap(t)
+
+	for _, child := range d.Children() {
+		key := reflect.New(t.Key()).Elem()
+		if !convertValue(child.Name(), key) {
+			if u.strict {
+				u.errs = append(u.errs,
+					&UnknownDataError{Path: GetFullDataPath(child)})
+			}
+			continue
+		}
+
+		elem := reflect.New(t.Elem()).Elem()
+		u.value(child, elem)
+		m.SetMapIndex(key, elem)
+	}
+
+	v.Set(m)
+}
@@ -0,0 +1,211 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testUnmarshalImg = `<imgdir name="0100100.img">
+	<imgdir name="info">
+		<int name="speed" value="-65"/>
+		<short name="level" value="2"/>
+		<int name="boss" value="1"/>
+		<string name="elemAttr" value="L3"/>
+		<imgdir name="skill">
+			<imgdir name="0">
+				<int name="skill" value="114"/>
+				<int name="level" value="3"/>
+			</imgdir>
+			<imgdir name="1">
+				<int name="skill" value="115"/>
+				<int name="level" value="1"/>
+			</imgdir>
+		</imgdir>
+	</imgdir>
+	<imgdir name="stand">
+		<canvas name="0" width="1" height="1">
+			<vector name="origin" x="29" y="51"/>
+		</canvas>
+	</imgdir>
+	<imgdir name="reward">
+		<int name="2000000" value="10"/>
+		<int name="4000019" value="600"/>
+	</imgdir>
+	<imgdir name="frames">
+		<vector name="10" x="10" y="0"/>
+		<vector name="2" x="2" y="0"/>
+	</imgdir>
+</imgdir>`
+
+type testMobSkill struct {
+	Skill int
+	Level int
+}
+
+type testMobStats struct {
+	Level    int32  `wz:"info/level"`
+	ElemAttr string `wz:"info/elemAttr"`
+}
+
+type testMob struct {
+	testMobStats
+	Speed      int            `wz:"info/speed"`
+	Boss       bool           `wz:"info/boss"`
+	Exp        int            `wz:"info/exp,default=10"`
+	Skills     []testMobSkill `wz:"info/skill"`
+	Stand      MapleCanvas    `wz:"stand/0"`
+	Origin     image.Point    `wz:"stand/0/origin"`
+	Stand0     MapleData      `wz:"stand/0"`
+	Reward     map[int]int16  `wz:"reward"`
+	Frames     []image.Point
+	Summon     *testMobSkill `wz:"info/summon"`
+	Ignored    int           `wz:"-"`
+	unexported int
+}
+
+func TestUnmarshal(t *testing.T) {
+	img, err := ParseXmlImg(strings.NewReader(testUnmarshalImg), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mob testMob
+	if err = Unmarshal(img, &mob); err != nil {
+		t.Fatal(err)
+	}
+
+	if mob.Level != 2 || mob.ElemAttr != "L3" {
+		t.Errorf("embedded struct = %+v", mob.testMobStats)
+	}
+	if mob.Speed != -65 || !mob.Boss || mob.Exp != 10 {
+		t.Errorf("speed, boss, exp = %v, %v, %v", mob.Speed, mob.Boss,
+			mob.Exp)
+	}
+	expected := []testMobSkill{{114, 3}, {115, 1}}
+	if !reflect.DeepEqual(mob.Skills, expected) {
+		t.Errorf("skills = %+v, expected %+v", mob.Skills, expected)
+	}
+	if mob.Stand == nil || mob.Stand.Width() != 1 {
+		t.Errorf("stand = %v", mob.Stand)
+	}
+	if mob.Origin != image.Pt(29, 51) {
+		t.Errorf("origin = %v", mob.Origin)
+	}
+	if mob.Stand0 == nil || mob.Stand0.Type() != CANVAS {
+		t.Errorf("stand/0 data = %v", mob.Stand0)
+	}
+	if !reflect.DeepEqual(mob.Reward, map[int]int16{2000000: 10, 4000019: 600}) {
+		t.Errorf("reward = %v", mob.Reward)
+	}
+	if !reflect.DeepEqual(mob.Frames,
+		[]image.Point{image.Pt(2, 0), image.Pt(10, 0)}) {
+
+		t.Errorf("frames = %v", mob.Frames)
+	}
+	if mob.Summon != nil {
+		t.Errorf("summon = %v, expected nil", mob.Summon)
+	}
+
+	// type errors
+	var bad struct {
+		Speed int8 `wz:"info/elemAttr"`
+	}
+	var typeErr *TypeError
+	err = Unmarshal(img, &bad)
+	if !errors.As(err, &typeErr) || typeErr.Path != "0100100.img/info/elemAttr" {
+		t.Errorf("bad field: err = %v", err)
+	}
+	var badDefault struct {
+		MP int `wz:"info/maxMP,default=lots"`
+	}
+	err = Unmarshal(img, &badDefault)
+	if !errors.As(err, &typeErr) || typeErr.Path != "0100100.img/info/maxMP" ||
+		typeErr.Value != "lots" {
+
+		t.Errorf("bad default: err = %v", err)
+	}
+
+	if err = Unmarshal(img, mob); err == nil {
+		t.Errorf("Unmarshal into a non-pointer should fail")
+	}
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	img, err := ParseXmlImg(strings.NewReader(testUnmarshalImg), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var partial struct {
+		Speed  int  `wz:"info/speed"`
+		Boss   bool `wz:"info/boss,optional"`
+		HP     int  `wz:"info/maxHP"`
+		Exp    int  `wz:"info/exp,default=10"`
+		MP     int  `wz:"info/maxMP,optional"`
+		Skills []struct {
+			Skill int
+		} `wz:"info/skill"`
+		Stand  MapleData `wz:"stand"`
+		Reward MapleData `wz:"reward"`
+		Frames MapleData `wz:"frames"`
+	}
+
+	err = UnmarshalStrict(img, &partial)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("UnmarshalStrict: err = %v, expected a *ValidationError", err)
+	}
+
+	var problems []string
+	for _, err := range verr.Errors {
+		var missing *MissingDataError
+		var unknown *UnknownDataError
+		switch {
+		case errors.As(err, &missing):
+			problems = append(problems, "missing "+missing.Path)
+		case errors.As(err, &unknown):
+			problems = append(problems, "unknown "+unknown.Path)
+		default:
+			problems = append(problems, err.Error())
+		}
+	}
+
+	expected := []string{
+		"missing 0100100.img/info/maxHP",
+		"unknown 0100100.img/info/skill/0/level",
+		"unknown 0100100.img/info/skill/1/level",
+		"unknown 0100100.img/info/level",
+		"unknown 0100100.img/info/elemAttr",
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("problems = %q\nexpected %q", problems, expected)
+	}
+
+	var full testMob
+	var speed int
+	if err = UnmarshalStrict(img, &full); err != nil {
+		t.Errorf("testMob maps the whole img: %v", err)
+	}
+	err = UnmarshalStrict(img.ChildByPath("info/speed"), &speed)
+	if err != nil || speed != -65 {
+		t.Errorf("strict scalar = %v, %v", speed, err)
+	}
+}
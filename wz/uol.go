@@ -0,0 +1,219 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A DanglingUOLError is returned when a UOL links to data that doesn't exist
+type DanglingUOLError struct {
+	Path string // full data path of the UOL
+	Link string // relative link stored in the UOL
+}
+
+func (e *DanglingUOLError) Error() string {
+	return fmt.Sprintf("UOL %s links to %s which doesn't exist", e.Path, e.Link)
+}
+
+// A UOLCycleError is returned when a chain of UOLs links back to itself
+type UOLCycleError struct {
+	Chain []string // full data paths of the UOLs that form the cycle
+}
+
+func (e *UOLCycleError) Error() string {
+	return "UOL cycle detected: " + strings.Join(e.Chain, " -> ")
+}
+
+// Resolve follows d through any chain of UOLs and returns the data the last
+// UOL links to. If d is not a UOL, it's returned as is.
+// Links are resolved relative to the UOL's parent and can walk through
+// other UOLs.
+func Resolve(d MapleData) (MapleData, error) {
+	return resolveUOL(d, nil)
+}
+
+// resolveUOL resolves d, chain holds the UOLs that are currently being
+// resolved and is used to detect cycles
+func resolveUOL(d MapleData, chain []string) (MapleData, error) {
+	for d != nil && d.Type() == UOL {
+		path := GetFullDataPath(d)
+		for i, p := range chain {
+			if p == path {
+				return nil, &UOLCycleError{
+					Chain: append(append([]string(nil), chain[i:]...), path),
+				}
+			}
+		}
+		chain = append(chain, path)
+
+		link, _ := d.Get().(string)
+		target, err := followLink(d, link, chain)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			return nil, &DanglingUOLError{Path: path, Link: link}
+		}
+
+		d = target
+	}
+
+	return d, nil
+}
+
+// followLink walks the relative link of a UOL starting from its parent,
+// resolving any UOLs found along the way.
+// returns nil if the link points to data that doesn't exist.
+func followLink(uol MapleData, link string, chain []string) (
+	MapleData, error) {
+
+	cur, _ := uol.Parent().(MapleData)
+	for _, segment := range strings.Split(link, "/") {
+		if cur == nil {
+			return nil, nil
+		}
+
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			cur, _ = cur.Parent().(MapleData)
+			continue
+		}
+
+		var err error
+		cur, err = resolveUOL(cur, chain)
+		if err != nil || cur == nil {
+			return nil, err
+		}
+		cur = cur.ChildByPath(segment)
+	}
+
+	return cur, nil
+}
+
+// A ResolvingMapleData wraps MapleData and transparently resolves UOLs
+// while navigating it. Resolved data keeps the name and position of the UOL
+// it was reached through, while the type, value and children are the ones of
+// the linked data.
+// UOLs that can't be resolved because they are dangling or cyclic are left
+// as they are, use Resolve to find out why.
+type ResolvingMapleData struct {
+	link   MapleData // the data as found in the tree, possibly a UOL
+	target MapleData // the resolved data
+}
+
+// NewResolvingMapleData wraps d to transparently resolve UOLs in it and
+// in all of its children
+func NewResolvingMapleData(d MapleData) *ResolvingMapleData {
+	if d == nil {
+		return nil
+	}
+
+	if r, ok := d.(*ResolvingMapleData); ok {
+		return r
+	}
+
+	target, err := Resolve(d)
+	if err != nil {
+		target = d
+	}
+
+	return &ResolvingMapleData{link: d, target: target}
+}
+
+// dataProvider returns the provider the wrapped data came from
+func (r *ResolvingMapleData) dataProvider() MapleDataProvider {
+	if owner, ok := r.link.(dataProviderOwner); ok {
+		return owner.dataProvider()
+	}
+	return nil
+}
+
+// Unwrap returns the wrapped data without resolving it
+func (r *ResolvingMapleData) Unwrap() MapleData { return r.link }
+
+func (r *ResolvingMapleData) Name() string { return r.link.Name() }
+
+func (r *ResolvingMapleData) Parent() MapleDataEntity {
+	parent, ok := r.link.Parent().(MapleData)
+	if !ok {
+		return nil
+	}
+	return NewResolvingMapleData(parent)
+}
+
+func (r *ResolvingMapleData) Type() MapleDataType { return r.target.Type() }
+
+func (r *ResolvingMapleData) Get() interface{} { return r.target.Get() }
+
+func (r *ResolvingMapleData) Children() []MapleData {
+	children := r.target.Children()
+	res := make([]MapleData, len(children))
+	for i, child := range children {
+		res[i] = NewResolvingMapleData(child)
+	}
+	return res
+}
+
+// ChildByPath finds and returns a value by path relative to this node,
+// resolving every UOL along the path
+func (r *ResolvingMapleData) ChildByPath(path string) MapleData {
+	cur := r
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			parent, ok := cur.Parent().(*ResolvingMapleData)
+			if !ok {
+				return nil
+			}
+			cur = parent
+			continue
+		}
+
+		child := cur.target.ChildByPath(segment)
+		if child == nil {
+			return nil
+		}
+		cur = NewResolvingMapleData(child)
+	}
+
+	return cur
+}
+
+// A ResolvingMapleDataProvider wraps a MapleDataProvider and returns data
+// that transparently resolves UOLs. See ResolvingMapleData.
+type ResolvingMapleDataProvider struct {
+	MapleDataProvider
+}
+
+// NewResolvingMapleDataProvider wraps p so that all of the data it
+// returns transparently resolves UOLs
+func NewResolvingMapleDataProvider(p MapleDataProvider,
+) *ResolvingMapleDataProvider {
+
+	return &ResolvingMapleDataProvider{MapleDataProvider: p}
+}
+
+// Get returns the wz data at the given path wrapped as ResolvingMapleData
+func (p *ResolvingMapleDataProvider) Get(path string) (MapleData, error) {
+	d, err := p.MapleDataProvider.Get(path)
+	if err != nil || d == nil {
+		return d, err
+	}
+	return NewResolvingMapleData(d), nil
+}
@@ -0,0 +1,152 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"image"
+	"testing"
+)
+
+const testUOLXml = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<imgdir name="00002000.img">
+	<imgdir name="stand1">
+		<imgdir name="0">
+			<canvas name="arm" width="1" height="1">
+				<vector name="origin" x="1" y="2"/>
+			</canvas>
+			<uol name="body" value="arm"/>
+			<uol name="hand" value="body"/>
+		</imgdir>
+		<uol name="1" value="0"/>
+	</imgdir>
+	<imgdir name="walk1">
+		<uol name="0" value="../stand1/1"/>
+		<uol name="1" value="../../missing/0"/>
+		<uol name="2" value="../stand1/1/hand/origin"/>
+		<int name="delay" value="180"/>
+	</imgdir>
+	<imgdir name="cycle">
+		<uol name="a" value="b"/>
+		<uol name="b" value="c"/>
+		<uol name="c" value="a"/>
+	</imgdir>
+</imgdir>`
+
+func newTestUOLProvider(t *testing.T) MapleDataProvider {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Character.wz/00002000.img.xml": testUOLXml,
+	})
+
+	x, err := NewXml(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestResolve(t *testing.T) {
+	img, err := newTestUOLProvider(t).Get("Character.wz/00002000.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := map[string]string{
+		"stand1/0/arm":  "00002000.img/stand1/0/arm",
+		"stand1/0/body": "00002000.img/stand1/0/arm",
+		"stand1/0/hand": "00002000.img/stand1/0/arm",
+		"stand1/1":      "00002000.img/stand1/0",
+		"walk1/0":       "00002000.img/stand1/0",
+		"walk1/2":       "00002000.img/stand1/0/arm/origin",
+	}
+
+	for path, expected := range resolved {
+		d, err := Resolve(img.ChildByPath(path))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if got := GetFullDataPath(d); got != expected {
+			t.Errorf("%s resolved to %s, expected %s", path, got, expected)
+		}
+	}
+
+	_, err = Resolve(img.ChildByPath("walk1/1"))
+	if derr, ok := err.(*DanglingUOLError); !ok ||
+		derr.Path != "00002000.img/walk1/1" || derr.Link != "../../missing/0" {
+
+		t.Errorf("walk1/1: expected a dangling UOL error, got %v", err)
+	}
+
+	_, err = Resolve(img.ChildByPath("cycle/a"))
+	if cerr, ok := err.(*UOLCycleError); !ok || len(cerr.Chain) != 4 {
+		t.Errorf("cycle/a: expected a UOL cycle error, got %v", err)
+	}
+}
+
+func TestResolvingMapleDataProvider(t *testing.T) {
+	p := NewResolvingMapleDataProvider(newTestUOLProvider(t))
+	img, err := p.Get("Character.wz/00002000.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := GetPoint(img.ChildByPath("walk1/0/hand/origin"))
+	if origin == nil || *origin != image.Pt(1, 2) {
+		t.Errorf("walk1/0/hand/origin = %v, expected (1,2)", origin)
+	}
+
+	frame := img.ChildByPath("walk1/0")
+	if frame.Type() != PROPERTY || frame.Name() != "0" ||
+		GetFullDataPath(frame) != "00002000.img/walk1/0" {
+
+		t.Errorf("walk1/0 resolved to %v %s %s", frame.Type(), frame.Name(),
+			GetFullDataPath(frame))
+	}
+
+	if delay := GetIntD(frame.ChildByPath("../delay"), 0); delay != 180 {
+		t.Errorf("walk1/0/../delay = %d, expected 180", delay)
+	}
+
+	for _, child := range img.ChildByPath("stand1").Children() {
+		if child.Type() != PROPERTY || len(child.Children()) != 3 {
+			t.Errorf("stand1/%s was not resolved", child.Name())
+		}
+	}
+
+	if d := img.ChildByPath("walk1/1"); d == nil || d.Type() != UOL {
+		t.Errorf("dangling UOLs should be left as they are")
+	}
+}
+
+func TestResolveWZFile(t *testing.T) {
+	f, err := NewWZFile(writeTestWz(t, encodeTestWz(testWzTree(), 83, BMSKey)), 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := NewResolvingMapleDataProvider(f).Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if origin := GetPointD(img.ChildByPath("stand/1/origin"), image.Point{}); origin != image.Pt(29, -51) {
+		t.Errorf("stand/1/origin = %v, expected (29,-51)", origin)
+	}
+	if GetImage(img.ChildByPath("stand/1")) == nil {
+		t.Errorf("stand/1 did not resolve to a canvas")
+	}
+}
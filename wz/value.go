@@ -0,0 +1,233 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// A MissingDataError is returned when the data at a path doesn't exist.
+// It matches ErrNotFound with errors.Is.
+type MissingDataError struct {
+	Path string // full data path that was looked up
+}
+
+func (e *MissingDataError) Error() string {
+	return e.Path + " not found"
+}
+
+// Is reports whether target is ErrNotFound
+func (e *MissingDataError) Is(target error) bool { return target == ErrNotFound }
+
+// A TypeError is returned when data can't be converted to the requested type
+type TypeError struct {
+	Path     string        // full data path of the data
+	Type     MapleDataType // type of the data
+	Value    interface{}   // value of the data
+	Expected string        // name of the requested go type
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("%s is %v(%v), can't convert it to %s", e.Path, e.Type,
+		e.Value, e.Expected)
+}
+
+// Value returns the value of the data at path relative to d converted to T.
+// An empty path means d itself.
+//
+// Numeric types convert from SHORT, INT, LONG, FLOAT, DOUBLE and numeric
+// STRING data. Integer types only accept values that fit T without losing
+// precision, so an int accessor accepts 10.0 but not 10.5. Float types
+// accept any value within the range of T and round it to the nearest value
+// T can hold, so a DOUBLE read as float32 may lose precision but one that
+// overflows float32 is rejected. string accepts STRING and UOL data and
+// formats numbers. bool accepts numbers (non-zero is true) and strings
+// parsed by strconv.ParseBool. Any other T must match the value returned by
+// Get, for example image.Point, Convex, MapleCanvas or MapleSound.
+//
+// Missing data is reported as a *MissingDataError and data that can't be
+// converted as a *TypeError.
+func Value[T any](d MapleData, path string) (T, error) {
+	var res T
+
+	if d == nil {
+		return res, &MissingDataError{Path: path}
+	}
+	if path != "" {
+		child := d.ChildByPath(path)
+		if child == nil {
+			return res, &MissingDataError{
+				Path: GetFullDataPath(d) + "/" + path}
+		}
+		d = child
+	}
+
+	val := d.Get()
+	if v, ok := val.(T); ok {
+		return v, nil
+	}
+
+	if !convertValue(val, reflect.ValueOf(&res).Elem()) {
+		return res, &TypeError{
+			Path:     GetFullDataPath(d),
+			Type:     d.Type(),
+			Value:    val,
+			Expected: reflect.TypeOf(&res).Elem().String(),
+		}
+	}
+
+	return res, nil
+}
+
+// ValueD returns the value of the data at path relative to d converted to T.
+// If the value can't be retrieved, defval will be returned. See Value.
+func ValueD[T any](d MapleData, path string, defval T) T {
+	res, err := Value[T](d, path)
+	if err != nil {
+		return defval
+	}
+	return res
+}
+
+// MustGet returns the value of the data at path relative to d converted to
+// T and panics if it can't be retrieved. See Value.
+func MustGet[T any](d MapleData, path string) T {
+	res, err := Value[T](d, path)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// convertValue converts val into dst following the rules described in Value
+func convertValue(val interface{}, dst reflect.Value) bool {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+
+		i, ok := toInt64(val)
+		if !ok || dst.OverflowInt(i) {
+			return false
+		}
+		dst.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+
+		i, ok := toInt64(val)
+		if !ok || i < 0 || dst.OverflowUint(uint64(i)) {
+			return false
+		}
+		dst.SetUint(uint64(i))
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat64(val)
+		if !ok || dst.OverflowFloat(f) {
+			return false
+		}
+		dst.SetFloat(f)
+
+	case reflect.String:
+		switch v := val.(type) {
+		case string:
+			dst.SetString(v)
+		case int16, int32, int64:
+			i, _ := toInt64(v)
+			dst.SetString(strconv.FormatInt(i, 10))
+		case float32:
+			dst.SetString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+		case float64:
+			dst.SetString(strconv.FormatFloat(v, 'g', -1, 64))
+		default:
+			return false
+		}
+
+	case reflect.Bool:
+		if s, ok := val.(string); ok {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return false
+			}
+			dst.SetBool(b)
+			return true
+		}
+
+		f, ok := toFloat64(val)
+		if !ok {
+			return false
+		}
+		dst.SetBool(f != 0)
+
+	default:
+		// named types with the same underlying type as the value
+		v := reflect.ValueOf(val)
+		if !v.IsValid() || v.Kind() != dst.Kind() ||
+			!v.Type().ConvertibleTo(dst.Type()) {
+
+			return false
+		}
+		dst.Set(v.Convert(dst.Type()))
+	}
+
+	return true
+}
+
+// toInt64 converts a numeric or string value to an integer without losing
+// precision
+func toInt64(val interface{}) (int64, bool) {
+	switch v := val.(type) {
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			return i, true
+		}
+	}
+
+	f, ok := toFloat64(val)
+	if !ok || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// toFloat64 converts a numeric or string value to a float64
+func toFloat64(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
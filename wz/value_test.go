@@ -0,0 +1,144 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestValue(t *testing.T) {
+	img, err := ParseXmlImg(strings.NewReader(`<imgdir name="0100100.img">
+	<imgdir name="info">
+		<short name="pushed" value="-5"/>
+		<int name="maxHP" value="100000"/>
+		<float name="fs" value="10.0"/>
+		<double name="rate" value="0.25"/>
+		<double name="huge" value="1e300"/>
+		<double name="tenth" value="0.1"/>
+		<string name="exp" value="42"/>
+		<string name="elemAttr" value="L3"/>
+		<int name="boss" value="1"/>
+	</imgdir>
+	<vector name="origin" x="29" y="-51"/>
+</imgdir>`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type mobID int32
+
+	if v, err := Value[int32](img, "info/pushed"); err != nil || v != -5 {
+		t.Errorf("int32 from SHORT = %v, %v", v, err)
+	}
+	if v, err := Value[int](img, "info/fs"); err != nil || v != 10 {
+		t.Errorf("int from FLOAT = %v, %v", v, err)
+	}
+	if v, err := Value[int64](img, "info/exp"); err != nil || v != 42 {
+		t.Errorf("int64 from STRING = %v, %v", v, err)
+	}
+	if v, err := Value[mobID](img, "info/maxHP"); err != nil || v != 100000 {
+		t.Errorf("named int from INT = %v, %v", v, err)
+	}
+	if v, err := Value[float64](img, "info/pushed"); err != nil || v != -5 {
+		t.Errorf("float64 from SHORT = %v, %v", v, err)
+	}
+	if v, err := Value[float32](img, "info/rate"); err != nil || v != 0.25 {
+		t.Errorf("float32 from DOUBLE = %v, %v", v, err)
+	}
+	if v, err := Value[float32](img, "info/tenth"); err != nil || v != 0.1 {
+		t.Errorf("rounded float32 from DOUBLE = %v, %v", v, err)
+	}
+	if v, err := Value[string](img, "info/maxHP"); err != nil || v != "100000" {
+		t.Errorf("string from INT = %v, %v", v, err)
+	}
+	if v, err := Value[bool](img, "info/boss"); err != nil || !v {
+		t.Errorf("bool from INT = %v, %v", v, err)
+	}
+	if v, err := Value[image.Point](img, "origin"); err != nil ||
+		v != image.Pt(29, -51) {
+
+		t.Errorf("image.Point from VECTOR = %v, %v", v, err)
+	}
+	info := img.ChildByPath("info")
+	if v, err := Value[int16](info.ChildByPath("pushed"), ""); err != nil ||
+		v != -5 {
+
+		t.Errorf("int16 with an empty path = %v, %v", v, err)
+	}
+
+	// errors
+	var typeErr *TypeError
+	_, err = Value[int16](img, "info/maxHP")
+	if !errors.As(err, &typeErr) || typeErr.Type != INT ||
+		typeErr.Path != "0100100.img/info/maxHP" || typeErr.Expected != "int16" {
+
+		t.Errorf("int16 overflow: err = %v", err)
+	}
+	if _, err = Value[float32](img, "info/huge"); !errors.As(err, &typeErr) {
+		t.Errorf("float32 from 1e300: err = %v", err)
+	}
+	if v, err := Value[float64](img, "info/huge"); err != nil || v != 1e300 {
+		t.Errorf("float64 from 1e300 = %v, %v", v, err)
+	}
+	if _, err = Value[int](img, "info/rate"); !errors.As(err, &typeErr) {
+		t.Errorf("int from 0.25: err = %v", err)
+	}
+	if _, err = Value[uint](img, "info/pushed"); !errors.As(err, &typeErr) {
+		t.Errorf("uint from -5: err = %v", err)
+	}
+	if _, err = Value[int](img, "info/elemAttr"); !errors.As(err, &typeErr) {
+		t.Errorf("int from L3: err = %v", err)
+	}
+	if _, err = Value[image.Point](img, "info"); !errors.As(err, &typeErr) ||
+		typeErr.Type != PROPERTY {
+
+		t.Errorf("image.Point from PROPERTY: err = %v", err)
+	}
+	if !strings.Contains(err.Error(), "0100100.img/info is PROPERTY") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	var missingErr *MissingDataError
+	_, err = Value[int](img, "info/speed")
+	if !errors.As(err, &missingErr) || !errors.Is(err, ErrNotFound) ||
+		missingErr.Path != "0100100.img/info/speed" {
+
+		t.Errorf("missing value: err = %v", err)
+	}
+
+	if v := ValueD(img, "info/speed", 100); v != 100 {
+		t.Errorf("ValueD = %v, expected the default", v)
+	}
+	if v := MustGet[string](img, "info/elemAttr"); v != "L3" {
+		t.Errorf("MustGet = %v", v)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("MustGet didn't panic on missing data")
+			}
+		}()
+		MustGet[int](img, "nothing")
+	}()
+
+	if v := GetIntConvert(img.ChildByPath("info/pushed")); v == nil || *v != -5 {
+		t.Errorf("GetIntConvert on a SHORT = %v", v)
+	}
+}
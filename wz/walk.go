@@ -0,0 +1,267 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// SkipDir can be returned by a WalkFunc to skip the contents of the
+// directory, img or property it was called for
+var SkipDir = errors.New("skip this directory")
+
+// SkipImg can be returned by a WalkFunc called for an img or any of its
+// nodes to skip the rest of that img. Returned for a directory, it skips
+// the imgs in it without loading them but still walks its subdirectories.
+var SkipImg = errors.New("skip this img")
+
+// errWalkStopped interrupts the workers of WalkParallel after an error
+var errWalkStopped = errors.New("walk stopped")
+
+// A WalkFunc is called by Walk for every directory, img and node it visits.
+// path is relative to the provider's root directory, which has an empty
+// path. e is a MapleDataDirectoryEntry for directories and a MapleData for
+// imgs and their nodes.
+//
+// If an img can't be loaded, the function is called with its
+// MapleDataFileEntry and the error. Returning nil skips the img, returning
+// the error or any other error stops the walk.
+type WalkFunc func(path string, e MapleDataEntity, err error) error
+
+// Walk walks the directories of p starting at root, calling fn for each of
+// them, then loads their imgs and walks each img's property tree. root can
+// be a directory, an img or a node inside an img.
+// Directories and imgs are visited in lexical order, the children of
+// properties in the order they are stored in.
+// Walk returns the first error returned by fn other than SkipDir and
+// SkipImg.
+func Walk(p MapleDataProvider, root string, fn WalkFunc) error {
+	w := &walker{p: p, fn: fn}
+	return w.walkRoot(root)
+}
+
+// WalkParallel works like Walk but loads and walks imgs with the given
+// number of goroutines, or GOMAXPROCS if workers is zero or less.
+// Directories are still walked in order from the calling goroutine, but the
+// imgs in them are walked in no particular order, so fn must be safe for
+// concurrent use. The nodes of a single img are still walked in order by
+// one goroutine.
+// After fn returns an error, WalkParallel stops loading imgs and returns it
+// once the imgs being walked are interrupted.
+func WalkParallel(p MapleDataProvider, root string, workers int,
+	fn WalkFunc) error {
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	w := &walker{p: p, fn: fn, jobs: make(chan walkJob)}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range w.jobs {
+				if w.stopped.Load() {
+					continue
+				}
+				w.fail(w.walkImg(job.path, job.file))
+			}
+		}()
+	}
+
+	w.fail(w.walkRoot(root))
+	close(w.jobs)
+	wg.Wait()
+	return w.err
+}
+
+// walker holds the state of Walk and WalkParallel
+type walker struct {
+	p  MapleDataProvider
+	fn WalkFunc
+
+	// only used by WalkParallel
+	jobs    chan walkJob
+	stopped atomic.Bool
+	mutex   sync.Mutex // guards err
+	err     error
+}
+
+type walkJob struct {
+	path string
+	file MapleDataFileEntry
+}
+
+// fail records the first error of a parallel walk and stops it
+func (w *walker) fail(err error) {
+	if err == nil || err == errWalkStopped {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.stopped.Store(true)
+	}
+}
+
+func walkJoin(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "/" + name
+}
+
+// walkRoot finds root and walks it
+func (w *walker) walkRoot(root string) error {
+	dir := w.p.Root()
+	if root == "" {
+		return w.walkDir("", dir)
+	}
+
+	segments := strings.Split(root, "/")
+	if dir.GetEntry(segments[0]) == nil && segments[0] == dir.Name() {
+		segments = segments[1:] // wz file paths can start with its name
+		root = strings.Join(segments, "/")
+		if root == "" {
+			return w.walkDir("", dir)
+		}
+	}
+
+	for i, segment := range segments {
+		switch entry := dir.GetEntry(segment).(type) {
+		case MapleDataDirectoryEntry:
+			if i == len(segments)-1 {
+				return w.walkDir(root, entry)
+			}
+			dir = entry
+
+		case MapleDataFileEntry:
+			imgpath := strings.Join(segments[:i+1], "/")
+			if i == len(segments)-1 {
+				return w.walkImg(imgpath, entry)
+			}
+
+			img, err := w.p.Get(imgpath)
+			if err != nil {
+				return err
+			}
+			data := img.ChildByPath(strings.Join(segments[i+1:], "/"))
+			if data == nil {
+				return &MissingDataError{Path: root}
+			}
+			if err = w.walkData(root, data); err == SkipImg {
+				return nil
+			}
+			return err
+
+		default:
+			return notFoundError(root, nil)
+		}
+	}
+
+	return nil
+}
+
+func (w *walker) walkDir(path string, dir MapleDataDirectoryEntry) error {
+	err := w.fn(path, dir, nil)
+	skipImgs := err == SkipImg
+	switch {
+	case err == SkipDir:
+		return nil
+	case err != nil && !skipImgs:
+		return err
+	}
+
+	entries := make([]MapleDataEntity, 0,
+		len(dir.Subdirectories())+len(dir.Files()))
+	for _, sub := range dir.Subdirectories() {
+		entries = append(entries, sub)
+	}
+	if !skipImgs {
+		for _, f := range dir.Files() {
+			entries = append(entries, f)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, entry := range entries {
+		if w.stopped.Load() {
+			return errWalkStopped
+		}
+
+		entrypath := walkJoin(path, entry.Name())
+		switch e := entry.(type) {
+		case MapleDataDirectoryEntry:
+			err = w.walkDir(entrypath, e)
+		case MapleDataFileEntry:
+			if w.jobs != nil {
+				w.jobs <- walkJob{entrypath, e}
+				continue
+			}
+			err = w.walkImg(entrypath, e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *walker) walkImg(path string, file MapleDataFileEntry) error {
+	img, err := w.p.Get(path)
+	if err != nil {
+		err = w.fn(path, file, err)
+	} else {
+		err = w.walkData(path, img)
+	}
+
+	if err == SkipDir || err == SkipImg {
+		return nil
+	}
+	return err
+}
+
+func (w *walker) walkData(path string, d MapleData) error {
+	if w.stopped.Load() {
+		return errWalkStopped
+	}
+
+	err := w.fn(path, d, nil)
+	if err == SkipDir {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, child := range d.Children() {
+		if err = w.walkData(path+"/"+child.Name(), child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	x, err := NewXmlTree(testfilesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	err = Walk(x, "", func(path string, e MapleDataEntity, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"", "Mob.wz", "Mob.wz/0100100.img",
+		"Mob.wz/0100100.img/info", "Mob.wz/0100100.img/info/bodyAttack"}
+	if len(paths) < len(expected) ||
+		!reflect.DeepEqual(paths[:len(expected)], expected) {
+
+		t.Errorf("walk starts with %q, expected %q", paths[:5], expected)
+	}
+
+	imgs := 0
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".img") {
+			imgs++
+		}
+	}
+	if imgs != 12 {
+		t.Errorf("walked %d imgs, expected 12", imgs)
+	}
+
+	// skipping
+	paths = nil
+	err = Walk(x, "", func(path string, e MapleDataEntity, err error) error {
+		paths = append(paths, path)
+		switch {
+		case path == "Mob.wz":
+			return SkipImg
+		case strings.HasSuffix(path, "/info"):
+			return SkipDir
+		case strings.HasSuffix(path, "/0002.img"):
+			return SkipImg
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if strings.HasPrefix(path, "Mob.wz/") ||
+			strings.Contains(path, "/info/") ||
+			strings.HasPrefix(path, "TamingMob.wz/0002.img/") {
+
+			t.Errorf("%s should have been skipped", path)
+		}
+	}
+
+	// roots
+	paths = nil
+	err = Walk(x, "Mob.wz/0210100.img/move/0",
+		func(path string, e MapleDataEntity, err error) error {
+			paths = append(paths, path)
+			return nil
+		})
+	if err != nil || len(paths) != 6 ||
+		paths[1] != "Mob.wz/0210100.img/move/0/origin" {
+
+		t.Errorf("walking move/0 = %q, %v", paths, err)
+	}
+
+	err = Walk(x, "Mob.wz/9999999.img",
+		func(path string, e MapleDataEntity, err error) error { return nil })
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("walking a missing root: err = %v", err)
+	}
+
+	// stopping
+	stop := errors.New("stop")
+	count := 0
+	err = Walk(x, "", func(path string, e MapleDataEntity, err error) error {
+		count++
+		if path == "Mob.wz/0100100.img/info/level" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 6 {
+		t.Errorf("err = %v after %d calls, expected stop after 6", err, count)
+	}
+}
+
+func TestWalkLoadError(t *testing.T) {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/a.img.xml": `<imgdir name="a.img"><int name="x" value="1"/>`,
+		"Mob.wz/b.img.xml": `<imgdir name="b.img"/>`,
+	})
+	x, err := NewXmlTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	err = Walk(x, "Mob.wz", func(path string, e MapleDataEntity,
+		err error) error {
+
+		if err != nil {
+			if _, ok := e.(MapleDataFileEntry); !ok ||
+				!errors.Is(err, ErrMalformedImg) {
+
+				t.Errorf("%s: unexpected error %v for %T", path, err, e)
+			}
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(paths, []string{"Mob.wz",
+		"Mob.wz/b.img"}) {
+
+		t.Errorf("walked %q, %v", paths, err)
+	}
+
+	err = Walk(x, "", func(path string, e MapleDataEntity, err error) error {
+		return err
+	})
+	if !errors.Is(err, ErrMalformedImg) {
+		t.Errorf("returning the load error should stop the walk: %v", err)
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	x, err := NewXmlTree(testfilesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []string
+	Walk(x, "", func(path string, e MapleDataEntity, err error) error {
+		expected = append(expected, path)
+		return nil
+	})
+
+	var mutex sync.Mutex
+	var paths []string
+	err = WalkParallel(x, "", 4, func(path string, e MapleDataEntity,
+		err error) error {
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		paths = append(paths, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(expected)
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("parallel walk visited %d nodes, expected %d", len(paths),
+			len(expected))
+	}
+
+	stop := errors.New("stop")
+	err = WalkParallel(x, "", 4, func(path string, e MapleDataEntity,
+		err error) error {
+
+		if strings.HasSuffix(path, "/info/speed") {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("err = %v, expected stop", err)
+	}
+}
@@ -0,0 +1,381 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+// 90% of this package is ported directly from OdinMS, so credits to them
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// A WZFile provides access to the data in a binary wz file
+type WZFile struct {
+	name       string
+	r          io.ReaderAt
+	closer     io.Closer
+	size       int64
+	fstart     uint32
+	copyright  string
+	encVersion uint16
+	version    int
+	hash       uint32
+	key        *WzKey
+	root       *DirectoryEntry
+	canvasLinks
+}
+
+// wzKeys are the region keys that are tried when the key of a wz file
+// is detected automatically
+var wzKeys = []*WzKey{GMSKey, SEAKey, BMSKey}
+
+// NewWZFile opens the wz file at the given path and parses its directory
+// tree for the given maple version. The region key is detected
+// automatically.
+// If version is negative, the version is detected as well by trying every
+// candidate like DetectVersion does.
+func NewWZFile(path string, version int) (*WZFile, error) {
+	return NewWZFileKey(path, version, nil)
+}
+
+// NewWZFileKey opens the wz file at the given path and parses its directory
+// tree for the given maple version and region key.
+// If key is nil, it will be detected automatically. If version is negative,
+// it will be detected like DetectVersion does.
+func NewWZFileKey(path string, version int, key *WzKey) (*WZFile, error) {
+	return openWZFile(path, version, key)
+}
+
+// openWZFile opens the wz file at the given path and loads it with the given
+// version and key. If version is negative, it will be detected.
+func openWZFile(path string, version int, key *WzKey) (*WZFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := file.Stat()
+	if err == nil {
+		var f *WZFile
+		f, err = readWZHeader(file, fi.Size(), filepath.Base(path))
+		if err == nil {
+			err = f.load(version, key)
+		}
+		if err == nil {
+			f.closer = file
+			return f, nil
+		}
+	}
+
+	file.Close()
+	return nil, err
+}
+
+// NewWZFileFromReader parses the directory tree of a wz file of the given
+// size stored in r for the given maple version. The region key is detected
+// automatically, and so is the version if it's negative.
+// name is the name of the wz file (for example Mob.wz) and will be the name
+// of the root directory.
+func NewWZFileFromReader(r io.ReaderAt, size int64, name string,
+	version int) (*WZFile, error) {
+
+	return NewWZFileFromReaderKey(r, size, name, version, nil)
+}
+
+// NewWZFileFromReaderKey parses the directory tree of a wz file of the
+// given size stored in r for the given maple version and region key.
+// If key is nil, it will be detected automatically. If version is negative,
+// it will be detected like DetectVersion does.
+func NewWZFileFromReaderKey(r io.ReaderAt, size int64, name string,
+	version int, key *WzKey) (*WZFile, error) {
+
+	f, err := readWZHeader(r, size, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.load(version, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// readWZHeader parses the header of a wz file without reading the
+// directory tree
+func readWZHeader(r io.ReaderAt, size int64, name string) (*WZFile, error) {
+	f := &WZFile{
+		name: name,
+		r:    r,
+		size: size,
+		key:  BMSKey,
+	}
+	f.owner = f
+
+	rd := f.newReader()
+	if string(rd.read(4)) != "PKG1" {
+		return nil, fmt.Errorf("%s is not a valid wz file", name)
+	}
+
+	rd.readUint64() // data size
+	f.fstart = rd.readUint32()
+	f.copyright = rd.readCString()
+	rd.pos = int64(f.fstart)
+	f.encVersion = rd.readUint16()
+	if rd.err != nil {
+		return nil, fmt.Errorf("%s has a corrupted header: %v", name, rd.err)
+	}
+
+	return f, nil
+}
+
+// load parses the directory tree with the given version and key.
+// If version is negative, it will be detected. If key is nil, every known
+// region key is tried until names decode correctly.
+func (f *WZFile) load(version int, key *WzKey) error {
+	keys := wzKeys
+	if key != nil {
+		keys = []*WzKey{key}
+	}
+
+	var err error
+	for _, k := range keys {
+		f.key = k
+		if version < 0 {
+			err = f.detectVersion()
+		} else {
+			err = f.setVersion(version)
+		}
+
+		if err == nil && (key != nil || f.checkKey()) {
+			return nil
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("Could not detect the region key of %s", f.name)
+	}
+	return err
+}
+
+// checkKey checks that the current key decodes the first img header
+// correctly. If there are no imgs, it checks that all names are printable.
+func (f *WZFile) checkKey() bool {
+	var file MapleDataFileEntry
+	printable := true
+
+	var walk func(dir MapleDataDirectoryEntry)
+	walk = func(dir MapleDataDirectoryEntry) {
+		for _, e := range dir.Files() {
+			if file == nil {
+				file = e
+			}
+			printable = printable && isPrintable(e.Name())
+		}
+		for _, e := range dir.Subdirectories() {
+			printable = printable && isPrintable(e.Name())
+			walk(e)
+		}
+	}
+	walk(f.root)
+
+	if file == nil {
+		return printable
+	}
+
+	r := f.newReader()
+	r.pos = int64(file.Offset())
+	return r.readByte() == 0x73 && r.readString() == "Property" &&
+		r.err == nil
+}
+
+// isPrintable checks that a decoded name doesn't contain control characters
+func isPrintable(s string) bool {
+	for _, c := range s {
+		if !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// setVersion computes the version hash for the given version and parses
+// the directory tree with it
+func (f *WZFile) setVersion(version int) error {
+	hash := VersionHash(version)
+	if EncryptVersion(hash) != f.encVersion {
+		return fmt.Errorf("%s is not a v%d wz file", f.name, version)
+	}
+
+	f.version = version
+	f.hash = hash
+	f.root = NewDirectoryEntry(f.name, 0, 0, nil)
+	f.root.SetOffset(int(f.fstart) + 2)
+
+	rd := f.newReader()
+	rd.pos = int64(f.fstart) + 2
+	f.parseDirectory(rd, f.root, map[int64]bool{})
+	return rd.err
+}
+
+// newReader returns a new reader over the wz file's data
+func (f *WZFile) newReader() *wzReader {
+	return newWzReader(f.r, f.size, f.key)
+}
+
+// parseDirectory parses the directory listing at the reader's position
+// into dir and then recursively parses its subdirectories
+func (f *WZFile) parseDirectory(r *wzReader, dir *DirectoryEntry,
+	seen map[int64]bool) {
+
+	if seen[r.pos] {
+		r.fail(errCorrupt)
+		return
+	}
+	seen[r.pos] = true
+
+	count := int(r.readCompressedInt())
+	subdirs := make([]*DirectoryEntry, 0)
+
+	for i := 0; i < count && r.err == nil; i++ {
+		var name string
+		t := r.readByte()
+
+		switch t {
+		case 1: // unknown, always skipped
+			r.skip(6)
+			r.readOffset(f.fstart, f.hash)
+			continue
+
+		case 2: // name stored somewhere else in the file
+			stroff := int64(f.fstart) + int64(r.readInt32())
+			back := r.pos
+			r.pos = stroff
+			t = r.readByte()
+			name = r.readString()
+			r.pos = back
+
+		case 3, 4:
+			name = r.readString()
+
+		default:
+			r.fail(fmt.Errorf("Unknown directory entry type %d", t))
+			return
+		}
+
+		size := int(r.readCompressedInt())
+		checksum := int(r.readCompressedInt())
+		offset := r.readOffset(f.fstart, f.hash)
+		if offset < int64(f.fstart) || offset >= f.size {
+			r.fail(fmt.Errorf("%s: offset %d is out of bounds", name, offset))
+			return
+		}
+
+		switch t {
+		case 3:
+			subdir := NewDirectoryEntry(name, size, checksum, dir)
+			subdir.SetOffset(int(offset))
+			dir.AddDirectory(subdir)
+			subdirs = append(subdirs, subdir)
+
+		case 4:
+			file := NewFileEntry(name, size, checksum, dir)
+			file.SetOffset(int(offset))
+			dir.AddFile(file)
+
+		default:
+			r.fail(fmt.Errorf("Unknown directory entry type %d", t))
+		}
+	}
+
+	for _, subdir := range subdirs {
+		if r.err != nil {
+			return
+		}
+		r.pos = int64(subdir.Offset())
+		f.parseDirectory(r, subdir, seen)
+	}
+}
+
+// fileEntry looks up the img file entry at the given path
+func (f *WZFile) fileEntry(path string) (MapleDataFileEntry, error) {
+	path = strings.TrimPrefix(filepath.ToSlash(path), f.name+"/")
+	segments := strings.Split(path, "/")
+
+	var dir MapleDataDirectoryEntry = f.root
+	for i, segment := range segments {
+		entry := dir.GetEntry(segment)
+
+		if i == len(segments)-1 {
+			if _, isdir := entry.(MapleDataDirectoryEntry); isdir {
+				break
+			}
+			if file, ok := entry.(MapleDataFileEntry); ok {
+				return file, nil
+			}
+			break
+		}
+
+		subdir, ok := entry.(MapleDataDirectoryEntry)
+		if !ok {
+			break
+		}
+		dir = subdir
+	}
+
+	return nil, notFoundError(f.name+"/"+path, nil)
+}
+
+// Get parses and returns the img at the given path relative to the wz file
+func (f *WZFile) Get(path string) (MapleData, error) {
+	entry, err := f.fileEntry(path)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := parseImg(f.newReader(), entry.Name(), int64(entry.Offset()))
+	if err != nil {
+		return nil, malformedImgError(path, err)
+	}
+
+	img.provider = f.LinkProvider()
+	return img, nil
+}
+
+// Root returns the root directory entry of the wz file
+func (f *WZFile) Root() MapleDataDirectoryEntry { return f.root }
+
+// Version returns the maple version the wz file was parsed for
+func (f *WZFile) Version() int { return f.version }
+
+// Key returns the region key used to decrypt strings
+func (f *WZFile) Key() *WzKey { return f.key }
+
+// VersionHash returns the version hash used to decrypt directory offsets
+func (f *WZFile) VersionHash() uint32 { return f.hash }
+
+// Close closes the underlying file if the WZFile was opened with NewWZFile
+func (f *WZFile) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
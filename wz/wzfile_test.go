@@ -0,0 +1,667 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+import "github.com/Francesco149/maplelib"
+
+// testProp describes a property of a synthetic img for the binary wz tests
+type testProp struct {
+	name     string
+	kind     string
+	value    interface{}
+	children []testProp
+}
+
+type testImg struct {
+	name  string
+	props []testProp
+}
+
+type testDir struct {
+	name string
+	dirs []testDir
+	imgs []testImg
+}
+
+// testImgWriter encodes a synthetic img the same way the client does,
+// including string deduplication through offsets
+type testImgWriter struct {
+	bytes.Buffer
+	key     []byte
+	strings map[string]int
+}
+
+func (w *testImgWriter) u16(v uint16) { binary.Write(w, binary.LittleEndian, v) }
+func (w *testImgWriter) u32(v uint32) { binary.Write(w, binary.LittleEndian, v) }
+
+func (w *testImgWriter) compressedInt(v int32) {
+	if v > math.MinInt8 && v <= math.MaxInt8 {
+		w.WriteByte(byte(int8(v)))
+		return
+	}
+	w.WriteByte(0x80)
+	w.u32(uint32(v))
+}
+
+func (w *testImgWriter) keyAt(i int) byte {
+	if i >= len(w.key) {
+		return 0
+	}
+	return w.key[i]
+}
+
+func (w *testImgWriter) str(s string) {
+	if s == "" {
+		w.WriteByte(0)
+		return
+	}
+
+	ascii := true
+	for _, c := range s {
+		if c >= 0x80 {
+			ascii = false
+		}
+	}
+
+	if ascii {
+		if len(s) < 128 {
+			w.WriteByte(byte(int8(-len(s))))
+		} else {
+			w.WriteByte(0x80)
+			w.u32(uint32(len(s)))
+		}
+		mask := byte(0xAA)
+		for i := 0; i < len(s); i++ {
+			w.WriteByte(s[i] ^ mask ^ w.keyAt(i))
+			mask++
+		}
+		return
+	}
+
+	chars := utf16.Encode([]rune(s))
+	if len(chars) < 127 {
+		w.WriteByte(byte(len(chars)))
+	} else {
+		w.WriteByte(0x7F)
+		w.u32(uint32(len(chars)))
+	}
+	mask := uint16(0xAAAA)
+	for i, c := range chars {
+		w.u16(c ^ mask ^ (uint16(w.keyAt(i*2+1))<<8 | uint16(w.keyAt(i*2))))
+		mask++
+	}
+}
+
+// strBlock writes a string block, referencing previous copies of the string
+func (w *testImgWriter) strBlock(s string, inline, ref byte) {
+	if off, ok := w.strings[s]; ok && len(s) > 4 {
+		w.WriteByte(ref)
+		w.u32(uint32(off))
+		return
+	}
+	w.WriteByte(inline)
+	w.strings[s] = w.Len()
+	w.str(s)
+}
+
+func (w *testImgWriter) props(props []testProp) {
+	w.compressedInt(int32(len(props)))
+	for _, p := range props {
+		w.strBlock(p.name, 0x00, 0x01)
+
+		switch p.kind {
+		case "null":
+			w.WriteByte(0x00)
+		case "short":
+			w.WriteByte(0x02)
+			w.u16(uint16(p.value.(int16)))
+		case "int":
+			w.WriteByte(0x03)
+			w.compressedInt(p.value.(int32))
+		case "long":
+			w.WriteByte(0x14)
+			if v := p.value.(int64); v > math.MinInt8 && v <= math.MaxInt8 {
+				w.WriteByte(byte(int8(v)))
+			} else {
+				w.WriteByte(0x80)
+				binary.Write(w, binary.LittleEndian, v)
+			}
+		case "float":
+			w.WriteByte(0x04)
+			if p.value.(float32) == 0 {
+				w.WriteByte(0)
+			} else {
+				w.WriteByte(0x80)
+				w.u32(math.Float32bits(p.value.(float32)))
+			}
+		case "double":
+			w.WriteByte(0x05)
+			binary.Write(w, binary.LittleEndian, p.value.(float64))
+		case "string":
+			w.WriteByte(0x08)
+			w.strBlock(p.value.(string), 0x00, 0x01)
+		default:
+			w.WriteByte(0x09)
+			sizepos := w.Len()
+			w.u32(0)
+			w.extended(p)
+			binary.LittleEndian.PutUint32(w.Bytes()[sizepos:],
+				uint32(w.Len()-sizepos-4))
+		}
+	}
+}
+
+func (w *testImgWriter) extended(p testProp) {
+	switch p.kind {
+	case "sub":
+		w.strBlock("Property", 0x73, 0x1B)
+		w.u16(0)
+		w.props(p.children)
+
+	case "vector":
+		w.strBlock("Shape2D#Vector2D", 0x73, 0x1B)
+		pt := p.value.(image.Point)
+		w.compressedInt(int32(pt.X))
+		w.compressedInt(int32(pt.Y))
+
+	case "uol":
+		w.strBlock("UOL", 0x73, 0x1B)
+		w.WriteByte(0)
+		w.strBlock(p.value.(string), 0x00, 0x01)
+
+	case "convex":
+		w.strBlock("Shape2D#Convex2D", 0x73, 0x1B)
+		w.compressedInt(int32(len(p.children)))
+		for _, c := range p.children {
+			w.extended(c)
+		}
+
+	case "canvas":
+		c := p.value.(testCanvas)
+		w.strBlock("Canvas", 0x73, 0x1B)
+		w.WriteByte(0)
+		if len(p.children) > 0 {
+			w.WriteByte(1)
+			w.u16(0)
+			w.props(p.children)
+		} else {
+			w.WriteByte(0)
+		}
+		w.compressedInt(int32(c.width))
+		w.compressedInt(int32(c.height))
+		w.compressedInt(int32(c.format))
+		w.WriteByte(byte(c.format2))
+		w.u32(0)
+		w.u32(uint32(len(c.data) + 1))
+		w.WriteByte(0)
+		w.Write(c.data)
+
+	case "sound":
+		s := p.value.(testSound)
+		w.strBlock("Sound_DX8", 0x73, 0x1B)
+		w.WriteByte(0)
+		w.compressedInt(int32(len(s.data)))
+		w.compressedInt(int32(s.duration))
+		w.Write(s.header)
+		w.Write(s.data)
+
+	default:
+		panic("unknown test property kind " + p.kind)
+	}
+}
+
+type testCanvas struct {
+	width, height   int
+	format, format2 int
+	data            []byte
+}
+
+type testSound struct {
+	duration int
+	header   []byte
+	data     []byte
+}
+
+func encodeTestImg(img testImg, key []byte) []byte {
+	w := &testImgWriter{key: key, strings: map[string]int{}}
+	w.WriteByte(0x73)
+	w.str("Property")
+	w.u16(0)
+	w.props(img.props)
+	return w.Bytes()
+}
+
+// testWzLayout holds the computed positions of a synthetic wz file
+type testWzLayout struct {
+	fstart  uint32
+	hash    uint32
+	key     []byte
+	dirpos  map[*testDir]int
+	imgpos  map[*testImg]int
+	imgdata map[*testImg][]byte
+}
+
+// listing encodes the directory listing of dir which is stored at pos
+func (l *testWzLayout) listing(dir *testDir, pos int) []byte {
+	w := &testImgWriter{key: l.key}
+	w.compressedInt(int32(len(dir.dirs) + len(dir.imgs)))
+
+	offset := func(target int) {
+		mask := offsetMask(uint32(pos+w.Len()), l.fstart, l.hash)
+		w.u32((uint32(target) - l.fstart*2) ^ mask)
+	}
+
+	for i := range dir.dirs {
+		d := &dir.dirs[i]
+		w.WriteByte(3)
+		w.str(d.name)
+		w.compressedInt(0)
+		w.compressedInt(0)
+		offset(l.dirpos[d])
+	}
+
+	for i := range dir.imgs {
+		img := &dir.imgs[i]
+		data := l.imgdata[img]
+		checksum := int32(0)
+		for _, b := range data {
+			checksum += int32(b)
+		}
+		w.WriteByte(4)
+		w.str(img.name)
+		w.compressedInt(int32(len(data)))
+		w.compressedInt(checksum)
+		offset(l.imgpos[img])
+	}
+
+	return w.Bytes()
+}
+
+// encodeTestWz builds a binary wz file for the given directory tree
+func encodeTestWz(root *testDir, version int, wzkey *WzKey) []byte {
+	const copyright = "Package file v1.0 Copyright 2002 Wizet, ZZF"
+
+	key := wzkey.keystream(wzKeyChunk)
+
+	l := &testWzLayout{
+		fstart:  uint32(4 + 8 + 4 + len(copyright) + 1),
+		hash:    VersionHash(version),
+		key:     key,
+		dirpos:  map[*testDir]int{},
+		imgpos:  map[*testImg]int{},
+		imgdata: map[*testImg][]byte{},
+	}
+
+	var dirs []*testDir
+	var walk func(d *testDir)
+	walk = func(d *testDir) {
+		dirs = append(dirs, d)
+		for i := range d.imgs {
+			l.imgdata[&d.imgs[i]] = encodeTestImg(d.imgs[i], key)
+		}
+		for i := range d.dirs {
+			walk(&d.dirs[i])
+		}
+	}
+	walk(root)
+
+	// listings have a fixed size regardless of the offsets they contain
+	pos := int(l.fstart) + 2
+	for _, d := range dirs {
+		l.dirpos[d] = pos
+		pos += len(l.listing(d, pos))
+	}
+	for _, d := range dirs {
+		for i := range d.imgs {
+			l.imgpos[&d.imgs[i]] = pos
+			pos += len(l.imgdata[&d.imgs[i]])
+		}
+	}
+
+	w := &testImgWriter{}
+	w.WriteString("PKG1")
+	binary.Write(w, binary.LittleEndian, uint64(pos-int(l.fstart)))
+	w.u32(l.fstart)
+	w.WriteString(copyright)
+	w.WriteByte(0)
+	w.u16(EncryptVersion(l.hash))
+	for _, d := range dirs {
+		w.Write(l.listing(d, w.Len()))
+	}
+	for _, d := range dirs {
+		for i := range d.imgs {
+			w.Write(l.imgdata[&d.imgs[i]])
+		}
+	}
+
+	return w.Bytes()
+}
+
+// testWzTree returns the directory tree used by the binary wz tests
+func testWzTree() *testDir {
+	return &testDir{
+		name: "Mob.wz",
+		dirs: []testDir{{
+			name: "sub",
+			imgs: []testImg{{
+				name: "9999999.img",
+				props: []testProp{
+					{name: "name", kind: "string", value: "スライム"},
+				},
+			}},
+		}},
+		imgs: []testImg{{
+			name: "0100100.img",
+			props: []testProp{
+				{name: "info", kind: "sub", children: []testProp{
+					{name: "speed", kind: "int", value: int32(180)},
+					{name: "maxHP", kind: "int", value: int32(100000)},
+					{name: "swim", kind: "float", value: float32(100)},
+					{name: "fs", kind: "float", value: float32(0)},
+					{name: "pushed", kind: "short", value: int16(-5)},
+					{name: "rate", kind: "double", value: 0.25},
+					{name: "elemAttr", kind: "string", value: "L3"},
+					{name: "nothing", kind: "null"},
+				}},
+				{name: "stand", kind: "sub", children: []testProp{
+					{name: "0", kind: "canvas",
+						value: testCanvas{width: 2, height: 1, format: 2,
+							data: testDeflate([]byte{
+								0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x80,
+							})},
+						children: []testProp{
+							{name: "origin", kind: "vector",
+								value: image.Pt(29, -51)},
+						}},
+					{name: "1", kind: "uol", value: "../stand/0"},
+				}},
+				{name: "speed", kind: "int", value: int32(1)},
+				{name: "area", kind: "convex", children: []testProp{
+					{name: "0", kind: "vector", value: image.Pt(0, 0)},
+					{name: "1", kind: "vector", value: image.Pt(10, 0)},
+				}},
+			},
+		}},
+	}
+}
+
+func writeTestWz(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "Mob.wz")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWZFile(t *testing.T) {
+	path := writeTestWz(t, encodeTestWz(testWzTree(), 83, BMSKey))
+
+	f, err := NewWZFile(path, 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	root := f.Root()
+	if root.Name() != "Mob.wz" || len(root.Subdirectories()) != 1 ||
+		len(root.Files()) != 1 {
+
+		t.Fatalf("unexpected root directory %v %v %v", root.Name(),
+			root.Subdirectories(), root.Files())
+	}
+
+	entry := root.Files()[0]
+	if entry.Name() != "0100100.img" || entry.Size() == 0 ||
+		entry.Checksum() == 0 || entry.Offset() == 0 {
+
+		t.Errorf("unexpected file entry %v size=%d checksum=%d offset=%d",
+			entry.Name(), entry.Size(), entry.Checksum(), entry.Offset())
+	}
+
+	img, err := f.Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"info/speed":       int32(180),
+		"info/maxHP":       int32(100000),
+		"info/swim":        float32(100),
+		"info/fs":          float32(0),
+		"info/pushed":      int16(-5),
+		"info/rate":        0.25,
+		"info/elemAttr":    "L3",
+		"info/nothing":     nil,
+		"stand/0/origin":   image.Pt(29, -51),
+		"stand/1":          "../stand/0",
+		"speed":            int32(1),
+		"info/../speed":    int32(1),
+		"stand/0/../1":     "../stand/0",
+		"area/1":           image.Pt(10, 0),
+		"stand/0/origin/x": nil,
+	}
+
+	for path, val := range expected {
+		data := img.ChildByPath(path)
+		if path == "stand/0/origin/x" {
+			if data != nil {
+				t.Errorf("0100100.img/%s should not exist", path)
+			}
+			continue
+		}
+		if data == nil {
+			t.Errorf("0100100.img/%s not found", path)
+			continue
+		}
+		if got := data.Get(); !reflect.DeepEqual(got, val) {
+			t.Errorf("0100100.img/%s = %T(%v), expected %T(%v)",
+				path, got, got, val, val)
+		}
+	}
+
+	types := map[string]MapleDataType{
+		"info":         PROPERTY,
+		"info/nothing": IMG_0x00,
+		"stand/0":      CANVAS,
+		"stand/1":      UOL,
+		"area":         CONVEX,
+	}
+	for path, typ := range types {
+		if got := img.ChildByPath(path).Type(); got != typ {
+			t.Errorf("0100100.img/%s type = %v, expected %v", path, got, typ)
+		}
+	}
+
+	if n := len(img.ChildByPath("info").Children()); n != 8 {
+		t.Errorf("0100100.img/info has %d children, expected 8", n)
+	}
+
+	fullpath := GetFullDataPath(img.ChildByPath("stand/0/origin"))
+	if fullpath != "0100100.img/stand/0/origin" {
+		t.Errorf("full data path = %s", fullpath)
+	}
+
+	img, err = f.Get("Mob.wz/sub/9999999.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := GetStringD(img.ChildByPath("name"), ""); name != "スライム" {
+		t.Errorf("sub/9999999.img/name = %q", name)
+	}
+
+	if _, err = f.Get("sub"); err == nil {
+		t.Errorf("getting a directory should fail")
+	}
+	if _, err = f.Get("0100101.img"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getting a missing img: err = %v, expected ErrNotFound",
+			err)
+	}
+}
+
+func TestWZFileVersion(t *testing.T) {
+	path := writeTestWz(t, encodeTestWz(testWzTree(), 83, BMSKey))
+
+	if _, err := NewWZFile(path, 62); err == nil {
+		t.Errorf("opening a v83 wz file as v62 should fail")
+	}
+
+	p, err := NewMapleDataProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(*WZFile).Close()
+
+	if v := p.(*WZFile).Version(); v != 83 {
+		t.Errorf("detected version %d, expected 83", v)
+	}
+
+	path = writeTestWz(t, []byte("PKG0 not a wz file"))
+	if _, err := NewMapleDataProvider(path); err == nil {
+		t.Errorf("opening an invalid wz file should fail")
+	}
+}
+
+func TestDetectVersion(t *testing.T) {
+	for _, version := range []int{62, 83, 176} {
+		path := writeTestWz(t, encodeTestWz(testWzTree(), version, BMSKey))
+
+		detected, err := DetectVersion(path)
+		if err != nil {
+			t.Errorf("v%d: %v", version, err)
+			continue
+		}
+		if detected != version {
+			t.Errorf("detected version %d, expected %d", detected, version)
+		}
+
+		f, err := NewWZFile(path, -1)
+		if err != nil {
+			t.Errorf("v%d: NewWZFile with a negative version: %v", version,
+				err)
+			continue
+		}
+		if f.Version() != version {
+			t.Errorf("NewWZFile detected version %d, expected %d",
+				f.Version(), version)
+		}
+		f.Close()
+	}
+
+	candidates := VersionCandidates(EncryptVersion(VersionHash(83)), 1000)
+	found := false
+	for _, v := range candidates {
+		found = found || v == 83
+	}
+	if !found {
+		t.Errorf("83 is not among the candidates %v", candidates)
+	}
+
+	// corrupt the offset of the first directory entry
+	data := encodeTestWz(testWzTree(), 83, BMSKey)
+	fstart := int(binary.LittleEndian.Uint32(data[12:]))
+	// version, count, type, name ("sub"), size, checksum
+	pos := fstart + 2 + 1 + 1 + 1 + 3 + 1 + 1
+	for i := 0; i < 4; i++ {
+		data[pos+i] = 0xFF
+	}
+
+	_, err := DetectVersion(writeTestWz(t, data))
+	verr, ok := err.(*VersionNotFoundError)
+	if !ok {
+		t.Fatalf("expected a *VersionNotFoundError, got %v", err)
+	}
+	if len(verr.Tried) == 0 || verr.Name != "Mob.wz" {
+		t.Errorf("unexpected error %v", verr)
+	}
+}
+
+func TestWzKey(t *testing.T) {
+	key := maplelib.AESKey()
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []*WzKey{GMSKey, SEAKey} {
+		iv := k.IV()
+		var expected [32]byte
+		copy(expected[:], bytes.Repeat(iv[:], 4))
+		block.Encrypt(expected[:16], expected[:16])
+		block.Encrypt(expected[16:], expected[:16])
+
+		for i, b := range expected {
+			if k.At(i) != b {
+				t.Errorf("keystream for iv % X = % X, expected % X",
+					iv, k.keystream(32)[:32], expected)
+				break
+			}
+		}
+	}
+
+	for i := 0; i < wzKeyChunk*2; i++ {
+		if BMSKey.At(i) != 0 {
+			t.Fatalf("the bms keystream must be all zeros")
+		}
+	}
+
+	for _, k := range []*WzKey{GMSKey, SEAKey, BMSKey} {
+		w := &testImgWriter{key: k.keystream(64)}
+		w.str("Property")
+		if s := k.DecodeASCII(w.Bytes()[1:]); s != "Property" {
+			t.Errorf("iv % X: decoded %q, expected Property", k.IV(), s)
+		}
+
+		w.Reset()
+		w.str("ガスト")
+		if s := k.DecodeUnicode(w.Bytes()[1:]); s != "ガスト" {
+			t.Errorf("iv % X: decoded %q, expected ガスト", k.IV(), s)
+		}
+
+		path := writeTestWz(t, encodeTestWz(testWzTree(), 83, k))
+		p, err := NewMapleDataProvider(path)
+		if err != nil {
+			t.Errorf("iv % X: %v", k.IV(), err)
+			continue
+		}
+
+		f := p.(*WZFile)
+		if f.Key() != k {
+			t.Errorf("detected iv % X, expected % X", f.Key().IV(), k.IV())
+		}
+
+		img, err := f.Get("sub/9999999.img")
+		if err != nil {
+			t.Errorf("iv % X: %v", k.IV(), err)
+		} else if name := GetStringD(img.ChildByPath("name"), ""); name != "スライム" {
+			t.Errorf("iv % X: name = %q", k.IV(), name)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,127 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+// 90% of this package is ported directly from OdinMS, so credits to them
+
+import "strings"
+
+// A WZIMGEntry is a node of an img parsed from a binary wz file or a wz xml
+// file. The whole img is parsed at once into a tree of WZIMGEntry objects
+// holding typed values.
+type WZIMGEntry struct {
+	name     string
+	datatype MapleDataType
+	data     interface{}
+	parent   *WZIMGEntry
+	children []*WZIMGEntry
+	provider MapleDataProvider // only set on the root node
+}
+
+// NewWZIMGEntry initializes an empty WZIMGEntry with the given parent.
+// parent can be nil for root nodes.
+func NewWZIMGEntry(name string, datatype MapleDataType, parent *WZIMGEntry,
+) *WZIMGEntry {
+
+	return &WZIMGEntry{
+		name:     name,
+		datatype: datatype,
+		parent:   parent,
+	}
+}
+
+// addChild appends a child node and sets its parent to this node
+func (e *WZIMGEntry) addChild(child *WZIMGEntry) {
+	child.parent = e
+	e.children = append(e.children, child)
+}
+
+// dataProvider returns the provider canvas links are resolved through
+func (e *WZIMGEntry) dataProvider() MapleDataProvider {
+	for e.parent != nil {
+		e = e.parent
+	}
+	return e.provider
+}
+
+// child returns the direct child with the given name or nil
+func (e *WZIMGEntry) child(name string) *WZIMGEntry {
+	for _, c := range e.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ChildByPath finds and returns a value by path relative to this node.
+// ".." segments walk to the parent node.
+func (e *WZIMGEntry) ChildByPath(path string) MapleData {
+	cur := e
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			cur = cur.parent
+		} else {
+			cur = cur.child(segment)
+		}
+
+		if cur == nil {
+			return nil
+		}
+	}
+
+	return cur
+}
+
+// Children returns the children entries of this node
+func (e *WZIMGEntry) Children() []MapleData {
+	res := make([]MapleData, len(e.children))
+	for i, c := range e.children {
+		res[i] = c
+	}
+	return res
+}
+
+// Get returns the value of this node as an interface.
+// If the value is invalid or absent, the return value is nil.
+// All the possible types returned by Get are float64, float32, int64, int32,
+// int16, string, image.Point, Convex, PNGMapleCanvas and ImgMapleSound, or
+// FileStoredPngMapleCanvas and FileStoredMapleSound for xml imgs.
+func (e *WZIMGEntry) Get() interface{} {
+	switch e.datatype {
+	case DOUBLE, FLOAT, LONG, INT, SHORT, STRING, UOL, VECTOR, CANVAS, SOUND:
+		return e.data
+	case CONVEX:
+		return convexFromChildren(e)
+	}
+
+	return nil
+}
+
+// Type returns the maple data type of this node.
+// See MapleDataType for more information.
+func (e *WZIMGEntry) Type() MapleDataType { return e.datatype }
+
+// Parent returns the parent node of this entry or nil for the img root
+func (e *WZIMGEntry) Parent() MapleDataEntity {
+	if e.parent == nil {
+		return nil
+	}
+	return e.parent
+}
+
+// Name returns the name of this entry
+func (e *WZIMGEntry) Name() string { return e.name }
@@ -0,0 +1,169 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+// 90% of this package is ported directly from OdinMS, so credits to them
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+)
+
+// soundHeaderSize is the size of the media type guids that precede the
+// WAVEFORMATEX header of a sound property
+const soundHeaderSize = 51
+
+// parseImg parses the img stored at the given offset into a tree of
+// WZIMGEntry objects
+func parseImg(r *wzReader, name string, offset int64) (*WZIMGEntry, error) {
+	r.pos = offset
+	if r.readByte() != 0x73 || r.readString() != "Property" ||
+		r.readUint16() != 0 {
+
+		r.fail(fmt.Errorf("%s is not a valid img", name))
+	}
+
+	root := NewWZIMGEntry(name, PROPERTY, nil)
+	parsePropertyList(r, offset, root)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return root, nil
+}
+
+// parsePropertyList parses a list of properties and appends them to parent
+func parsePropertyList(r *wzReader, imgoff int64, parent *WZIMGEntry) {
+	count := int(r.readCompressedInt())
+
+	for i := 0; i < count && r.err == nil; i++ {
+		e := NewWZIMGEntry(r.readStringBlock(imgoff), NONE, nil)
+
+		switch t := r.readByte(); t {
+		case 0x00:
+			e.datatype = IMG_0x00
+
+		case 0x02, 0x0B:
+			e.datatype = SHORT
+			e.data = int16(r.readUint16())
+
+		case 0x03, 0x13:
+			e.datatype = INT
+			e.data = r.readCompressedInt()
+
+		case 0x14:
+			e.datatype = LONG
+			e.data = r.readCompressedLong()
+
+		case 0x04:
+			e.datatype = FLOAT
+			e.data = r.readCompressedFloat()
+
+		case 0x05:
+			e.datatype = DOUBLE
+			e.data = r.readFloat64()
+
+		case 0x08:
+			e.datatype = STRING
+			e.data = r.readStringBlock(imgoff)
+
+		case 0x09:
+			size := int64(r.readUint32())
+			end := r.pos + size
+			parseExtended(r, imgoff, e)
+			r.pos = end
+
+		default:
+			r.fail(fmt.Errorf("Unknown property type 0x%02X at offset %d",
+				t, r.pos-1))
+		}
+
+		parent.addChild(e)
+	}
+}
+
+// parseExtended parses an extended property such as a canvas or a vector
+// into e
+func parseExtended(r *wzReader, imgoff int64, e *WZIMGEntry) {
+	switch kind := r.readStringBlock(imgoff); kind {
+	case "Property":
+		e.datatype = PROPERTY
+		r.skip(2)
+		parsePropertyList(r, imgoff, e)
+
+	case "Canvas":
+		e.datatype = CANVAS
+		r.skip(1)
+		if r.readByte() == 1 {
+			r.skip(2)
+			parsePropertyList(r, imgoff, e)
+		}
+
+		w := int(r.readCompressedInt())
+		h := int(r.readCompressedInt())
+		format := int(r.readCompressedInt())
+		format2 := int(r.readByte())
+		r.skip(4)
+		length := int(r.readInt32()) - 1
+		r.skip(1)
+		canvas := newPNGMapleCanvas(w, h, format, format2, r.r, r.pos, length,
+			r.key)
+		if hasCanvasLink(e) {
+			canvas.linked = e
+		}
+		e.data = canvas
+		r.skip(int64(length))
+
+	case "Shape2D#Vector2D":
+		e.datatype = VECTOR
+		x := int(r.readCompressedInt())
+		y := int(r.readCompressedInt())
+		e.data = image.Pt(x, y)
+
+	case "Shape2D#Convex2D":
+		e.datatype = CONVEX
+		count := int(r.readCompressedInt())
+		for i := 0; i < count && r.err == nil; i++ {
+			child := NewWZIMGEntry(strconv.Itoa(i), NONE, nil)
+			parseExtended(r, imgoff, child)
+			e.addChild(child)
+		}
+
+	case "Sound_DX8":
+		e.datatype = SOUND
+		r.skip(1)
+		length := int(r.readCompressedInt())
+		duration := int(r.readCompressedInt())
+
+		headerpos := r.pos
+		r.skip(soundHeaderSize)
+		wavlen := int(r.readByte())
+		r.pos = headerpos
+		header := append([]byte(nil), r.read(soundHeaderSize+1+wavlen)...)
+
+		e.data = newImgMapleSound(duration, header, r.r, r.pos, length, r.key)
+		r.skip(int64(length))
+
+	case "UOL":
+		e.datatype = UOL
+		r.skip(1)
+		e.data = r.readStringBlock(imgoff)
+
+	default:
+		r.fail(fmt.Errorf("Unknown extended property %q", kind))
+	}
+}
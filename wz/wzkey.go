@@ -0,0 +1,142 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"sync"
+	"unicode/utf16"
+)
+
+import "github.com/Francesco149/maplelib"
+
+// wzKeyChunk is the amount of keystream bytes generated at once
+const wzKeyChunk = 4096
+
+// Initialization vectors used to generate the wz string keystream
+var (
+	GMSIV = [4]byte{0x4D, 0x23, 0xC7, 0x2B} // global maplestory
+	SEAIV = [4]byte{0xB9, 0x7D, 0x63, 0xE9} // maplesea and europe
+	BMSIV = [4]byte{0x00, 0x00, 0x00, 0x00} // korean, bms and other regions
+)
+
+// Shared keys for the known regions. Keys cache their keystream, so sharing
+// them across wz files avoids generating it multiple times.
+var (
+	GMSKey = NewWzKey(GMSIV)
+	SEAKey = NewWzKey(SEAIV)
+	BMSKey = NewWzKey(BMSIV)
+)
+
+// A WzKey generates and caches the keystream that is xored with strings
+// inside binary wz files. The keystream is maple's AES user key applied in
+// OFB mode over a region-specific initialization vector and it's generated
+// on demand as longer strings are decoded.
+// An all-zero initialization vector produces an all-zero keystream, which
+// is what regions without wz string encryption use.
+// A WzKey is safe for concurrent use.
+type WzKey struct {
+	iv    [4]byte
+	mutex sync.Mutex
+	block cipher.Block
+	keys  []byte
+}
+
+// NewWzKey initializes a wz string key for the given initialization vector
+func NewWzKey(iv [4]byte) *WzKey {
+	k := &WzKey{iv: iv}
+	if iv != [4]byte{} {
+		key := maplelib.AESKey()
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			panic(err) // the key has a constant valid size
+		}
+		k.block = block
+	}
+	return k
+}
+
+// IV returns the initialization vector of the key
+func (k *WzKey) IV() [4]byte { return k.iv }
+
+// keystream returns at least n bytes of keystream. The returned slice is
+// never modified afterwards, so it can be used without locking.
+func (k *WzKey) keystream(n int) []byte {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if len(k.keys) >= n {
+		return k.keys
+	}
+
+	size := (n + wzKeyChunk - 1) / wzKeyChunk * wzKeyChunk
+	keys := make([]byte, size)
+	copy(keys, k.keys)
+
+	if k.block != nil {
+		start := len(k.keys)
+		var prev [16]byte
+		if start == 0 {
+			for i := 0; i < 4; i++ {
+				copy(prev[4*i:], k.iv[:])
+			}
+		} else {
+			copy(prev[:], k.keys[start-16:])
+		}
+
+		for i := start; i < size; i += 16 {
+			k.block.Encrypt(keys[i:i+16], prev[:])
+			copy(prev[:], keys[i:i+16])
+		}
+	}
+
+	k.keys = keys
+	return keys
+}
+
+// At returns the i-th byte of the keystream
+func (k *WzKey) At(i int) byte {
+	return k.keystream(i + 1)[i]
+}
+
+// DecodeASCII decrypts the bytes of a single byte wz string
+func (k *WzKey) DecodeASCII(b []byte) string {
+	keys := k.keystream(len(b))
+	res := make([]byte, len(b))
+	mask := byte(0xAA)
+	for i := range res {
+		res[i] = b[i] ^ mask ^ keys[i]
+		mask++
+	}
+	return string(res)
+}
+
+// DecodeUnicode decrypts the bytes of an utf-16 wz string
+func (k *WzKey) DecodeUnicode(b []byte) string {
+	keys := k.keystream(len(b) &^ 1)
+	chars := make([]uint16, len(b)/2)
+	mask := uint16(0xAAAA)
+	for i := range chars {
+		c := binary.LittleEndian.Uint16(b[i*2:])
+		c ^= mask
+		c ^= binary.LittleEndian.Uint16(keys[i*2:])
+		chars[i] = c
+		mask++
+	}
+	return string(utf16.Decode(chars))
+}
@@ -0,0 +1,274 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+// 90% of this package is ported directly from OdinMS, so credits to them
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// wzReaderChunk is how many bytes a wzReader caches from the underlying
+// reader at once
+const wzReaderChunk = 64 * 1024
+
+// offsetKey is the constant used by the wz offset encryption
+const offsetKey = 0x581C3F6D
+
+var errCorrupt = errors.New("Corrupted wz data")
+
+// A wzReader is a little endian accessor with random access over binary wz
+// data. Errors are sticky: once a read fails, every following read returns
+// zero values and err holds the first error that occurred.
+type wzReader struct {
+	r      io.ReaderAt
+	size   int64
+	pos    int64
+	buf    []byte
+	bufpos int64
+	err    error
+	key    *WzKey
+}
+
+func newWzReader(r io.ReaderAt, size int64, key *WzKey) *wzReader {
+	return &wzReader{r: r, size: size, key: key}
+}
+
+// fail records err as the reader's error unless one was already recorded
+func (r *wzReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// read returns the next n bytes. The returned slice is only valid until the
+// next read.
+func (r *wzReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n < 0 || r.pos < 0 || r.pos+int64(n) > r.size {
+		r.fail(errCorrupt)
+		return nil
+	}
+
+	if r.pos < r.bufpos || r.pos+int64(n) > r.bufpos+int64(len(r.buf)) {
+		size := n
+		if size < wzReaderChunk {
+			size = wzReaderChunk
+		}
+		if cap(r.buf) < size {
+			r.buf = make([]byte, size)
+		}
+		r.buf = r.buf[:size]
+
+		m, err := r.r.ReadAt(r.buf, r.pos)
+		r.buf = r.buf[:m]
+		r.bufpos = r.pos
+		if m < n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			r.fail(err)
+			return nil
+		}
+	}
+
+	start := r.pos - r.bufpos
+	r.pos += int64(n)
+	return r.buf[start : start+int64(n)]
+}
+
+// skip advances the reader by n bytes without reading them
+func (r *wzReader) skip(n int64) {
+	if r.pos+n > r.size || n < 0 {
+		r.fail(errCorrupt)
+		return
+	}
+	r.pos += n
+}
+
+func (r *wzReader) readByte() byte {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *wzReader) readUint16() uint16 {
+	b := r.read(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *wzReader) readUint32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *wzReader) readUint64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *wzReader) readInt32() int32 { return int32(r.readUint32()) }
+
+func (r *wzReader) readFloat64() float64 {
+	return math.Float64frombits(r.readUint64())
+}
+
+// readCompressedInt reads a wz compressed int, which is a single signed byte
+// unless the byte is -128, in which case a full int32 follows
+func (r *wzReader) readCompressedInt() int32 {
+	b := int8(r.readByte())
+	if b == math.MinInt8 {
+		return r.readInt32()
+	}
+	return int32(b)
+}
+
+// readCompressedLong is the 64-bit version of readCompressedInt
+func (r *wzReader) readCompressedLong() int64 {
+	b := int8(r.readByte())
+	if b == math.MinInt8 {
+		return int64(r.readUint64())
+	}
+	return int64(b)
+}
+
+// readCompressedFloat reads a wz compressed float, which is a single zero
+// byte unless the value is non-zero, in which case the byte is 0x80 and
+// a full float32 follows
+func (r *wzReader) readCompressedFloat() float32 {
+	if r.readByte() != 0x80 {
+		return 0
+	}
+	return math.Float32frombits(r.readUint32())
+}
+
+// readCString reads a null terminated string
+func (r *wzReader) readCString() string {
+	var res []byte
+	for r.err == nil {
+		b := r.readByte()
+		if b == 0 {
+			break
+		}
+		res = append(res, b)
+	}
+	return string(res)
+}
+
+// readString reads an encrypted wz string. Positive lengths are utf-16
+// strings, negative lengths are single byte strings.
+func (r *wzReader) readString() string {
+	small := int8(r.readByte())
+
+	switch {
+	case small == 0:
+		return ""
+
+	case small > 0:
+		n := int(small)
+		if small == math.MaxInt8 {
+			n = int(r.readInt32())
+		}
+		if n < 0 || int64(n)*2 > r.size {
+			r.fail(errCorrupt)
+			return ""
+		}
+
+		b := r.read(n * 2)
+		if b == nil {
+			return ""
+		}
+		return r.key.DecodeUnicode(b)
+
+	default:
+		n := -int(small)
+		if small == math.MinInt8 {
+			n = int(r.readInt32())
+		}
+		if n < 0 || int64(n) > r.size {
+			r.fail(errCorrupt)
+			return ""
+		}
+
+		b := r.read(n)
+		if b == nil {
+			return ""
+		}
+		return r.key.DecodeASCII(b)
+	}
+}
+
+// readStringAt reads an encrypted wz string at the given absolute offset
+// and then returns to the current position
+func (r *wzReader) readStringAt(offset int64) string {
+	back := r.pos
+	r.pos = offset
+	res := r.readString()
+	r.pos = back
+	return res
+}
+
+// readStringBlock reads a string that is either stored inline or as an
+// offset relative to imgoff pointing to a previously stored copy
+func (r *wzReader) readStringBlock(imgoff int64) string {
+	switch r.readByte() {
+	case 0x00, 0x73:
+		return r.readString()
+	case 0x01, 0x1B:
+		return r.readStringAt(imgoff + int64(r.readInt32()))
+	}
+
+	r.fail(errCorrupt)
+	return ""
+}
+
+// readOffset reads and decrypts a directory entry offset
+func (r *wzReader) readOffset(fstart, hash uint32) int64 {
+	pos := uint32(r.pos)
+	enc := r.readUint32()
+	return int64(decryptOffset(pos, enc, fstart, hash))
+}
+
+// offsetMask computes the value that is xored with the offset stored at pos
+func offsetMask(pos, fstart, hash uint32) uint32 {
+	off := (pos - fstart) ^ 0xFFFFFFFF
+	off *= hash
+	off -= offsetKey
+	return bits.RotateLeft32(off, int(off&0x1F))
+}
+
+// decryptOffset decrypts an offset that was stored at position pos
+func decryptOffset(pos, enc, fstart, hash uint32) uint32 {
+	return (offsetMask(pos, fstart, hash) ^ enc) + fstart*2
+}
@@ -0,0 +1,199 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxDetectedVersion is the highest version tried when detecting the version
+// of a wz file
+const MaxDetectedVersion = 1000
+
+// A VersionNotFoundError is returned when none of the candidate versions
+// of a wz file passed validation
+type VersionNotFoundError struct {
+	Name       string // name of the wz file
+	EncVersion uint16 // encrypted version stored in the header
+	Tried      []int  // candidate versions that failed validation
+}
+
+func (e *VersionNotFoundError) Error() string {
+	tried := make([]string, len(e.Tried))
+	for i, v := range e.Tried {
+		tried[i] = strconv.Itoa(v)
+	}
+
+	return fmt.Sprintf(
+		"Could not detect the version of %s (encrypted version 0x%04X), "+
+			"tried: [%s]", e.Name, e.EncVersion, strings.Join(tried, ", "))
+}
+
+// VersionHash computes the hash of a maple version which is used to
+// encrypt directory offsets
+func VersionHash(version int) (hash uint32) {
+	for _, c := range []byte(strconv.Itoa(version)) {
+		hash = hash*32 + uint32(c) + 1
+	}
+	return
+}
+
+// EncryptVersion computes the 2-byte encrypted version stored in the
+// header of a wz file for the given version hash
+func EncryptVersion(hash uint32) uint16 {
+	return uint16(0xFF ^ (hash >> 24 & 0xFF) ^ (hash >> 16 & 0xFF) ^
+		(hash >> 8 & 0xFF) ^ (hash & 0xFF))
+}
+
+// VersionCandidates returns all the versions up to maxVersion whose hash
+// matches the given encrypted version
+func VersionCandidates(encVersion uint16, maxVersion int) []int {
+	res := make([]int, 0)
+	for version := 0; version <= maxVersion; version++ {
+		if EncryptVersion(VersionHash(version)) == encVersion {
+			res = append(res, version)
+		}
+	}
+	return res
+}
+
+// DetectVersion detects the maple version of the wz file at the given path.
+// See DetectVersionFromReader.
+func DetectVersion(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return DetectVersionFromReader(file, fi.Size(), filepath.Base(path))
+}
+
+// DetectVersionFromReader detects the maple version of a wz file of the
+// given size stored in r.
+// Every version up to MaxDetectedVersion whose hash matches the encrypted
+// version in the header is validated against the offset of the first
+// directory entry. The first valid version is returned, otherwise the error
+// is a *VersionNotFoundError listing all the versions that were tried.
+func DetectVersionFromReader(r io.ReaderAt, size int64, name string) (
+	int, error) {
+
+	f, err := readWZHeader(r, size, name)
+	if err != nil {
+		return 0, err
+	}
+
+	versions := f.validVersions()
+	if len(versions) == 0 {
+		return 0, f.versionNotFound()
+	}
+
+	return versions[0], nil
+}
+
+// versionNotFound builds the error returned when version detection fails
+func (f *WZFile) versionNotFound() error {
+	return &VersionNotFoundError{
+		Name:       f.name,
+		EncVersion: f.encVersion,
+		Tried:      VersionCandidates(f.encVersion, MaxDetectedVersion),
+	}
+}
+
+// validVersions returns the candidate versions that pass validation against
+// the first directory entry
+func (f *WZFile) validVersions() []int {
+	res := make([]int, 0)
+	for _, version := range VersionCandidates(f.encVersion, MaxDetectedVersion) {
+		if f.validateVersion(VersionHash(version)) {
+			res = append(res, version)
+		}
+	}
+	return res
+}
+
+// validateVersion decrypts the offset of the first entry of the root
+// directory with the given version hash and checks that it points to
+// valid data
+func (f *WZFile) validateVersion(hash uint32) bool {
+	r := f.newReader()
+	r.pos = int64(f.fstart) + 2
+	count := int(r.readCompressedInt())
+
+	for i := 0; i < count && r.err == nil; i++ {
+		t := r.readByte()
+
+		switch t {
+		case 1:
+			r.skip(10)
+			continue
+
+		case 2:
+			stroff := int64(f.fstart) + int64(r.readInt32())
+			back := r.pos
+			r.pos = stroff
+			t = r.readByte()
+			r.pos = back
+
+		case 3, 4:
+			r.readString()
+
+		default:
+			return false
+		}
+
+		r.readCompressedInt() // size
+		r.readCompressedInt() // checksum
+		offset := r.readOffset(f.fstart, hash)
+		if r.err != nil || offset < int64(f.fstart) || offset >= f.size {
+			return false
+		}
+
+		r.pos = offset
+		if t == 4 {
+			// imgs always start with an inline "Property" string
+			return r.readByte() == 0x73 && r.err == nil
+		}
+
+		// directories start with a non-negative entry count
+		return r.readCompressedInt() >= 0 && r.err == nil
+	}
+
+	// an empty wz file validates with any version
+	return r.err == nil
+}
+
+// detectVersion parses the directory tree with every valid candidate
+// version until one succeeds
+func (f *WZFile) detectVersion() error {
+	for _, version := range f.validVersions() {
+		if f.setVersion(version) == nil {
+			return nil
+		}
+	}
+
+	return f.versionNotFound()
+}
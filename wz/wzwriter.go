@@ -0,0 +1,630 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// wzCopyright is the copyright string stored in the header of wz files
+const wzCopyright = "Package file v1.0 Copyright 2002 Wizet, ZZF"
+
+// soundMediaType holds the media type guids that precede the WAVEFORMATEX
+// header of a sound property
+var soundMediaType = [soundHeaderSize]byte{
+	0x02,
+	0x83, 0xEB, 0x36, 0xE4, 0x4F, 0x52, 0xCE, 0x11,
+	0x9F, 0x53, 0x00, 0x20, 0xAF, 0x0B, 0xA7, 0x70,
+	0x8B, 0xEB, 0x36, 0xE4, 0x4F, 0x52, 0xCE, 0x11,
+	0x9F, 0x53, 0x00, 0x20, 0xAF, 0x0B, 0xA7, 0x70,
+	0x00,
+	0x01,
+	0x81, 0x9F, 0x58, 0x05, 0x56, 0xC3, 0xCE, 0x11,
+	0xBF, 0x01, 0x00, 0xAA, 0x00, 0x55, 0x59, 0x5A,
+}
+
+// A wzWriter is a little endian buffer that encodes binary wz data, the
+// opposite of wzReader
+type wzWriter struct {
+	bytes.Buffer
+	key     *WzKey
+	strings map[string]int // offsets of the strings of the current img
+}
+
+func newWzWriter(key *WzKey) *wzWriter {
+	return &wzWriter{key: key, strings: map[string]int{}}
+}
+
+func (w *wzWriter) writeUint16(v uint16) {
+	w.Write(binary.LittleEndian.AppendUint16(nil, v))
+}
+
+func (w *wzWriter) writeUint32(v uint32) {
+	w.Write(binary.LittleEndian.AppendUint32(nil, v))
+}
+
+func (w *wzWriter) writeUint64(v uint64) {
+	w.Write(binary.LittleEndian.AppendUint64(nil, v))
+}
+
+// writeCompressedInt writes a wz compressed int, see readCompressedInt
+func (w *wzWriter) writeCompressedInt(v int32) {
+	if v > math.MinInt8 && v <= math.MaxInt8 {
+		w.WriteByte(byte(int8(v)))
+		return
+	}
+	w.WriteByte(0x80)
+	w.writeUint32(uint32(v))
+}
+
+// writeCompressedLong is the 64-bit version of writeCompressedInt
+func (w *wzWriter) writeCompressedLong(v int64) {
+	if v > math.MinInt8 && v <= math.MaxInt8 {
+		w.WriteByte(byte(int8(v)))
+		return
+	}
+	w.WriteByte(0x80)
+	w.writeUint64(uint64(v))
+}
+
+// writeCompressedFloat writes a wz compressed float, see
+// readCompressedFloat
+func (w *wzWriter) writeCompressedFloat(v float32) {
+	if math.Float32bits(v) == 0 {
+		w.WriteByte(0)
+		return
+	}
+	w.WriteByte(0x80)
+	w.writeUint32(math.Float32bits(v))
+}
+
+// writeString writes an encrypted wz string. Strings that are plain ascii
+// or not valid utf-8 are written as single byte strings, the others as
+// utf-16 strings.
+func (w *wzWriter) writeString(s string) {
+	if s == "" {
+		w.WriteByte(0)
+		return
+	}
+
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		ascii = ascii && s[i] < 0x80
+	}
+
+	if ascii || !utf8.ValidString(s) {
+		if len(s) < 128 {
+			w.WriteByte(byte(int8(-len(s))))
+		} else {
+			w.WriteByte(0x80)
+			w.writeUint32(uint32(len(s)))
+		}
+
+		keys := w.key.keystream(len(s))
+		mask := byte(0xAA)
+		for i := 0; i < len(s); i++ {
+			w.WriteByte(s[i] ^ mask ^ keys[i])
+			mask++
+		}
+		return
+	}
+
+	chars := utf16.Encode([]rune(s))
+	if len(chars) < 127 {
+		w.WriteByte(byte(len(chars)))
+	} else {
+		w.WriteByte(0x7F)
+		w.writeUint32(uint32(len(chars)))
+	}
+
+	keys := w.key.keystream(len(chars) * 2)
+	mask := uint16(0xAAAA)
+	for i, c := range chars {
+		w.writeUint16(c ^ mask ^ binary.LittleEndian.Uint16(keys[i*2:]))
+		mask++
+	}
+}
+
+// writeStringBlock writes a string inline the first time it's written in
+// the current img and as an offset to the first copy afterwards, see
+// readStringBlock
+func (w *wzWriter) writeStringBlock(s string, inline, ref byte) {
+	if off, ok := w.strings[s]; ok && len(s) > 4 {
+		w.WriteByte(ref)
+		w.writeUint32(uint32(off))
+		return
+	}
+	w.WriteByte(inline)
+	w.strings[s] = w.Len()
+	w.writeString(s)
+}
+
+// writeOffset encrypts and writes a directory entry offset, see readOffset.
+// pos is the absolute position the listing being written starts at.
+func (w *wzWriter) writeOffset(pos int, offset, fstart, hash uint32) {
+	mask := offsetMask(uint32(pos+w.Len()), fstart, hash)
+	w.writeUint32((offset - fstart*2) ^ mask)
+}
+
+// A WZWriter builds binary wz files that can be read by WZFile
+type WZWriter struct {
+	version int
+	key     *WzKey
+	format  int
+}
+
+// NewWZWriter initializes a writer for wz files of the given maple version
+// whose strings are encrypted with the given region key.
+// If key is nil, strings are not encrypted like with BMSKey.
+func NewWZWriter(version int, key *WzKey) *WZWriter {
+	if key == nil {
+		key = BMSKey
+	}
+	return &WZWriter{version: version, key: key}
+}
+
+// SetCanvasFormat sets the pixel format canvases are encoded in, see
+// EncodeCanvas. By default, canvases read from binary wz files keep their
+// format and compressed data and the other canvases are encoded as
+// FormatARGB8888. Zero restores the default.
+// Formats that can't be encoded, such as the DXT formats, give an
+// ErrCanvasFormat error and leave the current format unchanged.
+func (w *WZWriter) SetCanvasFormat(format int) error {
+	if format != 0 {
+		if err := checkCanvasFormat(format); err != nil {
+			return err
+		}
+	}
+	w.format = format
+	return nil
+}
+
+// wzLayout holds the data and positions of a wz file being written
+type wzLayout struct {
+	fstart  uint32
+	hash    uint32
+	dirs    []MapleDataDirectoryEntry // every directory in listing order
+	dirpos  map[MapleDataDirectoryEntry]int
+	imgs    map[MapleDataFileEntry][]byte
+	imgpos  map[MapleDataFileEntry]int
+	dirsize map[MapleDataDirectoryEntry]int32
+	dirsum  map[MapleDataDirectoryEntry]int32
+}
+
+// WriteProvider writes the directory at the given path of p and all of its
+// imgs as a wz file. An empty path writes the root directory.
+func (w *WZWriter) WriteProvider(out io.Writer, p MapleDataProvider,
+	path string) error {
+
+	dir := p.Root()
+	prefix := ""
+	if path != "" {
+		segments := strings.Split(path, "/")
+		if dir.GetEntry(segments[0]) == nil && segments[0] == dir.Name() {
+			segments = segments[1:] // wz file paths can start with its name
+		}
+
+		for _, segment := range segments {
+			subdir, ok := dir.GetEntry(segment).(MapleDataDirectoryEntry)
+			if !ok {
+				return notFoundError(path, nil)
+			}
+			dir = subdir
+		}
+		prefix = strings.Join(segments, "/")
+	}
+
+	return w.Write(out, dir, func(imgpath string) (MapleData, error) {
+		return p.Get(walkJoin(prefix, imgpath))
+	})
+}
+
+// Write writes a wz file whose root directory is dir. get is called to
+// load each img, with its path relative to dir.
+// The whole file is built in memory before it's written to out.
+func (w *WZWriter) Write(out io.Writer, dir MapleDataDirectoryEntry,
+	get func(path string) (MapleData, error)) error {
+
+	l := &wzLayout{
+		fstart:  uint32(4 + 8 + 4 + len(wzCopyright) + 1),
+		hash:    VersionHash(w.version),
+		dirpos:  map[MapleDataDirectoryEntry]int{},
+		imgs:    map[MapleDataFileEntry][]byte{},
+		imgpos:  map[MapleDataFileEntry]int{},
+		dirsize: map[MapleDataDirectoryEntry]int32{},
+		dirsum:  map[MapleDataDirectoryEntry]int32{},
+	}
+
+	if err := w.encodeDirectory(l, dir, "", get); err != nil {
+		return err
+	}
+
+	// listings have a fixed size regardless of the offsets they contain
+	pos := int(l.fstart) + 2
+	for _, d := range l.dirs {
+		l.dirpos[d] = pos
+		pos += w.listing(l, d, pos).Len()
+	}
+	for _, d := range l.dirs {
+		for _, f := range d.Files() {
+			l.imgpos[f] = pos
+			pos += len(l.imgs[f])
+		}
+	}
+
+	header := newWzWriter(w.key)
+	header.WriteString("PKG1")
+	header.writeUint64(uint64(pos) - uint64(l.fstart))
+	header.writeUint32(l.fstart)
+	header.WriteString(wzCopyright)
+	header.WriteByte(0)
+	header.writeUint16(EncryptVersion(l.hash))
+	if _, err := out.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	for _, d := range l.dirs {
+		listing := w.listing(l, d, l.dirpos[d])
+		if _, err := out.Write(listing.Bytes()); err != nil {
+			return err
+		}
+	}
+	for _, d := range l.dirs {
+		for _, f := range d.Files() {
+			if _, err := out.Write(l.imgs[f]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// encodeDirectory encodes the imgs of dir and its subdirectories and adds
+// them to the layout. The size and checksum of a directory are the sums of
+// the ones of its contents.
+func (w *WZWriter) encodeDirectory(l *wzLayout, dir MapleDataDirectoryEntry,
+	path string, get func(path string) (MapleData, error)) error {
+
+	l.dirs = append(l.dirs, dir)
+
+	for _, f := range dir.Files() {
+		imgpath := walkJoin(path, f.Name())
+		img, err := get(imgpath)
+		if err != nil {
+			return err
+		}
+
+		data, err := w.EncodeImg(img)
+		if err != nil {
+			return err
+		}
+
+		l.imgs[f] = data
+		l.dirsize[dir] += int32(len(data))
+		l.dirsum[dir] += imgChecksum(data)
+	}
+
+	for _, sub := range dir.Subdirectories() {
+		err := w.encodeDirectory(l, sub, walkJoin(path, sub.Name()), get)
+		if err != nil {
+			return err
+		}
+		l.dirsize[dir] += l.dirsize[sub]
+		l.dirsum[dir] += l.dirsum[sub]
+	}
+
+	return nil
+}
+
+// imgChecksum computes the checksum of an encoded img
+func imgChecksum(data []byte) (sum int32) {
+	for _, b := range data {
+		sum += int32(b)
+	}
+	return
+}
+
+// listing encodes the directory listing of dir which is stored at pos
+func (w *WZWriter) listing(l *wzLayout, dir MapleDataDirectoryEntry,
+	pos int) *wzWriter {
+
+	res := newWzWriter(w.key)
+	res.writeCompressedInt(int32(len(dir.Subdirectories()) +
+		len(dir.Files())))
+
+	for _, sub := range dir.Subdirectories() {
+		res.WriteByte(3)
+		res.writeString(sub.Name())
+		res.writeCompressedInt(l.dirsize[sub])
+		res.writeCompressedInt(l.dirsum[sub])
+		res.writeOffset(pos, uint32(l.dirpos[sub]), l.fstart, l.hash)
+	}
+
+	for _, f := range dir.Files() {
+		data := l.imgs[f]
+		res.WriteByte(4)
+		res.writeString(f.Name())
+		res.writeCompressedInt(int32(len(data)))
+		res.writeCompressedInt(imgChecksum(data))
+		res.writeOffset(pos, uint32(l.imgpos[f]), l.fstart, l.hash)
+	}
+
+	return res
+}
+
+// EncodeImg encodes the img d as it's stored in binary wz files
+func (w *WZWriter) EncodeImg(d MapleData) ([]byte, error) {
+	wr := newWzWriter(w.key)
+	wr.WriteByte(0x73)
+	wr.writeString("Property")
+	wr.writeUint16(0)
+	if err := w.properties(wr, d); err != nil {
+		return nil, err
+	}
+	return wr.Bytes(), nil
+}
+
+// properties writes the children of d as a property list
+func (w *WZWriter) properties(wr *wzWriter, d MapleData) error {
+	children := d.Children()
+	wr.writeCompressedInt(int32(len(children)))
+
+	for _, child := range children {
+		wr.writeStringBlock(child.Name(), 0x00, 0x01)
+		val := child.Get()
+		ok := true
+
+		switch child.Type() {
+		case IMG_0x00:
+			wr.WriteByte(0x00)
+
+		case SHORT:
+			var i int64
+			i, ok = toInt64(val)
+			ok = ok && i >= math.MinInt16 && i <= math.MaxInt16
+			wr.WriteByte(0x02)
+			wr.writeUint16(uint16(i))
+
+		case INT:
+			var i int64
+			i, ok = toInt64(val)
+			ok = ok && i >= math.MinInt32 && i <= math.MaxInt32
+			wr.WriteByte(0x03)
+			wr.writeCompressedInt(int32(i))
+
+		case LONG:
+			var i int64
+			i, ok = toInt64(val)
+			wr.WriteByte(0x14)
+			wr.writeCompressedLong(i)
+
+		case FLOAT:
+			var f float64
+			f, ok = toFloat64(val)
+			wr.WriteByte(0x04)
+			wr.writeCompressedFloat(float32(f))
+
+		case DOUBLE:
+			var f float64
+			f, ok = toFloat64(val)
+			wr.WriteByte(0x05)
+			wr.writeUint64(math.Float64bits(f))
+
+		case STRING:
+			var s string
+			s, ok = val.(string)
+			wr.WriteByte(0x08)
+			wr.writeStringBlock(s, 0x00, 0x01)
+
+		case PROPERTY, CANVAS, VECTOR, CONVEX, SOUND, UOL:
+			wr.WriteByte(0x09)
+			sizepos := wr.Len()
+			wr.writeUint32(0)
+			if err := w.extended(wr, child); err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint32(wr.Bytes()[sizepos:],
+				uint32(wr.Len()-sizepos-4))
+
+		default:
+			return fmt.Errorf("Cannot write %v data to a wz file at %s",
+				child.Type(), GetFullDataPath(child))
+		}
+
+		if !ok {
+			return dataValueError(child)
+		}
+	}
+
+	return nil
+}
+
+// extended writes an extended property such as a canvas or a vector
+func (w *WZWriter) extended(wr *wzWriter, d MapleData) error {
+	switch d.Type() {
+	case PROPERTY:
+		wr.writeStringBlock("Property", 0x73, 0x1B)
+		wr.writeUint16(0)
+		return w.properties(wr, d)
+
+	case CANVAS:
+		canvas, ok := d.Get().(MapleCanvas)
+		if !ok {
+			return dataValueError(d)
+		}
+		format, format2, data, err := w.encodeCanvas(d, canvas)
+		if err != nil {
+			return err
+		}
+
+		wr.writeStringBlock("Canvas", 0x73, 0x1B)
+		wr.WriteByte(0)
+		if len(d.Children()) > 0 {
+			wr.WriteByte(1)
+			wr.writeUint16(0)
+			if err = w.properties(wr, d); err != nil {
+				return err
+			}
+		} else {
+			wr.WriteByte(0)
+		}
+		wr.writeCompressedInt(int32(canvas.Width()))
+		wr.writeCompressedInt(int32(canvas.Height()))
+		wr.writeCompressedInt(int32(format))
+		wr.WriteByte(byte(format2))
+		wr.writeUint32(0)
+		wr.writeUint32(uint32(len(data) + 1))
+		wr.WriteByte(0)
+		wr.Write(data)
+
+	case VECTOR:
+		pt, ok := d.Get().(image.Point)
+		if !ok {
+			return dataValueError(d)
+		}
+		wr.writeStringBlock("Shape2D#Vector2D", 0x73, 0x1B)
+		wr.writeCompressedInt(int32(pt.X))
+		wr.writeCompressedInt(int32(pt.Y))
+
+	case CONVEX:
+		children := d.Children()
+		wr.writeStringBlock("Shape2D#Convex2D", 0x73, 0x1B)
+		wr.writeCompressedInt(int32(len(children)))
+		for _, child := range children {
+			if child.Type() != VECTOR {
+				return fmt.Errorf("%s: CONVEX data can only hold vectors",
+					GetFullDataPath(child))
+			}
+			if err := w.extended(wr, child); err != nil {
+				return err
+			}
+		}
+
+	case SOUND:
+		sound, ok := d.Get().(MapleSound)
+		if !ok {
+			return dataValueError(d)
+		}
+		header, payload, err := encodeSound(sound)
+		if err != nil {
+			return fmt.Errorf("%s: %v", GetFullDataPath(d), err)
+		}
+
+		wr.writeStringBlock("Sound_DX8", 0x73, 0x1B)
+		wr.WriteByte(0)
+		wr.writeCompressedInt(int32(len(payload)))
+		wr.writeCompressedInt(int32(sound.Duration().Milliseconds()))
+		wr.Write(header)
+		wr.Write(payload)
+
+	case UOL:
+		s, ok := d.Get().(string)
+		if !ok {
+			return dataValueError(d)
+		}
+		wr.writeStringBlock("UOL", 0x73, 0x1B)
+		wr.WriteByte(0)
+		wr.writeStringBlock(s, 0x00, 0x01)
+	}
+
+	return nil
+}
+
+// encodeCanvas returns the format and the compressed pixel data of a
+// canvas. Canvases that link to other canvases are written as transparent
+// because their own pixels are never used.
+// Compressed data stored in encrypted chunks is kept as it is when the
+// writer uses the same key and written as a plain zlib stream otherwise.
+func (w *WZWriter) encodeCanvas(d MapleData, canvas MapleCanvas) (
+	format, format2 int, data []byte, err error) {
+
+	if c, ok := canvas.(*PNGMapleCanvas); ok && w.format == 0 {
+		key := c.key
+		if key == nil {
+			key = BMSKey
+		}
+		data, err = c.CompressedData()
+		if err == nil && key.IV() != w.key.IV() {
+			data, err = decryptCanvas(data, key)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %v", GetFullDataPath(d), err)
+		}
+		return c.format, c.format2, data, err
+	}
+
+	format = w.format
+	if format == 0 {
+		format = FormatARGB8888
+	}
+
+	var img image.Image = image.NewNRGBA(image.Rect(0, 0, canvas.Width(),
+		canvas.Height()))
+	if !hasCanvasLink(d) {
+		loaded, err := LoadCanvas(canvas)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("%s: %v", GetFullDataPath(d), err)
+		}
+		img = *loaded
+	}
+
+	data, err = EncodeCanvas(canvas.Width(), canvas.Height(), format, img)
+	if err != nil {
+		err = fmt.Errorf("%s: %v", GetFullDataPath(d), err)
+	}
+	return format, 0, data, err
+}
+
+// encodeSound returns the media type header and the payload of a sound.
+// Headers of sounds read from binary wz files are kept unless they're
+// encrypted.
+func encodeSound(sound MapleSound) (header, payload []byte, err error) {
+	r, err := sound.Reader()
+	if err == nil {
+		payload, err = io.ReadAll(r)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s, ok := sound.(*ImgMapleSound); ok {
+		plain := len(s.header) <= soundHeaderSize+1 ||
+			parseSoundFormat(s.header[soundHeaderSize+1:]) != nil
+		if plain || s.format == nil {
+			return s.header, payload, nil
+		}
+	}
+
+	format := sound.Format()
+	if format == nil {
+		return nil, nil, fmt.Errorf("Sound has no format header")
+	}
+
+	wav := format.Bytes()
+	header = make([]byte, 0, soundHeaderSize+1+len(wav))
+	header = append(header, soundMediaType[:]...)
+	header = append(header, byte(len(wav)))
+	return append(header, wav...), payload, nil
+}
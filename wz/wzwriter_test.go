@@ -0,0 +1,377 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTestWzBytes parses a v83 wz file stored in memory
+func readTestWzBytes(t *testing.T, data []byte) *WZFile {
+	f, err := NewWZFileFromReader(bytes.NewReader(data), int64(len(data)),
+		"Mob.wz", 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// compareTestWz checks that two wz files hold the same imgs
+func compareTestWz(t *testing.T, a, b MapleDataProvider) {
+	for _, path := range testImgPaths(a.Root(), "") {
+		da, err := a.Get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := b.Get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareTestData(t, path, da, db)
+	}
+}
+
+func TestWZWriter(t *testing.T) {
+	payload := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tree := testWzTree()
+	info := &tree.imgs[0].props[0]
+	info.children = append(info.children,
+		testProp{name: "exp", kind: "long", value: int64(0x123456789A)},
+		testProp{name: "level", kind: "long", value: int64(-7)})
+	stand := &tree.imgs[0].props[1]
+	encrypted := testEncryptChunks(testDeflate([]byte{
+		0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x80,
+	}), GMSKey, 5)
+	stand.children = append(stand.children, testProp{name: "2",
+		kind: "canvas", value: testCanvas{width: 2, height: 1, format: 2,
+			data: encrypted}})
+	tree.imgs = append(tree.imgs, testImg{
+		name: "Bgm00.img",
+		props: []testProp{
+			{name: "FloralLife", kind: "sound", value: testSound{
+				duration: 1500,
+				header:   testSoundHeader(testPCMFormat, nil),
+				data:     payload,
+			}},
+			{name: "Encrypted", kind: "sound", value: testSound{
+				duration: 20,
+				header:   testSoundHeader(testPCMFormat, GMSKey),
+				data:     payload[:4],
+			}},
+		},
+	})
+	orig := readTestWzBytes(t, encodeTestWz(tree, 83, GMSKey))
+
+	var first bytes.Buffer
+	err := NewWZWriter(83, GMSKey).WriteProvider(&first, orig, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := readTestWzBytes(t, first.Bytes())
+	if f.Key() != GMSKey {
+		t.Error("the written wz file is not encrypted with the gms key")
+	}
+	compareTestWz(t, orig, f)
+
+	entry := f.Root().Files()[0]
+	data := first.Bytes()[entry.Offset() : entry.Offset()+entry.Size()]
+	if imgChecksum(data) != int32(entry.Checksum()) {
+		t.Errorf("%s: wrong checksum", entry.Name())
+	}
+
+	img, err := f.Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas := GetImage(img.ChildByPath("stand/0"))
+	if canvas == nil {
+		t.Fatal("stand/0: failed to decode the canvas")
+	}
+	testCanvasPixels(t, "stand/0", *canvas, map[image.Point]color.NRGBA{
+		{0, 0}: {0xFF, 0x00, 0x00, 0xFF},
+		{1, 0}: {0x00, 0x00, 0xFF, 0x80},
+	})
+	if v := img.ChildByPath("info/exp").Get(); v != int64(0x123456789A) {
+		t.Errorf("info/exp: unexpected long %#v", v)
+	}
+
+	// encrypted chunks are kept as they are
+	data, err = img.ChildByPath("stand/2").Get().(*PNGMapleCanvas).
+		CompressedData()
+	if err != nil || !bytes.Equal(data, encrypted) {
+		t.Errorf("stand/2: encrypted canvas data changed %x (%v)", data, err)
+	}
+
+	bgm, err := f.Get("Bgm00.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestSound(t, "FloralLife", GetSound(bgm.ChildByPath("FloralLife")),
+		1500*time.Millisecond, testPCMFormat, payload)
+	checkTestSound(t, "Encrypted", GetSound(bgm.ChildByPath("Encrypted")),
+		20*time.Millisecond, testPCMFormat, payload[:4])
+
+	var second bytes.Buffer
+	if err = NewWZWriter(83, GMSKey).WriteProvider(&second, f, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("writing the wz file again gives different data")
+	}
+
+	// encrypted chunks are decrypted for a different key
+	var sea bytes.Buffer
+	if err = NewWZWriter(83, SEAKey).WriteProvider(&sea, f, ""); err != nil {
+		t.Fatal(err)
+	}
+	img, err = readTestWzBytes(t, sea.Bytes()).Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas2 := img.ChildByPath("stand/2").Get().(*PNGMapleCanvas)
+	if data, err = canvas2.CompressedData(); err != nil || !isZlibHeader(data) {
+		t.Errorf("stand/2: expected plain zlib data, got %x (%v)", data, err)
+	}
+	decoded, err := canvas2.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCanvasPixels(t, "stand/2", *decoded, map[image.Point]color.NRGBA{
+		{0, 0}: {0xFF, 0x00, 0x00, 0xFF},
+		{1, 0}: {0x00, 0x00, 0xFF, 0x80},
+	})
+
+	// a subdirectory as a wz file of its own
+	var sub bytes.Buffer
+	err = NewWZWriter(83, GMSKey).WriteProvider(&sub, f, "Mob.wz/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = readTestWzBytes(t, sub.Bytes()).Get("9999999.img")
+	if err != nil || GetStringD(img.ChildByPath("name"), "") != "スライム" {
+		t.Errorf("sub/9999999.img: %v", err)
+	}
+}
+
+// testCanvasImage returns an image with a different color in every pixel
+func testCanvasImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 13), uint8(y * 7),
+				uint8(x * y), uint8(0xFF - x*y)})
+		}
+	}
+	return img
+}
+
+// writeTestMemWz writes a wz file that holds img as x.img
+func writeTestMemWz(t *testing.T, w *WZWriter, img MapleData) []byte {
+	root := NewDirectoryEntry("Mob.wz", 0, 0, nil)
+	root.AddFile(NewFileEntry("x.img", 0, 0, root))
+
+	var b bytes.Buffer
+	err := w.Write(&b, root, func(path string) (MapleData, error) {
+		if path != "x.img" {
+			t.Errorf("unexpected img path %s", path)
+		}
+		return img, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestWZWriterCanvasFormat(t *testing.T) {
+	src := testCanvasImage(20, 18)
+	data, err := EncodeCanvas(20, 18, FormatARGB8888, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := NewMemProperty("x.img")
+	_, err = img.SetChildByPath("c", CANVAS,
+		NewPNGMapleCanvas(20, 18, FormatARGB8888, 0, data, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := []int{FormatARGB4444, FormatARGB8888, FormatRGB565,
+		FormatRGB565Block}
+	for _, format := range formats {
+		w := NewWZWriter(83, nil)
+		if err = w.SetCanvasFormat(format); err != nil {
+			t.Fatal(err)
+		}
+		first := writeTestMemWz(t, w, img)
+
+		read, err := readTestWzBytes(t, first).Get("x.img")
+		if err != nil {
+			t.Fatal(err)
+		}
+		canvas := read.ChildByPath("c").Get().(*PNGMapleCanvas)
+		if canvas.Format() != format {
+			t.Errorf("format %d: canvas has format %d", format, canvas.Format())
+		}
+
+		decoded, err := canvas.Load()
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if format == FormatARGB8888 {
+			testCanvasPixels(t, "c", *decoded, map[image.Point]color.NRGBA{
+				{0, 0}:  src.NRGBAAt(0, 0),
+				{19, 5}: src.NRGBAAt(19, 5),
+				{7, 17}: src.NRGBAAt(7, 17),
+			})
+		}
+
+		// the data of the canvas is re-encoded, which must not change it
+		if second := writeTestMemWz(t, w, read); !bytes.Equal(first, second) {
+			t.Errorf("format %d: writing the canvas again changes it", format)
+		}
+	}
+
+	// dxt canvases can only be decoded
+	w := NewWZWriter(83, nil)
+	w.SetCanvasFormat(FormatRGB565)
+	if err = w.SetCanvasFormat(FormatDXT3); !errors.Is(err, ErrCanvasFormat) {
+		t.Errorf("SetCanvasFormat(FormatDXT3): unexpected error %v", err)
+	}
+	read, err := readTestWzBytes(t, writeTestMemWz(t, w, img)).Get("x.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas := read.ChildByPath("c").Get().(*PNGMapleCanvas)
+	if canvas.Format() != FormatRGB565 {
+		t.Errorf("the canvas format changed to %d", canvas.Format())
+	}
+	_, err = EncodeCanvas(20, 18, FormatDXT5, src)
+	if !errors.Is(err, ErrCanvasFormat) {
+		t.Errorf("EncodeCanvas(FormatDXT5): unexpected error %v", err)
+	}
+}
+
+func TestLongRoundTrip(t *testing.T) {
+	const exp = int64(0x123456789A)
+	tree := &testDir{name: "Mob.wz", imgs: []testImg{{
+		name: "x.img",
+		props: []testProp{
+			{name: "exp", kind: "long", value: exp},
+			{name: "small", kind: "long", value: int64(-7)},
+		},
+	}}}
+
+	// binary to xml
+	img, err := readTestWzBytes(t, encodeTestWz(tree, 83, GMSKey)).Get("x.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err = WriteXmlImg(&b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `<long name="exp" value="78187493530"/>`) {
+		t.Errorf("unexpected xml for a long property:\n%s", b.String())
+	}
+
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/x.img.xml": b.String(),
+	})
+	x, err := NewXml(filepath.Join(dir, "Mob.wz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dom, err := x.Get("x.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := dom.ChildByPath("exp"); d.Type() != LONG || d.Get() != exp {
+		t.Errorf("NewXml: exp is %v(%#v)", d.Type(), d.Get())
+	}
+
+	// xml to memory data to binary
+	parsed, err := ParseXmlImg(&b, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemMapleDataFrom(parsed)
+	_, err = mem.SetChildByPath("max", LONG, int64(math.MaxInt64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := writeTestMemWz(t, NewWZWriter(83, GMSKey), mem)
+	img, err = readTestWzBytes(t, data).Get("x.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareTestData(t, "x.img", mem, img)
+
+	if v, err := Value[int64](img, "exp"); err != nil || v != exp {
+		t.Errorf("Value[int64] = %v, %v", v, err)
+	}
+	if v, err := Value[string](img, "small"); err != nil || v != "-7" {
+		t.Errorf("Value[string] = %q, %v", v, err)
+	}
+	if _, err = Value[int32](img, "exp"); err == nil {
+		t.Error("Value[int32] of a long that doesn't fit: expected an error")
+	}
+}
+
+func TestWZWriterXml(t *testing.T) {
+	x, err := NewXmlTreeFS(testXmlFS(t), "Data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = NewWZWriter(83, SEAKey).WriteProvider(&b, x, "Mob.wz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := readTestWzBytes(t, b.Bytes())
+	if f.Key() != SEAKey {
+		t.Error("the written wz file is not encrypted with the sea key")
+	}
+	img, err := f.Get("0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"stand/0", "stand/1"} {
+		canvas := GetImage(img.ChildByPath(name))
+		if canvas == nil {
+			t.Errorf("%s: failed to load the canvas", name)
+			continue
+		}
+		testCanvasPixels(t, name, *canvas, map[image.Point]color.NRGBA{
+			{1, 0}: {0xFF, 0x00, 0x00, 0xFF},
+		})
+	}
+	if _, err = f.Get("Sub/0100101.img"); err != nil {
+		t.Errorf("Sub/0100101.img: %v", err)
+	}
+}
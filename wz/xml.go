@@ -27,10 +27,13 @@ import (
 
 const xmldebug = false
 
-// wz.Xml provides access to the data in a wz xml directory tree
+// wz.Xml provides access to the data in a wz xml directory tree through
+// go-pkg-xmlx. Values are parsed from the dom on every Get, see wz.XmlTree
+// for a faster alternative.
 type Xml struct {
 	root              string
 	rootForNavigation *DirectoryEntry
+	canvasLinks
 }
 
 // NewXml walks the given root directory and creates a new wx.Xml object
@@ -41,6 +44,7 @@ func NewXml(sourcedirpath string) (*Xml, error) {
 		rootForNavigation: NewDirectoryEntry(
 			filepath.Base(sourcedirpath), 0, 0, nil),
 	}
+	x.owner = x
 
 	// walk sourcedir and fill all data entities
 	err := fillMapleDataEntities(x.root, x.rootForNavigation)
@@ -106,13 +110,20 @@ func fillMapleDataEntities(lrootpath string, wzdir *DirectoryEntry) (
 // Get returns the wz data at the given path
 func (x *Xml) Get(path string) (res MapleData, err error) {
 	dataFile, err := os.Open(filepath.Join(x.root, path+".xml"))
+	if os.IsNotExist(err) {
+		return nil, notFoundError(path, err)
+	}
 	if err != nil {
 		return
 	}
 	defer dataFile.Close()
 
-	res, err = NewXMLDomMapleData(dataFile, path)
-	return
+	data, err := NewXMLDomMapleData(dataFile, path)
+	if err != nil {
+		return nil, err
+	}
+	data.provider = x
+	return data, nil
 }
 
 // Root returns the root directory entry of the xml file
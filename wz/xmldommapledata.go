@@ -18,11 +18,12 @@ package wz
 // 90% of this package is ported directly from OdinMS, so credits to them
 
 import (
-	"errors"
 	"image"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 import "github.com/jteeuwen/go-pkg-xmlx"
@@ -33,27 +34,55 @@ import "github.com/jteeuwen/go-pkg-xmlx"
 type XMLDomMapleData struct {
 	node         *xmlx.Node
 	imageDataDir string
+	provider     *Xml          // provider that loaded this node, if any
+	canvases     *xmlCanvasMap // canvases of the img this node belongs to
+}
+
+// An xmlCanvasMap holds the canvases created for the nodes of an img, so
+// that every Get of a canvas node returns the same canvas and its image is
+// only decoded once
+type xmlCanvasMap struct {
+	mutex    sync.Mutex
+	canvases map[*xmlx.Node]*FileStoredPngMapleCanvas
 }
 
 // NewXMLDomMapleData parses the given xml file into a tree and returns the
-// first node
+// first node. Malformed files are reported as *ImgError of kind
+// ErrMalformedImg.
 func NewXMLDomMapleData(file *os.File, path string) (
 	res *XMLDomMapleData, err error) {
 
 	doc := xmlx.New()
-	err = doc.LoadStream(file, nil)
+	if err = doc.LoadStream(file, nil); err != nil {
+		return nil, malformedImgError(path, err)
+	}
 
-	res = &XMLDomMapleData{
-		node:         doc.Root.Children[1],
-		imageDataDir: path,
+	// the root element is the first element after the xml declaration
+	if doc.Root != nil {
+		for _, node := range doc.Root.Children {
+			if node.Type == xmlx.NT_ELEMENT {
+				res = &XMLDomMapleData{
+					node:         node,
+					imageDataDir: path,
+					canvases: &xmlCanvasMap{
+						canvases: map[*xmlx.Node]*FileStoredPngMapleCanvas{},
+					},
+				}
+				return
+			}
+		}
 	}
-	return
+
+	return nil, &ImgError{Kind: ErrMalformedImg, Path: path,
+		Msg: "no root element"}
 }
 
 // fromNode is internally used to wrap child nodes as XMLDomMapleData objects
-func fromNode(node *xmlx.Node) *XMLDomMapleData {
+func (x *XMLDomMapleData) fromNode(node *xmlx.Node) *XMLDomMapleData {
 	return &XMLDomMapleData{
-		node: node,
+		node:     node,
+		provider: x.provider,
+		canvases: x.canvases,
 	}
 }
 
@@ -61,13 +90,14 @@ func fromNode(node *xmlx.Node) *XMLDomMapleData {
 func (x *XMLDomMapleData) ChildByPath(path string) MapleData {
 	segments := strings.Split(path, "/")
 	if segments[0] == ".." {
-		par := x.Parent()
-		res, ok := par.(MapleData)
-		if !ok {
-			panic(errors.New(
-				"XMLDomMapleData.ChildByPath: par failed type assertion"))
+		// the img root has no parent to walk to
+		res, ok := x.Parent().(MapleData)
+		if !ok || res == nil {
 			return nil
 		}
+		if len(segments) == 1 {
+			return res
+		}
 		return res.ChildByPath(path[strings.Index(path, "/")+1:])
 	}
 
@@ -101,7 +131,7 @@ func (x *XMLDomMapleData) ChildByPath(path string) MapleData {
 	}
 
 	// return the desired node
-	res := fromNode(mynode)
+	res := x.fromNode(mynode)
 	res.imageDataDir = newdatadir
 	// imageDataDir now holds the correct path for the png file
 
@@ -118,8 +148,8 @@ func (x *XMLDomMapleData) Children() []MapleData {
 			continue
 		}
 
-		child := fromNode(childNode)
-		child.imageDataDir = filepath.Join(x.imageDataDir, x.Name())
+		child := x.fromNode(childNode)
+		child.imageDataDir = filepath.Join(x.imageDataDir, child.Name())
 		res = append(res, child)
 	}
 
@@ -128,8 +158,9 @@ func (x *XMLDomMapleData) Children() []MapleData {
 
 // Get returns the value of this node as an interface.
 // If the value is invalid or absent, the return value is nil.
-// All the possible types returned by Get are float64, float32, int32, int16,
-// string, image.Point and FileStoredPngMapleCanvas.
+// All the possible types returned by Get are float64, float32, int64, int32,
+// int16, string, image.Point, Convex, FileStoredPngMapleCanvas and
+// FileStoredMapleSound.
 func (x *XMLDomMapleData) Get() interface{} {
 	datatype := x.Type()
 
@@ -138,6 +169,8 @@ func (x *XMLDomMapleData) Get() interface{} {
 		return x.node.Af64("", "value")
 	case FLOAT:
 		return x.node.Af32("", "value")
+	case LONG:
+		return x.node.Ai64("", "value")
 	case INT:
 		return x.node.Ai32("", "value")
 	case SHORT:
@@ -150,16 +183,60 @@ func (x *XMLDomMapleData) Get() interface{} {
 		vy := x.node.Ai("", "y")
 		return image.Pt(vx, vy)
 
+	case CONVEX:
+		return convexFromChildren(x)
+
 	case CANVAS:
-		w := x.node.Ai("", "width")
-		h := x.node.Ai("", "height")
-		return NewFileStoredPngMapleCanvas(w, h,
-			x.imageDataDir+".png")
+		return x.canvas()
+
+	case SOUND:
+		duration := time.Duration(x.node.Ai("", "length")) * time.Millisecond
+		return NewFileStoredMapleSound(x.sidecarPath(), duration)
 	}
 
 	return nil
 }
 
+// canvas returns the canvas of this node, creating it the first time it's
+// requested
+func (x *XMLDomMapleData) canvas() *FileStoredPngMapleCanvas {
+	if x.canvases != nil {
+		x.canvases.mutex.Lock()
+		defer x.canvases.mutex.Unlock()
+		if canvas, ok := x.canvases.canvases[x.node]; ok {
+			return canvas
+		}
+	}
+
+	w := x.node.Ai("", "width")
+	h := x.node.Ai("", "height")
+	canvas := NewFileStoredPngMapleCanvas(w, h, x.sidecarPath()+".png")
+	if hasCanvasLink(x) {
+		canvas.linked = x
+	}
+	if x.canvases != nil {
+		x.canvases.canvases[x.node] = canvas
+	}
+	return canvas
+}
+
+// sidecarPath returns the path of the files that hold the data of canvases
+// and sounds without extension
+func (x *XMLDomMapleData) sidecarPath() string {
+	if x.provider == nil {
+		return x.imageDataDir
+	}
+	return filepath.Join(x.provider.root, x.imageDataDir)
+}
+
+// dataProvider returns the provider canvas links are resolved through
+func (x *XMLDomMapleData) dataProvider() MapleDataProvider {
+	if x.provider == nil {
+		return nil
+	}
+	return x.provider.LinkProvider()
+}
+
 // Type returns the maple data type of this node.
 // See MapleDataType for more information.
 func (x *XMLDomMapleData) Type() MapleDataType {
@@ -180,6 +257,8 @@ func (x *XMLDomMapleData) Type() MapleDataType {
 		return DOUBLE
 	case "float":
 		return FLOAT
+	case "long":
+		return LONG
 	case "int":
 		return INT
 	case "short":
@@ -200,12 +279,12 @@ func (x *XMLDomMapleData) Type() MapleDataType {
 // Parent returns the parent node of this wz xml entry
 func (x *XMLDomMapleData) Parent() MapleDataEntity {
 	parentNode := x.node.Parent
-	if parentNode.Type == xmlx.NT_ROOT {
+	if parentNode == nil || parentNode.Type == xmlx.NT_ROOT {
 		return nil
 	}
 
-	parentData := fromNode(parentNode)
-	parentData.imageDataDir = x.imageDataDir[0:strings.LastIndex(x.imageDataDir, "/")]
+	parentData := x.fromNode(parentNode)
+	parentData.imageDataDir = filepath.Dir(x.imageDataDir)
 	return parentData
 }
 
@@ -0,0 +1,474 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wz.XmlTree provides access to the data in a wz xml directory tree stored
+// on disk or in any fs.FS, such as an embed.FS or a zip archive.
+// Unlike wz.Xml, it streams each img through encoding/xml and parses all of
+// its values once into a tree of WZIMGEntry objects, so Get on the returned
+// nodes doesn't reparse anything.
+type XmlTree struct {
+	fsys              fs.FS
+	closer            io.Closer
+	rootForNavigation *DirectoryEntry
+	canvasLinks
+}
+
+// NewXmlTree walks the given root directory and creates a new wz.XmlTree
+// object that will provide access to the wz xml data
+func NewXmlTree(sourcedirpath string) (*XmlTree, error) {
+	fi, err := os.Stat(sourcedirpath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.New(
+			"The root directory must be a directory, not a file!")
+	}
+
+	return newXmlTree(os.DirFS(sourcedirpath), filepath.Base(sourcedirpath))
+}
+
+// NewXmlTreeFS creates a new wz.XmlTree object that provides access to the
+// wz xml data in the root directory of fsys. Use fs.Sub to open a
+// subdirectory.
+// name is the name of the root directory entry, like the base name of the
+// directory NewXmlTree would be given.
+// The png and sound files of canvases and sounds are also read from fsys.
+func NewXmlTreeFS(fsys fs.FS, name string) (*XmlTree, error) {
+	return newXmlTree(fsys, name)
+}
+
+// OpenXmlTreeZip opens the zip archive at the given path and creates a new
+// wz.XmlTree object that provides access to the wz xml data in its root
+// directory. The archive stays open until Close is called.
+func OpenXmlTreeZip(path string) (*XmlTree, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := newXmlTree(r, filepath.Base(path))
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	x.closer = r
+	return x, nil
+}
+
+func newXmlTree(fsys fs.FS, name string) (*XmlTree, error) {
+	x := &XmlTree{
+		fsys:              fsys,
+		rootForNavigation: NewDirectoryEntry(name, 0, 0, nil),
+	}
+	x.owner = x
+
+	err := fillMapleDataEntitiesFS(fsys, ".", x.rootForNavigation)
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// fillMapleDataEntitiesFS is a recursive function that walks a directory of
+// xml wz files in fsys and caches everything into the wzdir object
+func fillMapleDataEntitiesFS(fsys fs.FS, dir string, wzdir *DirectoryEntry,
+) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir() && !strings.HasSuffix(name, ".img"):
+			newdir := NewDirectoryEntry(name, 0, 0, wzdir)
+			wzdir.AddDirectory(newdir)
+
+			err = fillMapleDataEntitiesFS(fsys, path.Join(dir, name), newdir)
+			if err != nil {
+				return err
+			}
+
+		case strings.HasSuffix(name, ".xml"):
+			wzdir.AddFile(NewFileEntry(name[0:len(name)-4], 0, 0, wzdir))
+		}
+	}
+
+	return nil
+}
+
+// Close closes the zip archive opened by OpenXmlTreeZip. It does nothing
+// for other trees.
+func (x *XmlTree) Close() error {
+	if x.closer == nil {
+		return nil
+	}
+	return x.closer.Close()
+}
+
+// openFile opens name from fsys, or from the os file system if fsys is nil
+func openFile(fsys fs.FS, name string) (fs.File, error) {
+	if fsys == nil {
+		return os.Open(name)
+	}
+	return fsys.Open(name)
+}
+
+// readFile reads name from fsys, or from the os file system if fsys is nil
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(name)
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// Get parses and returns the img at the given path
+func (x *XmlTree) Get(path string) (MapleData, error) {
+	img, errs := x.parse(path, false)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	img.provider = x.LinkProvider()
+	return img, nil
+}
+
+// parse opens and parses the img at the given path. In validate mode, it
+// keeps parsing after problems that don't prevent reading the rest of the
+// img and returns all of them.
+func (x *XmlTree) parse(path string, validate bool) (*WZIMGEntry, []error) {
+	name := strings.TrimPrefix(filepath.ToSlash(path), "/")
+	file, err := x.fsys.Open(name + ".xml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, []error{notFoundError(path, err)}
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer file.Close()
+
+	img, errs := parseXmlImg(file, x.fsys, name, validate)
+	for i, err := range errs {
+		errs[i] = malformedImgError(path, err)
+	}
+	return img, errs
+}
+
+// Validate parses every img in the tree and reports all the problems it
+// finds at once as a *ValidationError. This includes malformed values,
+// unknown elements and duplicate names, which Get doesn't check.
+func (x *XmlTree) Validate() error {
+	var errs []error
+
+	var walk func(dir MapleDataDirectoryEntry, prefix string)
+	walk = func(dir MapleDataDirectoryEntry, prefix string) {
+		for _, f := range dir.Files() {
+			_, imgErrs := x.parse(prefix+f.Name(), true)
+			errs = append(errs, imgErrs...)
+		}
+		for _, sub := range dir.Subdirectories() {
+			walk(sub, prefix+sub.Name()+"/")
+		}
+	}
+	walk(x.rootForNavigation, "")
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// Root returns the root directory entry of the xml tree
+func (x *XmlTree) Root() MapleDataDirectoryEntry { return x.rootForNavigation }
+
+// xmlTypes maps wz xml element names to maple data types
+var xmlTypes = map[string]MapleDataType{
+	"imgdir": PROPERTY,
+	"canvas": CANVAS,
+	"convex": CONVEX,
+	"sound":  SOUND,
+	"uol":    UOL,
+	"double": DOUBLE,
+	"float":  FLOAT,
+	"long":   LONG,
+	"int":    INT,
+	"short":  SHORT,
+	"string": STRING,
+	"vector": VECTOR,
+	"null":   IMG_0x00,
+}
+
+// xmlImgParser holds the state of ParseXmlImg
+type xmlImgParser struct {
+	d        *xml.Decoder
+	fsys     fs.FS    // file system that holds datadir, nil for the os one
+	datadir  string   // directory that holds the png and sound files
+	names    []string // names of the open elements below the root
+	errs     []error  // problems found in validate mode
+	validate bool
+}
+
+// ParseXmlImg parses a wz xml img from r into a tree of WZIMGEntry objects.
+// datadir is the path of the img without the .xml extension, which is
+// where the png and sound files of its canvases and sounds are stored.
+// Absent numeric attributes are read as zero, malformed ones are an error.
+// Errors are reported as *ImgError of kind ErrMalformedImg without a path.
+func ParseXmlImg(r io.Reader, datadir string) (*WZIMGEntry, error) {
+	img, errs := parseXmlImg(r, nil, datadir, false)
+	if len(errs) > 0 {
+		return nil, malformedImgError("", errs[0])
+	}
+	return img, nil
+}
+
+// parseXmlImg parses a wz xml img. In validate mode, it keeps going after
+// problems that don't prevent parsing the rest of the img.
+func parseXmlImg(r io.Reader, fsys fs.FS, datadir string, validate bool) (
+	*WZIMGEntry, []error) {
+
+	p := &xmlImgParser{d: xml.NewDecoder(r), fsys: fsys, datadir: datadir,
+		validate: validate}
+
+	var root *WZIMGEntry
+	var stack []*WZIMGEntry
+	var tags []string // element names of stack
+
+	for {
+		// RawToken skips the namespace bookkeeping, tags are matched below
+		tok, err := p.d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, append(p.errs, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil && len(stack) == 0 {
+				return nil, append(p.errs,
+					p.errorf("more than one root element"))
+			}
+
+			e, err := p.element(t, len(stack) == 0)
+			if err != nil {
+				return nil, append(p.errs, err)
+			}
+
+			if len(stack) == 0 {
+				root = e
+			} else {
+				parent := stack[len(stack)-1]
+				if p.validate && parent.child(e.name) != nil {
+					p.problem(p.errorf("duplicate name %q", e.name))
+				}
+				parent.addChild(e)
+			}
+			stack = append(stack, e)
+			tags = append(tags, t.Name.Local)
+
+		case xml.EndElement:
+			if len(stack) == 0 || t.Name.Local != tags[len(tags)-1] {
+				return nil, append(p.errs,
+					p.errorf("unexpected </%s>", t.Name.Local))
+			}
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			tags = tags[:len(tags)-1]
+			if len(stack) > 0 {
+				p.names = p.names[:len(p.names)-1]
+			}
+
+			if canvas, ok := e.data.(*FileStoredPngMapleCanvas); ok &&
+				hasCanvasLink(e) {
+
+				canvas.linked = e
+			}
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, append(p.errs, p.errorf("unexpected end of file"))
+	}
+	if root == nil {
+		return nil, append(p.errs, p.errorf("no root element"))
+	}
+
+	return root, p.errs
+}
+
+// errorf formats an error that reports the current position in the xml
+func (p *xmlImgParser) errorf(format string, args ...interface{}) error {
+	line, col := p.d.InputPos()
+	return &ImgError{
+		Kind:   ErrMalformedImg,
+		Line:   line,
+		Column: col,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// problem returns err, or records it and returns nil in validate mode
+func (p *xmlImgParser) problem(err error) error {
+	if !p.validate {
+		return err
+	}
+	p.errs = append(p.errs, err)
+	return nil
+}
+
+// attr returns the value of the given attribute or an empty string
+func attr(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// intAttr parses the given attribute as an integer of the given bit size
+func (p *xmlImgParser) intAttr(t xml.StartElement, name string, bits int) (
+	int64, error) {
+
+	s := attr(t, name)
+	if s == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		return 0, p.errorf("<%s name=%q>: invalid %s %q", t.Name.Local,
+			attr(t, "name"), name, s)
+	}
+	return v, nil
+}
+
+// floatAttr parses the given attribute as a float of the given bit size
+func (p *xmlImgParser) floatAttr(t xml.StartElement, name string, bits int) (
+	float64, error) {
+
+	s := attr(t, name)
+	if s == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseFloat(s, bits)
+	if err != nil {
+		return 0, p.errorf("<%s name=%q>: invalid %s %q", t.Name.Local,
+			attr(t, "name"), name, s)
+	}
+	return v, nil
+}
+
+// element creates the node for an xml element and parses its value
+func (p *xmlImgParser) element(t xml.StartElement, isRoot bool) (
+	*WZIMGEntry, error) {
+
+	datatype, ok := xmlTypes[t.Name.Local]
+	if !ok {
+		datatype = INVALID
+		if p.validate {
+			p.problem(p.errorf("unknown element <%s>", t.Name.Local))
+		}
+	}
+
+	e := NewWZIMGEntry(attr(t, "name"), datatype, nil)
+	if !isRoot {
+		p.names = append(p.names, e.name)
+	}
+
+	var err error
+	var i, j int64
+	var f float64
+
+	switch datatype {
+	case DOUBLE:
+		f, err = p.floatAttr(t, "value", 64)
+		e.data = f
+	case FLOAT:
+		f, err = p.floatAttr(t, "value", 32)
+		e.data = float32(f)
+	case LONG:
+		i, err = p.intAttr(t, "value", 64)
+		e.data = i
+	case INT:
+		i, err = p.intAttr(t, "value", 32)
+		e.data = int32(i)
+	case SHORT:
+		i, err = p.intAttr(t, "value", 16)
+		e.data = int16(i)
+	case STRING, UOL:
+		e.data = attr(t, "value")
+
+	case VECTOR:
+		if i, err = p.intAttr(t, "x", 32); err == nil {
+			j, err = p.intAttr(t, "y", 32)
+		}
+		e.data = image.Pt(int(i), int(j))
+
+	case CANVAS:
+		if i, err = p.intAttr(t, "width", 32); err == nil {
+			j, err = p.intAttr(t, "height", 32)
+		}
+		canvas := NewFileStoredPngMapleCanvas(int(i), int(j),
+			p.dataPath()+".png")
+		canvas.fsys = p.fsys
+		e.data = canvas
+
+	case SOUND:
+		i, err = p.intAttr(t, "length", 32)
+		sound := NewFileStoredMapleSound(p.dataPath(),
+			time.Duration(i)*time.Millisecond)
+		sound.fsys = p.fsys
+		e.data = sound
+	}
+
+	if err != nil {
+		if err = p.problem(err); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// dataPath returns the path of the files that hold the data of the current
+// element without extension
+func (p *xmlImgParser) dataPath() string {
+	if p.fsys != nil {
+		return path.Join(append([]string{p.datadir}, p.names...)...)
+	}
+	return filepath.Join(append([]string{p.datadir}, p.names...)...)
+}
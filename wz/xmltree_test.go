@@ -0,0 +1,436 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testfilesPath() string {
+	return filepath.Join(os.Getenv("GOPATH"), "src", "github.com",
+		"Francesco149", "maplelib", "wz", "testfiles")
+}
+
+// testImgPaths lists the paths of all the imgs in dir recursively
+func testImgPaths(dir MapleDataDirectoryEntry, prefix string) (res []string) {
+	for _, f := range dir.Files() {
+		res = append(res, prefix+f.Name())
+	}
+	for _, sub := range dir.Subdirectories() {
+		res = append(res, testImgPaths(sub, prefix+sub.Name()+"/")...)
+	}
+	return
+}
+
+// compareTestData checks that two maple data trees hold the same values
+func compareTestData(t *testing.T, path string, a, b MapleData) {
+	if a.Name() != b.Name() || a.Type() != b.Type() {
+		t.Errorf("%s: %s(%v) != %s(%v)", path, a.Name(), a.Type(), b.Name(),
+			b.Type())
+		return
+	}
+
+	switch va := a.Get().(type) {
+	case MapleCanvas:
+		vb, ok := b.Get().(MapleCanvas)
+		if !ok || va.Width() != vb.Width() || va.Height() != vb.Height() {
+			t.Errorf("%s: canvas %v != %v", path, va, b.Get())
+		}
+	case MapleSound:
+		vb, ok := b.Get().(MapleSound)
+		if !ok || va.Duration() != vb.Duration() {
+			t.Errorf("%s: sound %v != %v", path, va, b.Get())
+		}
+	default:
+		if !reflect.DeepEqual(va, b.Get()) {
+			t.Errorf("%s: %#v != %#v", path, va, b.Get())
+		}
+	}
+
+	ca, cb := a.Children(), b.Children()
+	if len(ca) != len(cb) {
+		t.Errorf("%s: %d children != %d children", path, len(ca), len(cb))
+		return
+	}
+	for i := range ca {
+		compareTestData(t, path+"/"+ca[i].Name(), ca[i], cb[i])
+	}
+}
+
+func TestXmlTree(t *testing.T) {
+	path := testfilesPath()
+
+	dom, err := NewXml(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewXmlTree(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imgs := testImgPaths(tree.Root(), "")
+	if len(imgs) == 0 {
+		t.Fatalf("no imgs found in %s", path)
+	}
+
+	for _, img := range imgs {
+		a, err := dom.Get(img)
+		if err != nil {
+			t.Errorf("Xml: %v", err)
+			continue
+		}
+		b, err := tree.Get(img)
+		if err != nil {
+			t.Errorf("XmlTree: %v", err)
+			continue
+		}
+		compareTestData(t, img, a, b)
+	}
+
+	img, err := tree.Get("Mob.wz/0210100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas := GetImage(img.ChildByPath("move/0"))
+	if canvas == nil || (*canvas).Bounds().Dx() != 237 {
+		t.Errorf("Mob.wz/0210100.img/move/0: failed to load 0.png")
+	}
+}
+
+func TestParseXmlImgErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":     ``,
+		"truncated": `<imgdir name="a.img"><int name="x" value="1"/>`,
+		"int":       `<imgdir name="a.img"><int name="x" value="1.5"/></imgdir>`,
+		"short": `<imgdir name="a.img"><short name="x" value="40000"/>` +
+			`</imgdir>`,
+		"vector": `<imgdir name="a.img"><vector name="v" x="1" y="z"/>` +
+			`</imgdir>`,
+		"roots": `<imgdir name="a.img"/><imgdir name="b.img"/>`,
+	}
+
+	for name, xml := range tests {
+		img, err := ParseXmlImg(strings.NewReader(xml), "")
+		if !errors.Is(err, ErrMalformedImg) {
+			t.Errorf("%s: expected ErrMalformedImg, got %v, %v", name, img,
+				err)
+		}
+	}
+}
+
+func writeTestXmlFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, xml := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(xml), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNewMapleDataProviderXml(t *testing.T) {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/0100100.img.xml": `<?xml version="1.0"?>
+<imgdir name="0100100.img"/>`,
+	})
+
+	x, err := NewMapleDataProvider(filepath.Join(dir, "Mob.wz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := x.(*Xml); !ok {
+		t.Errorf("wz xml directories should be opened as *Xml, got %T", x)
+	}
+}
+
+func TestXmlErrors(t *testing.T) {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/empty.img.xml": ``,
+		"Mob.wz/truncated.img.xml": `<?xml version="1.0"?>
+<imgdir name="truncated.img">
+	<int name="x" value="1"/>`,
+		"Mob.wz/ok.img.xml": `<?xml version="1.0"?>
+<imgdir name="ok.img">
+	<int name="x" value="1"/>
+</imgdir>`,
+	})
+
+	x, err := NewXml(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewXmlTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []MapleDataProvider{x, tree} {
+		for _, name := range []string{"empty", "truncated"} {
+			path := "Mob.wz/" + name + ".img"
+			img, err := p.Get(path)
+			var imgErr *ImgError
+			if img != nil || !errors.Is(err, ErrMalformedImg) ||
+				!errors.As(err, &imgErr) || imgErr.Path != path {
+
+				t.Errorf("%T: %s: got %v, %v", p, path, img, err)
+			}
+		}
+
+		img, err := p.Get("Mob.wz/missing.img")
+		if img != nil || !errors.Is(err, ErrNotFound) {
+			t.Errorf("%T: missing.img: got %v, %v", p, img, err)
+		}
+
+		img, err = p.Get("Mob.wz/ok.img")
+		if err != nil {
+			t.Fatalf("%T: %v", p, err)
+		}
+		if img.ChildByPath("..") != nil || img.ChildByPath("../x") != nil {
+			t.Errorf("%T: .. of the img root is not nil", p)
+		}
+		if parent := img.ChildByPath("x").ChildByPath(".."); parent == nil ||
+			parent.Name() != "ok.img" {
+
+			t.Errorf("%T: x -> .. = %v, expected ok.img", p, parent)
+		}
+	}
+
+	var imgErr *ImgError
+	if _, err := tree.Get("Mob.wz/truncated.img"); !errors.As(err, &imgErr) ||
+		imgErr.Line != 3 {
+
+		t.Errorf("truncated.img: err = %v, expected line 3", err)
+	}
+}
+
+func TestXmlTreeValidate(t *testing.T) {
+	dir := writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/a.img.xml": `<imgdir name="a.img">
+	<int name="x" value="1.5"/>
+	<int name="x" value="2"/>
+	<bool name="y" value="true"/>
+	<vector name="v" x="?" y="0"/>
+</imgdir>`,
+		"Mob.wz/Sub/b.img.xml": `<imgdir name="b.img">`,
+		"Mob.wz/c.img.xml":     `<imgdir name="c.img"/>`,
+	})
+
+	tree, err := NewXmlTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tree.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() = %v, expected a *ValidationError", err)
+	}
+
+	// malformed int, duplicate x, unknown bool, malformed vector, truncated b
+	if len(verr.Errors) != 5 {
+		t.Errorf("Validate() found %d problems, expected 5:\n%v",
+			len(verr.Errors), err)
+	}
+	for _, err := range verr.Errors {
+		var imgErr *ImgError
+		if !errors.As(err, &imgErr) || !errors.Is(err, ErrMalformedImg) ||
+			imgErr.Path == "" || imgErr.Line == 0 {
+
+			t.Errorf("problem %v is not a located ErrMalformedImg", err)
+		}
+	}
+
+	// Get still fails on the first problem only
+	if _, err = tree.Get("Mob.wz/a.img"); !errors.Is(err, ErrMalformedImg) {
+		t.Errorf("a.img: err = %v", err)
+	}
+	if _, err = tree.Get("Mob.wz/c.img"); err != nil {
+		t.Errorf("c.img: %v", err)
+	}
+}
+
+// testXmlFS returns a small wz xml tree with a png canvas as a file system
+func testXmlFS(t *testing.T) fstest.MapFS {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(1, 0, color.NRGBA{0xFF, 0x00, 0x00, 0xFF})
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return fstest.MapFS{
+		"Mob.wz/0100100.img.xml": {Data: []byte(`<?xml version="1.0"?>
+<imgdir name="0100100.img">
+	<imgdir name="stand">
+		<canvas name="0" width="2" height="1"/>
+		<canvas name="1" width="2" height="1">
+			<string name="_inlink" value="stand/0"/>
+		</canvas>
+	</imgdir>
+</imgdir>`)},
+		"Mob.wz/0100100.img/stand/0.png": {Data: b.Bytes()},
+		"Mob.wz/Sub/0100101.img.xml": {
+			Data: []byte(`<imgdir name="0100101.img"/>`)},
+		"readme.txt": {Data: []byte("not wz data")},
+	}
+}
+
+// checkTestXmlFS checks a provider that serves the tree of testXmlFS
+func checkTestXmlFS(t *testing.T, x *XmlTree) {
+	var paths []string
+	err := Walk(x, "", func(path string, e MapleDataEntity, err error) error {
+		if _, isdir := e.(MapleDataDirectoryEntry); isdir || err != nil {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	expected := []string{"", "Mob.wz", "Mob.wz/Sub"}
+	if err != nil || !reflect.DeepEqual(paths, expected) {
+		t.Errorf("directories = %q, %v, expected %q", paths, err, expected)
+	}
+
+	img, err := x.Get("Mob.wz/0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"stand/0", "stand/1"} {
+		canvas := GetImage(img.ChildByPath(name))
+		if canvas == nil {
+			t.Errorf("%s: failed to load the png", name)
+			continue
+		}
+		testCanvasPixels(t, name, *canvas, map[image.Point]color.NRGBA{
+			{1, 0}: {0xFF, 0x00, 0x00, 0xFF},
+		})
+	}
+
+	if _, err = x.Get("Mob.wz/Sub/0100101.img"); err != nil {
+		t.Errorf("Sub/0100101.img: %v", err)
+	}
+	if _, err = x.Get("Mob.wz/missing.img"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing.img: err = %v", err)
+	}
+}
+
+func TestXmlTreeFS(t *testing.T) {
+	x, err := NewXmlTreeFS(testXmlFS(t), "Data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := x.Root().Name(); name != "Data" {
+		t.Errorf("root name = %q, expected Data", name)
+	}
+	checkTestXmlFS(t, x)
+}
+
+func TestOpenXmlTreeZip(t *testing.T) {
+	fsys := testXmlFS(t)
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry,
+		err error) error {
+
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := w.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(fsys[path].Data)
+		return err
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "Data.zip")
+	if err = os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := OpenXmlTreeZip(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	if x.Root().Name() != "Data.zip" {
+		t.Errorf("root name = %s", x.Root().Name())
+	}
+	checkTestXmlFS(t, x)
+}
+
+func benchmarkXmlProvider(b *testing.B, p MapleDataProvider) {
+	imgs := testImgPaths(p.Root(), "")
+
+	var visit func(d MapleData)
+	visit = func(d MapleData) {
+		d.Get()
+		for _, child := range d.Children() {
+			visit(child)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range imgs {
+			img, err := p.Get(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			visit(img)
+		}
+	}
+}
+
+func BenchmarkXml(b *testing.B) {
+	x, err := NewXml(testfilesPath())
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkXmlProvider(b, x)
+}
+
+func BenchmarkXmlTree(b *testing.B) {
+	x, err := NewXmlTree(testfilesPath())
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkXmlProvider(b, x)
+}
@@ -0,0 +1,267 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// xmlElementNames maps maple data types to wz xml element names
+var xmlElementNames = map[MapleDataType]string{
+	PROPERTY: "imgdir",
+	CANVAS:   "canvas",
+	CONVEX:   "convex",
+	SOUND:    "sound",
+	UOL:      "uol",
+	DOUBLE:   "double",
+	FLOAT:    "float",
+	LONG:     "long",
+	INT:      "int",
+	SHORT:    "short",
+	STRING:   "string",
+	VECTOR:   "vector",
+	IMG_0x00: "null",
+}
+
+// xmlImgHeader is the xml declaration of wz xml files
+const xmlImgHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	"\n"
+
+// A SidecarWriter receives the png and sound files of the canvases and
+// sounds of an img written by WriteXmlImg. name is the slash separated path
+// of the file relative to the img's data directory, for example
+// "stand/0.png".
+type SidecarWriter func(name string, data []byte) error
+
+// DirSidecarWriter returns a SidecarWriter that stores the files under the
+// directory dir, creating it as needed
+func DirSidecarWriter(dir string) SidecarWriter {
+	return func(name string, data []byte) error {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+}
+
+// xmlImgWriter holds the state of WriteXmlImg
+type xmlImgWriter struct {
+	w        *bufio.Writer
+	sidecars SidecarWriter // receives the png and sound files, can be nil
+	names    []string      // names of the open elements below the root
+}
+
+// SaveXmlImg writes the img d to path+".xml" and the png and sound files of
+// its canvases and sounds under path, which is the layout read by XmlTree.
+func SaveXmlImg(d MapleData, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path + ".xml")
+	if err != nil {
+		return err
+	}
+
+	err = WriteXmlImg(file, d, DirSidecarWriter(path))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WriteXmlImg writes the img d, which can come from any provider, as wz xml
+// to w. The png and sound files of its canvases and sounds are passed to
+// sidecars, use DirSidecarWriter to store them in the directory ParseXmlImg
+// reads them from, which is the path of the img without the .xml extension.
+// If sidecars is nil, only the xml is written.
+// Canvases that link to other canvases and canvases or sounds whose file
+// doesn't exist are written without a file.
+func WriteXmlImg(w io.Writer, d MapleData, sidecars SidecarWriter) error {
+	x := &xmlImgWriter{w: bufio.NewWriter(w), sidecars: sidecars}
+	x.w.WriteString(xmlImgHeader)
+
+	if err := x.element(d, 0); err != nil {
+		return err
+	}
+	return x.w.Flush()
+}
+
+// attr writes an xml attribute
+func (x *xmlImgWriter) attr(name, value string) {
+	x.w.WriteString(" " + name + `="`)
+	xml.EscapeText(x.w, []byte(value))
+	x.w.WriteByte('"')
+}
+
+// element writes d and its children as xml elements
+func (x *xmlImgWriter) element(d MapleData, depth int) error {
+	tag, ok := xmlElementNames[d.Type()]
+	if !ok {
+		return fmt.Errorf("Cannot write %v data to xml at %s", d.Type(),
+			GetFullDataPath(d))
+	}
+
+	if depth > 0 {
+		x.names = append(x.names, d.Name())
+		defer func() { x.names = x.names[:len(x.names)-1] }()
+	}
+
+	x.w.WriteString(strings.Repeat("\t", depth) + "<" + tag)
+	x.attr("name", d.Name())
+	if err := x.value(d); err != nil {
+		return err
+	}
+
+	children := d.Children()
+	if len(children) == 0 {
+		_, err := x.w.WriteString("/>\n")
+		return err
+	}
+
+	x.w.WriteString(">\n")
+	for _, child := range children {
+		if err := x.element(child, depth+1); err != nil {
+			return err
+		}
+	}
+	_, err := x.w.WriteString(strings.Repeat("\t", depth) + "</" + tag + ">\n")
+	return err
+}
+
+// value writes the attributes that hold the value of d and its png or
+// sound file
+func (x *xmlImgWriter) value(d MapleData) error {
+	val := d.Get()
+
+	switch d.Type() {
+	case DOUBLE, FLOAT:
+		f, ok := toFloat64(val)
+		if !ok {
+			break
+		}
+		bits := 64
+		if d.Type() == FLOAT {
+			bits = 32
+		}
+		x.attr("value", strconv.FormatFloat(f, 'g', -1, bits))
+		return nil
+
+	case LONG, INT, SHORT:
+		if i, ok := toInt64(val); ok {
+			x.attr("value", strconv.FormatInt(i, 10))
+			return nil
+		}
+
+	case STRING, UOL:
+		if s, ok := val.(string); ok {
+			x.attr("value", s)
+			return nil
+		}
+
+	case VECTOR:
+		if pt, ok := val.(image.Point); ok {
+			x.attr("x", strconv.Itoa(pt.X))
+			x.attr("y", strconv.Itoa(pt.Y))
+			return nil
+		}
+
+	case CANVAS:
+		if canvas, ok := val.(MapleCanvas); ok {
+			x.attr("width", strconv.Itoa(canvas.Width()))
+			x.attr("height", strconv.Itoa(canvas.Height()))
+			if hasCanvasLink(d) {
+				return nil // the image is loaded from the link
+			}
+			return x.writeCanvas(d, canvas)
+		}
+
+	case SOUND:
+		if sound, ok := val.(MapleSound); ok {
+			x.attr("length",
+				strconv.FormatInt(sound.Duration().Milliseconds(), 10))
+			return x.writeSound(d, sound)
+		}
+
+	default:
+		return nil // the value is the children or there is no value
+	}
+
+	return dataValueError(d)
+}
+
+// dataValueError reports data whose value doesn't match its type
+func dataValueError(d MapleData) error {
+	return fmt.Errorf("%s: %v data holds a %T value", GetFullDataPath(d),
+		d.Type(), d.Get())
+}
+
+// writeDataFile writes a png or sound file of the current element
+func (x *xmlImgWriter) writeDataFile(ext string, data []byte) error {
+	return x.sidecars(path.Join(x.names...)+ext, data)
+}
+
+// writeCanvas writes the png file of a canvas
+func (x *xmlImgWriter) writeCanvas(d MapleData, canvas MapleCanvas) error {
+	if x.sidecars == nil {
+		return nil
+	}
+
+	img, err := LoadCanvas(canvas)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", GetFullDataPath(d), err)
+	}
+
+	var b bytes.Buffer
+	if err = png.Encode(&b, *img); err != nil {
+		return err
+	}
+	return x.writeDataFile(".png", b.Bytes())
+}
+
+// writeSound writes the sound file of a sound, see ExportSound
+func (x *xmlImgWriter) writeSound(d MapleData, sound MapleSound) error {
+	if x.sidecars == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	err := ExportSound(&b, sound)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", GetFullDataPath(d), err)
+	}
+	return x.writeDataFile(SoundExtension(sound), b.Bytes())
+}
@@ -0,0 +1,257 @@
+/*
+   Copyright 2014-2015 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of maplelib-go.
+   maplelib-go is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   maplelib-go is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with maplelib-go. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wz
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testWriterXml holds every data type that can be written to xml
+const testWriterXml = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<imgdir name="0100100.img">
+	<imgdir name="info">
+		<int name="level" value="-7"/>
+		<short name="speed" value="-30"/>
+		<float name="fs" value="0.1"/>
+		<double name="rate" value="1e-10"/>
+		<string name="name" value="&lt;Snail &amp; &#34;friends&#34;&gt;&#xA;"/>
+		<null name="empty"/>
+		<imgdir name="dir"/>
+	</imgdir>
+	<imgdir name="stand">
+		<canvas name="0" width="1" height="1">
+			<vector name="origin" x="12" y="-34"/>
+		</canvas>
+		<canvas name="1" width="1" height="1">
+			<string name="_inlink" value="stand/0"/>
+		</canvas>
+		<canvas name="2" width="5" height="5"/>
+		<uol name="3" value="0"/>
+	</imgdir>
+	<convex name="foothold">
+		<vector name="0" x="1" y="2"/>
+		<vector name="1" x="3" y="4"/>
+	</convex>
+	<sound name="FloralLife" length="1500"/>
+	<sound name="Title" length="52"/>
+</imgdir>
+`
+
+// readTestImg reads the img Mob.wz/0100100.img from the xml tree in dir
+func readTestImg(t *testing.T, dir string) MapleData {
+	x, err := NewXmlTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := x.Get("Mob.wz/0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// testSoundPayload returns the payload of the sound at path in img
+func testSoundPayload(t *testing.T, img MapleData, path string) []byte {
+	s := GetSound(img.ChildByPath(path))
+	if s == nil {
+		t.Fatalf("%s is not a sound", path)
+	}
+	r, err := s.Reader()
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return data
+}
+
+// writeTestWriterImg writes testWriterXml and its png and sound files to a
+// temporary directory and returns it along with the sound payloads
+func writeTestWriterImg(t *testing.T) (dir string, payload, mp3 []byte) {
+	payload = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	var b bytes.Buffer
+	ExportSound(&b, newImgMapleSound(0, testSoundHeader(testPCMFormat, nil),
+		bytes.NewReader(payload), 0, len(payload), nil))
+
+	mp3 = make([]byte, 417*2)
+	copy(mp3, []byte{0xFF, 0xFB, 0x90, 0x44})
+	copy(mp3[417:], []byte{0xFF, 0xFB, 0x90, 0x44})
+
+	dir = writeTestXmlFiles(t, map[string]string{
+		"Mob.wz/0100100.img.xml":            testWriterXml,
+		"Mob.wz/0100100.img/FloralLife.wav": b.String(),
+		"Mob.wz/0100100.img/Title.mp3":      string(mp3),
+	})
+	writeTestPNG(t, filepath.Join(dir, "Mob.wz", "0100100.img", "stand",
+		"0.png"), color.NRGBA{0xFF, 0x00, 0x00, 0xFF})
+	return
+}
+
+func TestWriteXmlImg(t *testing.T) {
+	src, payload, mp3 := writeTestWriterImg(t)
+	red := color.NRGBA{0xFF, 0x00, 0x00, 0xFF}
+
+	a := readTestImg(t, src)
+	dst := t.TempDir()
+	err := SaveXmlImg(a, filepath.Join(dst, "Mob.wz", "0100100.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := os.ReadFile(filepath.Join(dst, "Mob.wz",
+		"0100100.img.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != testWriterXml {
+		t.Errorf("written xml:\n%s\nexpected:\n%s", written, testWriterXml)
+	}
+
+	b2 := readTestImg(t, dst)
+	compareTestData(t, "0100100.img", a, b2)
+
+	for _, name := range []string{"stand/0", "stand/1"} {
+		img, err := LoadCanvas(b2.ChildByPath(name).Get().(MapleCanvas))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		testCanvasPixels(t, name, *img, map[image.Point]color.NRGBA{
+			{0, 0}: red,
+		})
+	}
+	if _, err = os.Stat(filepath.Join(dst, "Mob.wz", "0100100.img", "stand",
+		"1.png")); !os.IsNotExist(err) {
+
+		t.Error("linked canvas stand/1 should not have a png file")
+	}
+
+	if !bytes.Equal(testSoundPayload(t, b2, "FloralLife"), payload) {
+		t.Error("FloralLife: payload mismatch")
+	}
+	if !bytes.Equal(testSoundPayload(t, b2, "Title"), mp3) {
+		t.Error("Title: payload mismatch")
+	}
+}
+
+func TestWriteXmlImgSidecars(t *testing.T) {
+	src, payload, mp3 := writeTestWriterImg(t)
+	a := readTestImg(t, src)
+
+	// write everything to memory and read it back from there
+	fsys := fstest.MapFS{}
+	var b bytes.Buffer
+	err := WriteXmlImg(&b, a, func(name string, data []byte) error {
+		fsys["Mob.wz/0100100.img/"+name] = &fstest.MapFile{Data: data}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["Mob.wz/0100100.img.xml"] = &fstest.MapFile{Data: b.Bytes()}
+
+	var names []string
+	for name := range fsys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{
+		"Mob.wz/0100100.img.xml",
+		"Mob.wz/0100100.img/FloralLife.wav",
+		"Mob.wz/0100100.img/Title.mp3",
+		"Mob.wz/0100100.img/stand/0.png",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("written files = %q, expected %q", names, expected)
+	}
+
+	x, err := NewXmlTreeFS(fsys, "Data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := x.Get("Mob.wz/0100100.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareTestData(t, "0100100.img", a, img)
+	if GetImage(img.ChildByPath("stand/0")) == nil {
+		t.Error("stand/0: failed to load the png")
+	}
+	if !bytes.Equal(testSoundPayload(t, img, "FloralLife"), payload) ||
+		!bytes.Equal(testSoundPayload(t, img, "Title"), mp3) {
+
+		t.Error("sound payload mismatch")
+	}
+}
+
+func TestWriteXmlImgTestfiles(t *testing.T) {
+	x, err := NewXmlTree(testfilesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, path := range testImgPaths(x.Root(), "") {
+		a, err := x.Get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var first bytes.Buffer
+		err = WriteXmlImg(&first, a, DirSidecarWriter(filepath.Join(dir, path)))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		b, err := ParseXmlImg(strings.NewReader(first.String()),
+			filepath.Join(dir, path))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		compareTestData(t, path, a, b)
+
+		var second bytes.Buffer
+		err = WriteXmlImg(&second, b, DirSidecarWriter(t.TempDir()))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if first.String() != second.String() {
+			t.Errorf("%s: writing the img again gives a different xml", path)
+		}
+	}
+}
+
+func TestWriteXmlImgInvalid(t *testing.T) {
+	img := NewWZIMGEntry("x.img", PROPERTY, nil)
+	img.addChild(NewWZIMGEntry("bad", EXTENDED, img))
+
+	var b bytes.Buffer
+	if err := WriteXmlImg(&b, img, nil); err == nil {
+		t.Error("expected an error for EXTENDED data")
+	}
+}